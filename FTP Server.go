@@ -6,10 +6,14 @@ import (
 	"FTPServ/FTPServConfig"
 	"FTPServ/FTPServer"
 	"FTPServ/Logger"
+	"FTPServ/ftpfs"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+
+	//database/sql driver of "sqlite" authentication backend (FTPAuth.DefaultSQLDriver)
+	_ "modernc.org/sqlite"
 )
 
 func main() {
@@ -119,6 +123,29 @@ func main() {
 			fmt.Println("Setting home dir error: ", err)
 		}
 		fmt.Println("FTP Homedir changed to: ", value)
+	case "-la":
+		value := strings.TrimSpace(argsString[3:])
+		if err = config.SetListenAddress(value); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Listen address set to: ", config.Config.ListenAddress)
+	case "-fs":
+		value := strings.TrimSpace(argsString[3:])
+		if !ftpfs.DriverRegistered(value) {
+			fmt.Println("Unknown storage: ", value)
+			return
+		}
+		config.SetStorage(value)
+		fmt.Println("Storage set to: ", config.Config.Storage)
+	case "-ow":
+		value := strings.TrimSpace(argsString[3:])
+		if !ftpfs.ValidOverwritePolicy(value) {
+			fmt.Println("Unknown overwrite policy: ", value)
+			return
+		}
+		config.SetOverwritePolicy(value)
+		fmt.Println("Overwrite policy set to: ", config.Config.OverwritePolicy)
 	case "-rs":
 		FTPServConfig.CreateConfig()
 		fmt.Println("Loaded default server configuration")
@@ -183,7 +210,9 @@ func main() {
 		return
 	}
 	config.SaveConfig()
-	users.Save()
+	if err := users.Save(); err != nil {
+		Logger.Log("func main(): ", err)
+	}
 }
 
 func readAfterStart(stopServer *(chan bool)) {
@@ -201,6 +230,9 @@ func readAfterStart(stopServer *(chan bool)) {
 func showHelp() {
 	fmt.Println("PN FTP Server Configurator commands:\r\n'-sp port_num' - set message port\r\n'-pp port_numlow port_numhigh' - set passive mode data port range\r\n'-wd path_to_dir' - set root directory\r\n'-an (true|false) || (0|1) - set anonymous user allowed\r\n'-mp' - set num of max peers\r\n'-rs' - reset config to default\r\n'-pd' - prints config file")
 	fmt.Println("'-bs size' - set send and receive buffer size (bytes)")
+	fmt.Println("'-la address' - set IPv4 or IPv6 listen address (without address - listen on all addresses)")
+	fmt.Println("'-fs (local|memory|s3)' - set storage of user files: FTP root folder, memory (lost on server stop) or S3 bucket (S3 settings in config.json)")
+	fmt.Println("'-ow (reject|overwrite|rename)' - set what upload does with existing file: refuse, replace or save with new name (name.1.ext)")
 	fmt.Println("PN FTP Server users commands: \r\nUnder construction")
 	fmt.Println("'-adduser Username Password Folder' - add user with specified name, password and root folder (/ is FTP root folder)")
 	fmt.Println("'-rmuser Username' - remove specified user")
@@ -3,14 +3,13 @@ package FTPAuth
 
 import (
 	"crypto"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 )
 
 const usersFileName string = "users.json"
@@ -19,12 +18,34 @@ const hash crypto.Hash = crypto.SHA256
 type Users struct {
 	Users     []User
 	usersFile *os.File
+	//lock guards Users changed by connections (password rehash)
+	lock sync.Mutex
 }
 
 type User struct {
 	UserName string
 	Password string
 	Folder   string
+	//RecursiveDelete allows removing non-empty directories (SITE RMDIR -R)
+	RecursiveDelete bool
+	//Mounts are folders shown in user tree in addition to Folder
+	Mounts []Mount
+	//OverwritePolicy - STOR of existing file: "reject", "overwrite" or "rename", empty - server policy
+	OverwritePolicy string
+	//QuotaBytes, QuotaFiles - limits of space and number of files: 0 - server default, < 0 - no limit
+	QuotaBytes int64
+	QuotaFiles int64
+	//Permissions - commands allowed to user (see PermissionList...), nil - DefaultPermissions of server
+	Permissions []string
+}
+
+//Mount shows Folder of Storage at Path of user tree. Folder of "local" storage (default) is a directory of
+//local file system, relative folders are in FTP root folder. Folder of other storages is a folder in storage
+type Mount struct {
+	Path     string
+	Folder   string
+	Storage  string
+	ReadOnly bool
 }
 
 //Returns UsersList configuration, err in couldn't load
@@ -43,19 +64,9 @@ func LoadUsersList() (*Users, error) {
 	return Users, nil
 }
 
-//Hashes pswd
-func HashPswd(pswd *string) {
-	hash := sha256.New()
-	*pswd = hex.EncodeToString(hash.Sum([]byte(*pswd)))
-}
-func (U *User) CheckPswd(pswd string) bool {
-	HashPswd(&pswd)
-	if strings.Compare(pswd, U.Password) == 0 {
-		return true
-	}
-	return false
-}
 func (U *Users) CheckUserName(userName string) *User {
+	U.lock.Lock()
+	defer U.lock.Unlock()
 	for _, usr := range U.Users {
 		if userName == usr.UserName {
 			return &usr
@@ -73,6 +84,8 @@ func (U *Users) AddNewUser(userName, password, folder string) error {
 	return nil
 }
 func (U *Users) Save() error {
+	U.lock.Lock()
+	defer U.lock.Unlock()
 	if U.usersFile == nil {
 		file, err := os.Create(usersFileName)
 		if err != nil {
@@ -84,9 +97,29 @@ func (U *Users) Save() error {
 	if err != nil {
 		return errors.New(fmt.Sprint("func SaveUsers() error: ", err))
 	}
-	ioutil.WriteFile(U.usersFile.Name(), output, os.ModeAppend)
 	U.usersFile.Close()
-	return err
+	if err := ioutil.WriteFile(U.usersFile.Name(), output, os.ModeAppend); err != nil {
+		return errors.New(fmt.Sprint("func SaveUsers() error: ", err))
+	}
+	return nil
+}
+
+//RehashPswd replaces legacy password hash of user with current one (pswd is checked already) and saves users
+func (U *Users) RehashPswd(userName string, pswd string) error {
+	HashPswd(&pswd)
+	U.lock.Lock()
+	found := false
+	for i := range U.Users {
+		if U.Users[i].UserName == userName {
+			U.Users[i].Password = pswd
+			found = true
+		}
+	}
+	U.lock.Unlock()
+	if !found {
+		return errors.New("No such user specified")
+	}
+	return U.Save()
 }
 func (U *Users) RemoveUser(user *User) error {
 	usrIndex := -1
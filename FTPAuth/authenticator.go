@@ -0,0 +1,167 @@
+// Authentication backends
+package FTPAuth
+
+import (
+	"FTPServ/FTPServConfig"
+	"FTPServ/Logger"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+//Errors of authenticators. Chain of authenticators asks next one only if user is unknown
+var (
+	ErrUnknownUser   = errors.New("Unknown user")
+	ErrWrongPassword = errors.New("Wrong password")
+)
+
+//Authenticator is a source of FTP users
+type Authenticator interface {
+	//Name of authenticator for logs
+	Name() string
+	//Lookup returns profile of user, ErrUnknownUser if there is no such user
+	Lookup(userName string) (*User, error)
+	//Authenticate checks credentials and returns profile of user.
+	//Errors are ErrUnknownUser, ErrWrongPassword or errors of backend
+	Authenticate(userName string, pswd string) (*User, error)
+}
+
+//AuthBackendFactory makes authenticator from config. users is users.json list
+type AuthBackendFactory func(config *FTPServConfig.ConfigStorage, users *Users) (Authenticator, error)
+
+//DefaultAuthBackend is used if AuthBackends is not set in config
+const DefaultAuthBackend = "json"
+
+var authBackends = make(map[string]AuthBackendFactory)
+var authBackendsLock sync.RWMutex
+
+func init() {
+	RegisterAuthBackend(DefaultAuthBackend, func(config *FTPServConfig.ConfigStorage, users *Users) (Authenticator, error) {
+		if users == nil {
+			return nil, errors.New("Users list is not loaded")
+		}
+		return users, nil
+	})
+	RegisterAuthBackend("htpasswd", newHtpasswdAuthenticatorFromConfig)
+	RegisterAuthBackend("sqlite", newSQLAuthenticatorFromConfig)
+	RegisterAuthBackend("ldap", newLDAPAuthenticatorFromConfig)
+	RegisterAuthBackend("command", newCommandAuthenticatorFromConfig)
+	RegisterAuthBackend("http", newHTTPAuthenticatorFromConfig)
+}
+
+//RegisterAuthBackend adds (or replaces) backend which may be used in AuthBackends of config
+func RegisterAuthBackend(name string, factory AuthBackendFactory) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if len(name) == 0 || factory == nil {
+		return errors.New(fmt.Sprint("RegisterAuthBackend: wrong backend \"", name, "\""))
+	}
+	authBackendsLock.Lock()
+	authBackends[name] = factory
+	authBackendsLock.Unlock()
+	return nil
+}
+
+//NewAuthenticator returns chain of backends listed in AuthBackends of config (users.json if empty)
+func NewAuthenticator(config *FTPServConfig.ConfigStorage, users *Users) (Authenticator, error) {
+	names := config.AuthBackends
+	if len(names) == 0 {
+		names = []string{DefaultAuthBackend}
+	}
+	chain := make(AuthChain, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		authBackendsLock.RLock()
+		factory, ok := authBackends[name]
+		authBackendsLock.RUnlock()
+		if !ok {
+			return nil, errors.New(fmt.Sprint("Unknown authentication backend \"", name, "\""))
+		}
+		backend, err := factory(config, users)
+		if err != nil {
+			return nil, errors.New(fmt.Sprint("Authentication backend ", name, " error: ", err))
+		}
+		chain = append(chain, backend)
+	}
+	return chain, nil
+}
+
+//AuthChain asks authenticators in order. First authenticator knowing user decides, next authenticator is asked
+//only if user is unknown. Errors of backends are logged and stop the chain: user of backend which is down
+//is not checked by next backend (which may know other user of the same name)
+type AuthChain []Authenticator
+
+func (chain AuthChain) Name() string {
+	names := make([]string, len(chain))
+	for i, backend := range chain {
+		names[i] = backend.Name()
+	}
+	return strings.Join(names, ",")
+}
+func (chain AuthChain) Lookup(userName string) (*User, error) {
+	for _, backend := range chain {
+		user, err := backend.Lookup(userName)
+		if err == nil {
+			return user, nil
+		}
+		if err != ErrUnknownUser {
+			Logger.Log("Authentication backend ", backend.Name(), " error: ", err)
+			return nil, err
+		}
+	}
+	return nil, ErrUnknownUser
+}
+func (chain AuthChain) Authenticate(userName string, pswd string) (*User, error) {
+	for _, backend := range chain {
+		user, err := backend.Authenticate(userName, pswd)
+		if err == nil || err == ErrWrongPassword {
+			return user, err
+		}
+		if err != ErrUnknownUser {
+			Logger.Log("Authentication backend ", backend.Name(), " error: ", err)
+			return nil, err
+		}
+	}
+	return nil, ErrUnknownUser
+}
+
+//Name, Lookup and Authenticate make users.json list an Authenticator
+func (U *Users) Name() string {
+	return DefaultAuthBackend
+}
+func (U *Users) Lookup(userName string) (*User, error) {
+	user := U.CheckUserName(userName)
+	if user == nil {
+		return nil, ErrUnknownUser
+	}
+	return user, nil
+}
+
+//Authenticate checks password, legacy password hash is replaced with current one
+func (U *Users) Authenticate(userName string, pswd string) (*User, error) {
+	user := U.CheckUserName(userName)
+	if user == nil {
+		return nil, ErrUnknownUser
+	}
+	if !user.CheckPswd(pswd) {
+		return nil, ErrWrongPassword
+	}
+	if user.PswdNeedsRehash() {
+		if err := U.RehashPswd(userName, pswd); err != nil {
+			Logger.Log("Couldn't rehash password of user ", userName, ": ", err)
+		} else {
+			Logger.Log("Password hash of user ", userName, " upgraded")
+		}
+	}
+	return user, nil
+}
+
+//userFolder returns folder of user of backend without folders: template with "{user}" replaced by user name
+//("/{user}" if template is empty)
+func userFolder(template string, userName string) string {
+	if len(strings.TrimSpace(template)) == 0 {
+		template = "/{user}"
+	}
+	return path.Clean(fmt.Sprint("/", strings.Replace(template, "{user}", userName, -1)))
+}
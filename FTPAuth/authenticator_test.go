@@ -0,0 +1,201 @@
+package FTPAuth
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+//testBackend knows users by passwords, err is returned for all users if it is set
+type testBackend struct {
+	name  string
+	users map[string]string
+	err   error
+	calls int
+}
+
+func (b *testBackend) Name() string {
+	return b.name
+}
+func (b *testBackend) Lookup(userName string) (*User, error) {
+	b.calls++
+	if b.err != nil {
+		return nil, b.err
+	}
+	if _, ok := b.users[userName]; !ok {
+		return nil, ErrUnknownUser
+	}
+	return &User{UserName: userName, Folder: fmt.Sprint("/", b.name)}, nil
+}
+func (b *testBackend) Authenticate(userName string, pswd string) (*User, error) {
+	user, err := b.Lookup(userName)
+	if err != nil {
+		return nil, err
+	}
+	if b.users[userName] != pswd {
+		return nil, ErrWrongPassword
+	}
+	return user, nil
+}
+
+var errBackendDown = errors.New("backend is down")
+
+func TestAuthChain(t *testing.T) {
+	tests := []struct {
+		name      string
+		first     map[string]string
+		firstErr  error
+		userName  string
+		pswd      string
+		folder    string
+		err       error
+		askSecond bool
+	}{
+		{"first knows user", map[string]string{"bob": "one"}, nil, "bob", "one", "/first", nil, false},
+		{"second knows user", map[string]string{"eve": "one"}, nil, "bob", "two", "/second", nil, true},
+		{"wrong password in first", map[string]string{"bob": "one"}, nil, "bob", "two", "", ErrWrongPassword, false},
+		{"first is down", nil, errBackendDown, "bob", "two", "", errBackendDown, false},
+		{"unknown user", nil, nil, "alice", "two", "", ErrUnknownUser, true},
+	}
+	for _, test := range tests {
+		first := &testBackend{name: "first", users: test.first, err: test.firstErr}
+		second := &testBackend{name: "second", users: map[string]string{"bob": "two"}}
+		chain := AuthChain{first, second}
+		user, err := chain.Authenticate(test.userName, test.pswd)
+		if err != test.err {
+			t.Errorf("%s: Authenticate error %v, want %v", test.name, err, test.err)
+		}
+		if err == nil && user.Folder != test.folder {
+			t.Errorf("%s: user of %s, want %s", test.name, user.Folder, test.folder)
+		}
+		if asked := second.calls > 0; asked != test.askSecond {
+			t.Errorf("%s: second backend asked: %v", test.name, asked)
+		}
+		second.calls = 0
+		user, err = chain.Lookup(test.userName)
+		if test.err == ErrWrongPassword {
+			if err != nil || user.Folder != "/first" {
+				t.Errorf("%s: Lookup returned %v, %v", test.name, user, err)
+			}
+		} else if err != test.err {
+			t.Errorf("%s: Lookup error %v, want %v", test.name, err, test.err)
+		}
+		if asked := second.calls > 0; asked != test.askSecond {
+			t.Errorf("%s: second backend asked by Lookup: %v", test.name, asked)
+		}
+	}
+	if name := (AuthChain{&testBackend{name: "a"}, &testBackend{name: "b"}}).Name(); name != "a,b" {
+		t.Errorf("chain name %q", name)
+	}
+}
+
+//testSQLUsers is table of test SQL driver: user name - password hash and folder (nil - NULL).
+//Query of user testSQLBroken fails
+var testSQLUsers = map[string][]driver.Value{}
+
+const testSQLBroken = "broken"
+
+type testSQLDriver struct{}
+type testSQLConn struct{}
+type testSQLStmt struct{}
+type testSQLRows struct {
+	rows [][]driver.Value
+}
+
+func init() {
+	sql.Register("ftpauth-test", testSQLDriver{})
+}
+func (testSQLDriver) Open(name string) (driver.Conn, error) {
+	return testSQLConn{}, nil
+}
+func (testSQLConn) Prepare(query string) (driver.Stmt, error) {
+	if query != DefaultSQLUsersQuery {
+		return nil, errors.New(fmt.Sprint("unexpected query ", query))
+	}
+	return testSQLStmt{}, nil
+}
+func (testSQLConn) Close() error {
+	return nil
+}
+func (testSQLConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+func (testSQLStmt) Close() error {
+	return nil
+}
+func (testSQLStmt) NumInput() int {
+	return 1
+}
+func (testSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("exec is not supported")
+}
+func (testSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	userName, _ := args[0].(string)
+	if userName == testSQLBroken {
+		return nil, errBackendDown
+	}
+	rows := &testSQLRows{}
+	if row, ok := testSQLUsers[userName]; ok {
+		rows.rows = append(rows.rows, row)
+	}
+	return rows, nil
+}
+func (r *testSQLRows) Columns() []string {
+	return []string{"password", "folder"}
+}
+func (r *testSQLRows) Close() error {
+	return nil
+}
+func (r *testSQLRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSQLAuthenticator(t *testing.T) {
+	if _, err := NewSQLAuthenticator("no-such-driver", "users.db", "", ""); err == nil {
+		t.Errorf("authenticator made for driver not linked into server")
+	}
+	bobHash := "secret"
+	HashPswd(&bobHash)
+	testSQLUsers["bob"] = []driver.Value{bobHash, nil}
+	testSQLUsers["alice"] = []driver.Value{legacyHash("alice"), "/data/alice"}
+	auth, err := NewSQLAuthenticator("ftpauth-test", "users.db", "", "/home/{user}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auth.DB.Close()
+	tests := []struct {
+		userName string
+		pswd     string
+		folder   string
+		err      error
+	}{
+		{"bob", "secret", "/home/bob", nil},
+		{"bob", "wrong", "", ErrWrongPassword},
+		{"alice", "alice", "/data/alice", nil},
+		{"carol", "secret", "", ErrUnknownUser},
+		{testSQLBroken, "secret", "", errBackendDown},
+	}
+	for _, test := range tests {
+		user, err := auth.Authenticate(test.userName, test.pswd)
+		if err != test.err {
+			t.Errorf("%s: error %v, want %v", test.userName, err, test.err)
+			continue
+		}
+		if err == nil && (user.UserName != test.userName || user.Folder != test.folder) {
+			t.Errorf("%s: user %s with folder %s, want %s", test.userName, user.UserName, user.Folder, test.folder)
+		}
+	}
+	//error of database stops chain, user of next backend is not logged in
+	json := &testBackend{name: "json", users: map[string]string{testSQLBroken: "secret"}}
+	if _, err := (AuthChain{auth, json}).Authenticate(testSQLBroken, "secret"); err != errBackendDown || json.calls != 0 {
+		t.Errorf("chain after database error: %v, next backend asked %d times", err, json.calls)
+	}
+}
@@ -0,0 +1,195 @@
+// External authentication backends: program or HTTP endpoint
+package FTPAuth
+
+import (
+	"FTPServ/FTPServConfig"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+//DefaultExternalAuthTimeout limits run of program or HTTP request
+const DefaultExternalAuthTimeout = 10 * time.Second
+
+//Actions of external authentication request
+const (
+	ExternalAuthenticate = "authenticate"
+	ExternalLookup       = "lookup"
+)
+
+//ExternalAuthRequest is sent to program (stdin) or HTTP endpoint (POST body) as JSON. Password is empty for lookup
+type ExternalAuthRequest struct {
+	Action   string
+	UserName string
+	Password string
+}
+
+//ExternalAuthResponse is JSON answer of program or endpoint (names of fields are case insensitive).
+//Allow - credentials are right (lookup needs only known user), UnknownUser - next backend is asked. Folder is user folder (folder template
+//of config if empty), Permissions are permissions of user (DefaultPermissions of server if there are none),
+//QuotaBytes and QuotaFiles are limits of user
+type ExternalAuthResponse struct {
+	Allow       bool
+	UnknownUser bool
+	Folder      string
+	Permissions []string
+	QuotaBytes  int64
+	QuotaFiles  int64
+	Error       string
+}
+
+//externalAuthCall asks external authenticator
+type externalAuthCall func(ctx context.Context, request []byte) ([]byte, error)
+
+//ExternalAuthenticator delegates checks of users to program or HTTP endpoint
+type ExternalAuthenticator struct {
+	name    string
+	call    externalAuthCall
+	Folder  string
+	Timeout time.Duration
+}
+
+//NewCommandAuthenticator runs program with args for each request: request is written to stdin,
+//response is read from stdout. Program must exit with code 0
+func NewCommandAuthenticator(args []string, folder string, timeout time.Duration) (*ExternalAuthenticator, error) {
+	if len(args) == 0 || len(strings.TrimSpace(args[0])) == 0 {
+		return nil, errors.New("program is not set")
+	}
+	program, err := exec.LookPath(args[0])
+	if err != nil {
+		return nil, err
+	}
+	call := func(ctx context.Context, request []byte) ([]byte, error) {
+		cmd := exec.CommandContext(ctx, program, args[1:]...)
+		cmd.Stdin = bytes.NewReader(request)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, errors.New(fmt.Sprint(args[0], ": ", err, " ", strings.TrimSpace(stderr.String())))
+		}
+		return output, nil
+	}
+	return &ExternalAuthenticator{name: fmt.Sprint("command(", args[0], ")"), call: call, Folder: folder, Timeout: timeout}, nil
+}
+
+//NewHTTPAuthenticator posts requests to url, response must have status 200.
+//token is sent as "Authorization: Bearer token" if it isn't empty
+func NewHTTPAuthenticator(url string, token string, folder string, timeout time.Duration) (*ExternalAuthenticator, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, errors.New(fmt.Sprint("wrong URL \"", url, "\""))
+	}
+	call := func(ctx context.Context, request []byte) ([]byte, error) {
+		httpRequest, err := http.NewRequest("POST", url, bytes.NewReader(request))
+		if err != nil {
+			return nil, err
+		}
+		httpRequest.Header.Set("Content-Type", "application/json")
+		if len(token) > 0 {
+			httpRequest.Header.Set("Authorization", fmt.Sprint("Bearer ", token))
+		}
+		response, err := http.DefaultClient.Do(httpRequest.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+		body, err := ioutil.ReadAll(io.LimitReader(response.Body, 1<<20))
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode != http.StatusOK {
+			return nil, errors.New(fmt.Sprint(url, ": ", response.Status))
+		}
+		return body, nil
+	}
+	return &ExternalAuthenticator{name: fmt.Sprint("http(", url, ")"), call: call, Folder: folder, Timeout: timeout}, nil
+}
+func newCommandAuthenticatorFromConfig(config *FTPServConfig.ConfigStorage, users *Users) (Authenticator, error) {
+	if len(config.ExternalAuthCommand) == 0 {
+		return nil, errors.New("ExternalAuthCommand is not set")
+	}
+	return NewCommandAuthenticator(config.ExternalAuthCommand, config.AuthUserFolder, time.Duration(config.ExternalAuthTimeout)*time.Second)
+}
+func newHTTPAuthenticatorFromConfig(config *FTPServConfig.ConfigStorage, users *Users) (Authenticator, error) {
+	if len(config.ExternalAuthURL) == 0 {
+		return nil, errors.New("ExternalAuthURL is not set")
+	}
+	return NewHTTPAuthenticator(config.ExternalAuthURL, config.ExternalAuthToken, config.AuthUserFolder, time.Duration(config.ExternalAuthTimeout)*time.Second)
+}
+
+func (auth *ExternalAuthenticator) Name() string {
+	return auth.name
+}
+
+//Lookup returns user known to external authenticator, Allow isn't checked
+func (auth *ExternalAuthenticator) Lookup(userName string) (*User, error) {
+	response, err := auth.ask(ExternalAuthRequest{Action: ExternalLookup, UserName: userName})
+	if err != nil {
+		return nil, err
+	}
+	return auth.user(userName, response)
+}
+func (auth *ExternalAuthenticator) Authenticate(userName string, pswd string) (*User, error) {
+	response, err := auth.ask(ExternalAuthRequest{Action: ExternalAuthenticate, UserName: userName, Password: pswd})
+	if err != nil {
+		return nil, err
+	}
+	if !response.Allow {
+		return nil, ErrWrongPassword
+	}
+	return auth.user(userName, response)
+}
+
+//ask sends request and returns response of known user
+func (auth *ExternalAuthenticator) ask(request ExternalAuthRequest) (*ExternalAuthResponse, error) {
+	if len(strings.TrimSpace(request.UserName)) == 0 {
+		return nil, ErrUnknownUser
+	}
+	timeout := auth.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExternalAuthTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	output, err := auth.call(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	var response ExternalAuthResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		return nil, errors.New(fmt.Sprint("wrong response: ", err))
+	}
+	if len(response.Error) > 0 {
+		return nil, errors.New(response.Error)
+	}
+	if response.UnknownUser {
+		return nil, ErrUnknownUser
+	}
+	return &response, nil
+}
+
+//user makes profile of user from response
+func (auth *ExternalAuthenticator) user(userName string, response *ExternalAuthResponse) (*User, error) {
+	user := &User{UserName: userName, Folder: userFolder(auth.Folder, userName), QuotaBytes: response.QuotaBytes, QuotaFiles: response.QuotaFiles}
+	if len(strings.TrimSpace(response.Folder)) > 0 {
+		user.Folder = strings.TrimSpace(response.Folder)
+	}
+	for _, permission := range response.Permissions {
+		if err := user.Grant(permission); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
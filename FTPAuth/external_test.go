@@ -0,0 +1,186 @@
+package FTPAuth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//testExternalBackend is external authenticator answering with response after delay, request returns last request
+type testExternalBackend struct {
+	auth    *ExternalAuthenticator
+	request func() ExternalAuthRequest
+}
+
+//newTestExternalBackends returns command and HTTP authenticators with the same answer
+func newTestExternalBackends(t *testing.T, response string, delay time.Duration) []testExternalBackend {
+	t.Helper()
+	const timeout = 500 * time.Millisecond
+	readRequest := func(data []byte) ExternalAuthRequest {
+		var request ExternalAuthRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			t.Errorf("request %q: %v", data, err)
+		}
+		return request
+	}
+
+	requestFile := filepath.Join(t.TempDir(), "request.json")
+	sleep := ""
+	if delay > 0 {
+		sleep = fmt.Sprint(delay.Seconds())
+	}
+	//program replaced with sleep is killed on timeout
+	command, err := NewCommandAuthenticator([]string{"sh", "-c", `cat > "$1"; if [ -n "$3" ]; then exec sleep "$3"; fi; printf "%s" "$2"`,
+		"sh", requestFile, response, sleep}, "/ext/{user}", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lastRequest []byte
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		lastRequest = body
+		lock.Unlock()
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "wrong token", http.StatusForbidden)
+			return
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		if response == "500" {
+			http.Error(w, "failure", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	httpAuth, err := NewHTTPAuthenticator(server.URL, "token", "/ext/{user}", timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []testExternalBackend{
+		{command, func() ExternalAuthRequest {
+			data, _ := ioutil.ReadFile(requestFile)
+			return readRequest(data)
+		}},
+		{httpAuth, func() ExternalAuthRequest {
+			lock.Lock()
+			defer lock.Unlock()
+			return readRequest(lastRequest)
+		}},
+	}
+}
+
+func TestExternalAuthenticator(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		delay    time.Duration
+		folder   string
+		err      error
+		//lookupErr is error of Lookup if it differs from error of Authenticate
+		lookupErr error
+		//backendErr - error of backend, chain stops
+		backendErr bool
+	}{
+		{"allowed", `{"Allow": true, "Folder": "/data/bob", "Permissions": ["list", "download"], "QuotaBytes": 100}`, 0, "/data/bob", nil, nil, false},
+		{"names are case insensitive", `{"allow": true, "quotaFiles": 5}`, 0, "/ext/bob", nil, nil, false},
+		{"wrong password", `{"allow": false}`, 0, "/ext/bob", ErrWrongPassword, nil, false},
+		{"unknown user", `{"unknownUser": true, "allow": true}`, 0, "", ErrUnknownUser, ErrUnknownUser, false},
+		{"error field", `{"error": "directory is down", "allow": true}`, 0, "", nil, nil, true},
+		{"bad JSON", `allow`, 0, "", nil, nil, true},
+		{"unknown permission", `{"allow": true, "permissions": ["fly"]}`, 0, "", nil, nil, true},
+		{"HTTP status", `500`, 0, "", nil, nil, true},
+		{"timeout", `{"allow": true}`, 5 * time.Second, "", nil, nil, true},
+	}
+	for _, test := range tests {
+		for _, backend := range newTestExternalBackends(t, test.response, test.delay) {
+			name := fmt.Sprint(backend.auth.Name(), ": ", test.name)
+			if strings.HasPrefix(name, "command") && test.response == "500" {
+				//status is sent by HTTP backend only
+				continue
+			}
+			start := time.Now()
+			user, err := backend.auth.Authenticate("bob", "secret")
+			if time.Since(start) > 2*time.Second {
+				t.Errorf("%s: Authenticate took %v", name, time.Since(start))
+			}
+			if request := backend.request(); request != (ExternalAuthRequest{Action: ExternalAuthenticate, UserName: "bob", Password: "secret"}) {
+				t.Errorf("%s: request %+v", name, request)
+			}
+			switch {
+			case test.backendErr:
+				if err == nil || err == ErrUnknownUser || err == ErrWrongPassword {
+					t.Errorf("%s: Authenticate error %v, want error of backend", name, err)
+				}
+			case err != test.err:
+				t.Errorf("%s: Authenticate error %v, want %v", name, err, test.err)
+			case err == nil && (user.UserName != "bob" || user.Folder != test.folder):
+				t.Errorf("%s: user %s with folder %s", name, user.UserName, user.Folder)
+			}
+			if test.name == "error field" && (err == nil || err.Error() != "directory is down") {
+				t.Errorf("%s: error %v", name, err)
+			}
+
+			//lookup needs known user only, Allow isn't checked
+			user, err = backend.auth.Lookup("bob")
+			if request := backend.request(); request != (ExternalAuthRequest{Action: ExternalLookup, UserName: "bob"}) {
+				t.Errorf("%s: lookup request %+v", name, request)
+			}
+			switch {
+			case test.backendErr:
+				if err == nil || err == ErrUnknownUser || err == ErrWrongPassword {
+					t.Errorf("%s: Lookup error %v, want error of backend", name, err)
+				}
+			case err != test.lookupErr:
+				t.Errorf("%s: Lookup error %v, want %v", name, err, test.lookupErr)
+			case err == nil && user.Folder != test.folder:
+				t.Errorf("%s: Lookup folder %s, want %s", name, user.Folder, test.folder)
+			}
+
+			//unknown user is asked from next backend, errors of backend stop chain
+			next := &testBackend{name: "json", users: map[string]string{"bob": "secret"}}
+			user, err = (AuthChain{backend.auth, next}).Authenticate("bob", "secret")
+			if asked := next.calls > 0; asked != (test.err == ErrUnknownUser) {
+				t.Errorf("%s: next backend asked: %v", name, asked)
+			}
+			if test.err == ErrUnknownUser && (err != nil || user.Folder != "/json") {
+				t.Errorf("%s: user of next backend: %v, %v", name, user, err)
+			}
+		}
+	}
+}
+
+func TestExternalAuthenticatorPermissions(t *testing.T) {
+	for _, backend := range newTestExternalBackends(t, `{"allow": true, "permissions": ["list", "download"], "quotaBytes": 100, "quotaFiles": 5}`, 0) {
+		user, err := backend.auth.Authenticate("bob", "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !user.Can(PermissionDownload, nil) || user.Can(PermissionUpload, []string{PermissionAll}) {
+			t.Errorf("%s: permissions %q", backend.auth.Name(), user.Permissions)
+		}
+		if user.QuotaBytes != 100 || user.QuotaFiles != 5 {
+			t.Errorf("%s: quota %d bytes, %d files", backend.auth.Name(), user.QuotaBytes, user.QuotaFiles)
+		}
+	}
+	if _, err := NewCommandAuthenticator(nil, "", 0); err == nil {
+		t.Errorf("command authenticator without program")
+	}
+	if _, err := NewHTTPAuthenticator("ftp://auth", "", "", 0); err == nil {
+		t.Errorf("HTTP authenticator with ftp URL")
+	}
+}
@@ -0,0 +1,167 @@
+// htpasswd file authentication backend
+package FTPAuth
+
+import (
+	"FTPServ/FTPServConfig"
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//HtpasswdAuthenticator checks users of Apache htpasswd file ("name:hash" lines). Supported hashes:
+//"$2y$", "$2b$" and "$2a$" (bcrypt, "htpasswd -B"), "{SHA}" and "$argon2id$" of this server. MD5 hashes
+//("$apr1$", "$1$") are refused. File is read again when it changes.
+//Folder is template of user folder ("{user}" is replaced by user name)
+type HtpasswdAuthenticator struct {
+	FileName string
+	Folder   string
+	lock     sync.Mutex
+	hashes   map[string]string
+	modTime  time.Time
+	size     int64
+}
+
+//ErrUnsupportedHash is returned for password hashes which can't be checked (DES crypt, SHA-256/512 crypt)
+var ErrUnsupportedHash = errors.New("Unsupported password hash")
+
+//ErrMD5Hash is returned for MD5 crypt hashes of htpasswd ("$apr1$", "$1$"): they are too weak to be accepted
+var ErrMD5Hash = errors.New("MD5 password hashes ($apr1$, $1$) are not supported, use bcrypt (htpasswd -B)")
+
+//ErrWrongHash is returned for damaged password hashes
+var ErrWrongHash = errors.New("Wrong password hash")
+
+//bcryptMaxCost - hashes with higher cost are refused, login check with such cost takes minutes
+const bcryptMaxCost = 16
+
+//NewHtpasswdAuthenticator returns authenticator of htpasswd file
+func NewHtpasswdAuthenticator(fileName string, folder string) (*HtpasswdAuthenticator, error) {
+	auth := &HtpasswdAuthenticator{FileName: fileName, Folder: folder}
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+	if err := auth.load(); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+func newHtpasswdAuthenticatorFromConfig(config *FTPServConfig.ConfigStorage, users *Users) (Authenticator, error) {
+	if len(config.HtpasswdFile) == 0 {
+		return nil, errors.New("HtpasswdFile is not set")
+	}
+	return NewHtpasswdAuthenticator(config.HtpasswdFile, config.AuthUserFolder)
+}
+
+//load reads file if it was changed. Lock must be held
+func (auth *HtpasswdAuthenticator) load() error {
+	fi, err := os.Stat(auth.FileName)
+	if err != nil {
+		return err
+	}
+	if auth.hashes != nil && fi.ModTime().Equal(auth.modTime) && fi.Size() == auth.size {
+		return nil
+	}
+	file, err := os.Open(auth.FileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		hashes[parts[0]] = parts[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	auth.hashes, auth.modTime, auth.size = hashes, fi.ModTime(), fi.Size()
+	return nil
+}
+
+//hash returns password hash of user from actual file
+func (auth *HtpasswdAuthenticator) hash(userName string) (string, error) {
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+	if err := auth.load(); err != nil {
+		return "", err
+	}
+	hash, ok := auth.hashes[userName]
+	if !ok {
+		return "", ErrUnknownUser
+	}
+	return hash, nil
+}
+
+func (auth *HtpasswdAuthenticator) Name() string {
+	return fmt.Sprint("htpasswd(", auth.FileName, ")")
+}
+func (auth *HtpasswdAuthenticator) Lookup(userName string) (*User, error) {
+	if _, err := auth.hash(userName); err != nil {
+		return nil, err
+	}
+	return &User{UserName: userName, Folder: userFolder(auth.Folder, userName)}, nil
+}
+func (auth *HtpasswdAuthenticator) Authenticate(userName string, pswd string) (*User, error) {
+	hash, err := auth.hash(userName)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := checkHtpasswdHash(hash, pswd)
+	if err != nil {
+		return nil, errors.New(fmt.Sprint("user ", userName, ": ", err))
+	}
+	if !ok {
+		return nil, ErrWrongPassword
+	}
+	return &User{UserName: userName, Folder: userFolder(auth.Folder, userName)}, nil
+}
+
+//checkHtpasswdHash reports if pswd matches hash of htpasswd file
+func checkHtpasswdHash(hash string, pswd string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(pswd))
+		return subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(sum[:])), []byte(hash[5:])) == 1, nil
+	case strings.HasPrefix(hash, "$2y$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2a$"):
+		return checkBcrypt(hash, pswd)
+	case strings.HasPrefix(hash, "$apr1$"), strings.HasPrefix(hash, "$1$"):
+		return false, ErrMD5Hash
+	case strings.HasPrefix(hash, fmt.Sprint("$", passwordScheme, "$")):
+		user := User{Password: hash}
+		return user.CheckPswd(pswd), nil
+	}
+	return false, ErrUnsupportedHash
+}
+
+//checkBcrypt reports if pswd matches bcrypt hash
+func checkBcrypt(hash string, pswd string) (bool, error) {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, ErrWrongHash
+	}
+	if cost > bcryptMaxCost {
+		return false, errors.New(fmt.Sprint("bcrypt cost ", cost, " is over ", bcryptMaxCost))
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(pswd))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, ErrWrongHash
+	}
+	return true, nil
+}
@@ -0,0 +1,128 @@
+package FTPAuth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//htpasswdVectors are made by libcrypt (bcrypt) and htpasswd
+var htpasswdVectors = []struct {
+	name string
+	hash string
+	pswd string
+}{
+	{"bcrypt", "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "U*U"},
+	{"bcrypt 2", "$2a$05$CCCCCCCCCCCCCCCCCCCCC.VGOzA784oUp/Z0DY336zx7pLYAy0lwK", "U*U*"},
+	{"bcrypt empty password", "$2b$05$CCCCCCCCCCCCCCCCCCCCC.7uG0VCzI2bS7j6ymqJi9CdcdxiRTWNy", ""},
+	{"bcrypt long password", "$2b$05$abcdefghijklmnopqrstuu5s2v8.iXieOjg/.AySBTTZIIVFJeBui", "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789chars after 72 are ignored"},
+	{"bcrypt 72 characters", "$2b$05$abcdefghijklmnopqrstuu5s2v8.iXieOjg/.AySBTTZIIVFJeBui", "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"},
+	{"bcrypt htpasswd -B", "$2y$10$abcdefghijklmnopqrstuuqflPDzB6gcMhKa1rZqKiun2YGL5sa2u", "secret"},
+	{"bcrypt cost 4", "$2b$04$ABCDEFGHIJKLMNOPQRSTUux/yVoHLb2P5.QxxfIdIyVUJvRQqUe5S", "secret"},
+	{"sha1", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "secret"},
+}
+
+func TestHtpasswdHashes(t *testing.T) {
+	for _, vector := range htpasswdVectors {
+		if ok, err := checkHtpasswdHash(vector.hash, vector.pswd); !ok || err != nil {
+			t.Errorf("%s: password is not accepted (%v)", vector.name, err)
+		}
+		//bcrypt uses 72 characters of password
+		if len(vector.pswd) >= 72 {
+			continue
+		}
+		if ok, err := checkHtpasswdHash(vector.hash, vector.pswd+"x"); ok || err != nil {
+			t.Errorf("%s: wrong password accepted (%v)", vector.name, err)
+		}
+	}
+	pswd := "secret"
+	HashPswd(&pswd)
+	if ok, err := checkHtpasswdHash(pswd, "secret"); !ok || err != nil {
+		t.Errorf("argon2id hash of server is not accepted (%v)", err)
+	}
+	//MD5 hashes are refused even with right password
+	for _, hash := range []string{"$1$saltsalt$9xy1btjgzLYfb7hivXtC//", "$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0"} {
+		if ok, err := checkHtpasswdHash(hash, "secret"); ok || err != ErrMD5Hash {
+			t.Errorf("MD5 hash %s: %v, error %v, want %v", hash, ok, err, ErrMD5Hash)
+		}
+	}
+	for _, hash := range []string{
+		"$6$saltsalt$TVLlQcbpFVof5W3Yz4DTP6gRstiNuHwwTt6GLc1E5n0U0aDehy0S5knV8wiOQSpT0Y77vwPZN.Pq.H91p5hVO1",
+		"saQS3MFuWFEbg",
+	} {
+		if _, err := checkHtpasswdHash(hash, "secret"); err != ErrUnsupportedHash {
+			t.Errorf("hash %s: error %v, want %v", hash, err, ErrUnsupportedHash)
+		}
+	}
+	for _, hash := range []string{
+		"$2b$05$CCCCCCCCCCCCCCCCCCCCC.7uG0VCzI2bS7j6ymqJi9Cdcdxi",
+		"$2b$03$CCCCCCCCCCCCCCCCCCCCC.7uG0VCzI2bS7j6ymqJi9CdcdxiRTWNy",
+		"$2b$5$CCCCCCCCCCCCCCCCCCCCC.7uG0VCzI2bS7j6ymqJi9CdcdxiRTWNy",
+		"$2b$31$CCCCCCCCCCCCCCCCCCCCC.7uG0VCzI2bS7j6ymqJi9CdcdxiRTWNy",
+	} {
+		if ok, err := checkHtpasswdHash(hash, ""); ok || err == nil {
+			t.Errorf("damaged or too costly hash %s accepted", hash)
+		}
+	}
+}
+
+func TestHtpasswdAuthenticator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftpauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, ".htpasswd")
+	content := "# users\nbob:$2y$10$abcdefghijklmnopqrstuuqflPDzB6gcMhKa1rZqKiun2YGL5sa2u\n\nalice:$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0\n" +
+		"carol:$6$saltsalt$TVLlQcbpFVof5W3Yz4DTP6gRstiNuHwwTt6GLc1E5n0U0aDehy0S5knV8wiOQSpT0Y77vwPZN.Pq.H91p5hVO1\nbroken line\n"
+	if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := NewHtpasswdAuthenticator(fileName, "/ftp/{user}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		userName string
+		pswd     string
+		ok       bool
+		err      error
+	}{
+		{"bob", "secret", true, nil},
+		{"bob", "Secret", false, ErrWrongPassword},
+		{"alice", "secret", false, nil},
+		{"dave", "secret", false, ErrUnknownUser},
+		{"broken line", "", false, ErrUnknownUser},
+		{"carol", "secret", false, nil},
+	}
+	for _, test := range tests {
+		user, err := auth.Authenticate(test.userName, test.pswd)
+		if test.ok {
+			if err != nil || user.Folder != "/ftp/"+test.userName {
+				t.Errorf("%s: user %v, error %v", test.userName, user, err)
+			}
+		} else if err == nil || (test.err != nil && err != test.err) {
+			t.Errorf("%s: error %v, want %v", test.userName, err, test.err)
+		}
+	}
+	//unsupported and MD5 hashes are errors of backend, not wrong password
+	for _, userName := range []string{"alice", "carol"} {
+		if _, err := auth.Authenticate(userName, "secret"); err == nil || err == ErrWrongPassword {
+			t.Errorf("%s: unsupported hash reported as %v", userName, err)
+		}
+	}
+	//changed file is read again
+	if err := ioutil.WriteFile(fileName, []byte("dave:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(fileName, later, later)
+	if _, err := auth.Authenticate("dave", "secret"); err != nil {
+		t.Errorf("user of changed file: %v", err)
+	}
+	if _, err := auth.Lookup("bob"); err != ErrUnknownUser {
+		t.Errorf("removed user: error %v", err)
+	}
+}
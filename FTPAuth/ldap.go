@@ -0,0 +1,351 @@
+// LDAP authentication backend
+package FTPAuth
+
+import (
+	"FTPServ/FTPServConfig"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Defaults of LDAP authenticator
+const (
+	DefaultLDAPUserFilter     = "(uid={user})"
+	DefaultLDAPGroupAttribute = "memberOf"
+	DefaultLDAPTimeout        = 10 * time.Second
+)
+
+//LDAPAuthenticator checks users with simple bind to LDAP server. If UserDN is set user binds with it
+//("uid={user},ou=people,dc=example,dc=com"), otherwise user is searched in BaseDN with UserFilter
+//(as BindDN or anonymously) and binds with DN found. Folder of user is value of FolderAttribute (Folder
+//template if it is empty or missing). Users get Permissions (ReadOnlyPermissions if empty), GroupPermissions adds
+//permissions to members of groups listed in GroupAttribute (group DN or its name). Users are cached for CacheTime,
+//0 - no cache
+type LDAPAuthenticator struct {
+	URL              string
+	StartTLS         bool
+	BindDN           string
+	BindPassword     string
+	UserDN           string
+	BaseDN           string
+	UserFilter       string
+	FolderAttribute  string
+	GroupAttribute   string
+	GroupPermissions map[string][]string
+	Permissions      []string
+	Folder           string
+	CacheTime        time.Duration
+	Timeout          time.Duration
+	lock             sync.Mutex
+	cache            map[string]ldapCacheEntry
+	cacheKey         []byte
+}
+
+//ldapCacheEntry is user found in LDAP. pswdHash is keyed hash of password of successful login (nil after lookup)
+type ldapCacheEntry struct {
+	user     User
+	pswdHash []byte
+	expires  time.Time
+}
+
+func newLDAPAuthenticatorFromConfig(config *FTPServConfig.ConfigStorage, users *Users) (Authenticator, error) {
+	auth := &LDAPAuthenticator{
+		URL:              config.LDAPURL,
+		StartTLS:         config.LDAPStartTLS,
+		BindDN:           config.LDAPBindDN,
+		BindPassword:     config.LDAPBindPassword,
+		UserDN:           config.LDAPUserDN,
+		BaseDN:           config.LDAPBaseDN,
+		UserFilter:       config.LDAPUserFilter,
+		FolderAttribute:  config.LDAPFolderAttribute,
+		GroupAttribute:   config.LDAPGroupAttribute,
+		GroupPermissions: config.LDAPGroupPermissions,
+		Permissions:      config.DefaultPermissions,
+		Folder:           config.AuthUserFolder,
+		CacheTime:        time.Duration(config.LDAPCacheTime) * time.Second,
+	}
+	if len(auth.URL) == 0 {
+		return nil, errors.New("LDAPURL is not set")
+	}
+	if len(auth.UserDN) == 0 && len(auth.BaseDN) == 0 {
+		return nil, errors.New("LDAPUserDN or LDAPBaseDN must be set")
+	}
+	if _, err := ldap.CompileFilter(strings.Replace(auth.userFilter(), "{user}", "user", -1)); err != nil {
+		return nil, errors.New(fmt.Sprint("LDAPUserFilter \"", auth.userFilter(), "\": ", err))
+	}
+	for group, permissions := range auth.GroupPermissions {
+		for _, permission := range permissions {
+			if err := new(User).Grant(permission); err != nil {
+				return nil, errors.New(fmt.Sprint("group ", group, ": ", err))
+			}
+		}
+	}
+	return auth, nil
+}
+
+func (auth *LDAPAuthenticator) Name() string {
+	return fmt.Sprint("ldap(", auth.URL, ")")
+}
+
+//Lookup finds user as BindDN (or anonymously)
+func (auth *LDAPAuthenticator) Lookup(userName string) (*User, error) {
+	if user, ok := auth.cached(userName, nil); ok {
+		return user, nil
+	}
+	if len(strings.TrimSpace(userName)) == 0 {
+		return nil, ErrUnknownUser
+	}
+	conn, err := auth.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := auth.bindSearch(conn); err != nil {
+		return nil, err
+	}
+	var entry *ldap.Entry
+	if len(auth.UserDN) > 0 {
+		entry, err = auth.readEntry(conn, auth.userDN(userName))
+	} else {
+		entry, err = auth.findEntry(conn, userName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return auth.store(userName, entry, nil), nil
+}
+
+//Authenticate binds as user. Wrong password of UserDN can't be told from unknown user: ErrWrongPassword is returned
+func (auth *LDAPAuthenticator) Authenticate(userName string, pswd string) (*User, error) {
+	if len(strings.TrimSpace(userName)) == 0 {
+		return nil, ErrUnknownUser
+	}
+	if len(pswd) == 0 {
+		//simple bind with empty password is anonymous one and always succeeds
+		return nil, ErrWrongPassword
+	}
+	pswdHash := auth.pswdHash(pswd)
+	if user, ok := auth.cached(userName, pswdHash); ok {
+		return user, nil
+	}
+	conn, err := auth.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var entry *ldap.Entry
+	if len(auth.UserDN) > 0 {
+		dn := auth.userDN(userName)
+		if err := conn.Bind(dn, pswd); err != nil {
+			if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+				return nil, ErrWrongPassword
+			}
+			return nil, err
+		}
+		if entry, err = auth.readEntry(conn, dn); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := auth.bindSearch(conn); err != nil {
+			return nil, err
+		}
+		if entry, err = auth.findEntry(conn, userName); err != nil {
+			return nil, err
+		}
+		if err := conn.Bind(entry.DN, pswd); err != nil {
+			if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+				return nil, ErrWrongPassword
+			}
+			return nil, err
+		}
+	}
+	return auth.store(userName, entry, pswdHash), nil
+}
+
+//dial connects to URL, StartTLS secures ldap:// connection
+func (auth *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	timeout := auth.timeout()
+	ldapURL, err := url.Parse(auth.URL)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ldap.DialURL(auth.URL, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(&tls.Config{ServerName: ldapURL.Hostname()}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+	if auth.StartTLS && strings.EqualFold(ldapURL.Scheme, "ldap") {
+		if err := conn.StartTLS(&tls.Config{ServerName: ldapURL.Hostname()}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+//bindSearch binds as BindDN, connection stays anonymous if it is empty
+func (auth *LDAPAuthenticator) bindSearch(conn *ldap.Conn) error {
+	if len(auth.BindDN) == 0 {
+		return nil
+	}
+	if err := conn.Bind(auth.BindDN, auth.BindPassword); err != nil {
+		return errors.New(fmt.Sprint("bind as \"", auth.BindDN, "\": ", err))
+	}
+	return nil
+}
+func (auth *LDAPAuthenticator) timeout() time.Duration {
+	if auth.Timeout <= 0 {
+		return DefaultLDAPTimeout
+	}
+	return auth.Timeout
+}
+func (auth *LDAPAuthenticator) userDN(userName string) string {
+	return strings.Replace(auth.UserDN, "{user}", ldap.EscapeDN(userName), -1)
+}
+
+//userFilter returns UserFilter in parentheses ("uid={user}" is "(uid={user})")
+func (auth *LDAPAuthenticator) userFilter() string {
+	filter := strings.TrimSpace(auth.UserFilter)
+	if len(filter) == 0 {
+		return DefaultLDAPUserFilter
+	}
+	if !strings.HasPrefix(filter, "(") {
+		filter = fmt.Sprint("(", filter, ")")
+	}
+	return filter
+}
+func (auth *LDAPAuthenticator) groupAttribute() string {
+	if len(strings.TrimSpace(auth.GroupAttribute)) == 0 {
+		return DefaultLDAPGroupAttribute
+	}
+	return auth.GroupAttribute
+}
+func (auth *LDAPAuthenticator) attributes() []string {
+	attributes := []string{auth.groupAttribute()}
+	if len(auth.FolderAttribute) > 0 {
+		attributes = append(attributes, auth.FolderAttribute)
+	}
+	return attributes
+}
+
+//findEntry searches user in BaseDN, user must be found once
+func (auth *LDAPAuthenticator) findEntry(conn *ldap.Conn, userName string) (*ldap.Entry, error) {
+	filter := strings.Replace(auth.userFilter(), "{user}", ldap.EscapeFilter(userName), -1)
+	result, err := conn.Search(ldap.NewSearchRequest(auth.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2,
+		int(auth.timeout()/time.Second), false, filter, auth.attributes(), nil))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, errors.New(fmt.Sprint("LDAP: more than one entry found for user ", userName))
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch len(result.Entries) {
+	case 0:
+		return nil, ErrUnknownUser
+	case 1:
+		return result.Entries[0], nil
+	}
+	return nil, errors.New(fmt.Sprint("LDAP: more than one entry found for user ", userName))
+}
+
+//readEntry reads attributes of user entry dn
+func (auth *LDAPAuthenticator) readEntry(conn *ldap.Conn, dn string) (*ldap.Entry, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1,
+		int(auth.timeout()/time.Second), false, "(objectClass=*)", auth.attributes(), nil))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, ErrUnknownUser
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) == 0 {
+		return nil, ErrUnknownUser
+	}
+	return result.Entries[0], nil
+}
+
+//user makes profile of user from entry
+func (auth *LDAPAuthenticator) user(userName string, entry *ldap.Entry) User {
+	user := User{UserName: userName, Folder: userFolder(auth.Folder, userName)}
+	user.Permissions = append([]string(nil), auth.Permissions...)
+	if len(user.Permissions) == 0 {
+		user.Permissions = append(user.Permissions, ReadOnlyPermissions...)
+	}
+	if folders := entry.GetEqualFoldAttributeValues(auth.FolderAttribute); len(auth.FolderAttribute) > 0 && len(folders) > 0 && len(strings.TrimSpace(folders[0])) > 0 {
+		user.Folder = strings.TrimSpace(folders[0])
+	}
+	for _, groupDN := range entry.GetEqualFoldAttributeValues(auth.groupAttribute()) {
+		groupName := strings.SplitN(groupDN, ",", 2)[0]
+		groupName = groupName[strings.Index(groupName, "=")+1:]
+		for group, permissions := range auth.GroupPermissions {
+			if !strings.EqualFold(group, groupDN) && !strings.EqualFold(group, groupName) {
+				continue
+			}
+			for _, permission := range permissions {
+				user.Grant(permission)
+			}
+		}
+	}
+	return user
+}
+
+//pswdHash returns hash of password kept in cache, its key is random one of authenticator
+func (auth *LDAPAuthenticator) pswdHash(pswd string) []byte {
+	auth.lock.Lock()
+	if auth.cacheKey == nil {
+		auth.cacheKey = make([]byte, 32)
+		if _, err := rand.Read(auth.cacheKey); err != nil {
+			panic(err)
+		}
+	}
+	mac := hmac.New(sha256.New, auth.cacheKey)
+	auth.lock.Unlock()
+	mac.Write([]byte(pswd))
+	return mac.Sum(nil)
+}
+
+//cached returns user from cache. pswdHash is checked if it isn't nil
+func (auth *LDAPAuthenticator) cached(userName string, pswdHash []byte) (*User, bool) {
+	if auth.CacheTime <= 0 {
+		return nil, false
+	}
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+	entry, ok := auth.cache[userName]
+	if !ok || time.Now().After(entry.expires) {
+		delete(auth.cache, userName)
+		return nil, false
+	}
+	if pswdHash != nil && !hmac.Equal(pswdHash, entry.pswdHash) {
+		return nil, false
+	}
+	user := entry.user
+	return &user, true
+}
+
+//store caches user of entry and returns it. Lookup keeps cached login till it expires
+func (auth *LDAPAuthenticator) store(userName string, entry *ldap.Entry, pswdHash []byte) *User {
+	user := auth.user(userName, entry)
+	if auth.CacheTime > 0 {
+		auth.lock.Lock()
+		if auth.cache == nil {
+			auth.cache = make(map[string]ldapCacheEntry)
+		}
+		cached := ldapCacheEntry{user: user, pswdHash: pswdHash, expires: time.Now().Add(auth.CacheTime)}
+		if old, ok := auth.cache[userName]; ok && pswdHash == nil && time.Now().Before(old.expires) {
+			cached.pswdHash, cached.expires = old.pswdHash, old.expires
+		}
+		auth.cache[userName] = cached
+		auth.lock.Unlock()
+	}
+	return &user
+}
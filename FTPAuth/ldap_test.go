@@ -0,0 +1,372 @@
+package FTPAuth
+
+import (
+	"bufio"
+	"fmt"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//testLDAPEntry is object of test LDAP server, names of attributes are in lower case
+type testLDAPEntry struct {
+	password   string
+	attributes map[string][]string
+}
+
+//testLDAPServer is LDAP server of test: simple bind, search with equality, presence and "&" filters.
+//Operations are logged as "bind DN", "search base filter"
+type testLDAPServer struct {
+	listener net.Listener
+	entries  map[string]testLDAPEntry
+	lock     sync.Mutex
+	log      []string
+}
+
+const (
+	testLDAPBaseDN     = "ou=people,dc=example,dc=com"
+	testLDAPServiceDN  = "cn=service,dc=example,dc=com"
+	testLDAPUploaders  = "cn=uploaders,ou=groups,dc=example,dc=com"
+	testLDAPAdmins     = "cn=admins,ou=groups,dc=example,dc=com"
+	testLDAPServicePwd = "service secret"
+)
+
+func startTestLDAPServer(t *testing.T) *testLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person := func(uid string, password string, attributes map[string][]string) (string, testLDAPEntry) {
+		attributes["uid"] = []string{uid}
+		attributes["objectclass"] = []string{"person"}
+		return fmt.Sprint("uid=", uid, ",", testLDAPBaseDN), testLDAPEntry{password: password, attributes: attributes}
+	}
+	s := &testLDAPServer{listener: listener, entries: make(map[string]testLDAPEntry)}
+	s.entries[testLDAPServiceDN] = testLDAPEntry{password: testLDAPServicePwd, attributes: map[string][]string{}}
+	for _, add := range []func() (string, testLDAPEntry){
+		func() (string, testLDAPEntry) {
+			return person("bob", "bob secret", map[string][]string{"homedirectory": {"/home/bob"}, "memberof": {testLDAPUploaders}})
+		},
+		func() (string, testLDAPEntry) {
+			return person("eve", "eve secret", map[string][]string{"memberof": {testLDAPAdmins, testLDAPUploaders}})
+		},
+		func() (string, testLDAPEntry) {
+			return person("carol", "carol secret", map[string][]string{})
+		},
+	} {
+		dn, entry := add()
+		s.entries[dn] = entry
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testLDAPServer) URL() string {
+	return fmt.Sprint("ldap://", s.listener.Addr())
+}
+
+//operations returns logged operations and clears log
+func (s *testLDAPServer) operations() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	log := s.log
+	s.log = nil
+	return log
+}
+func (s *testLDAPServer) logOperation(args ...interface{}) {
+	s.lock.Lock()
+	s.log = append(s.log, fmt.Sprint(args...))
+	s.lock.Unlock()
+}
+
+//LDAP operations of test server (application tags of RFC 4511)
+const (
+	testLDAPBindRequest        = 0
+	testLDAPBindResponse       = 1
+	testLDAPUnbindRequest      = 2
+	testLDAPSearchRequest      = 3
+	testLDAPSearchEntry        = 4
+	testLDAPSearchDone         = 5
+	testLDAPSuccess            = 0
+	testLDAPNoSuchObject       = 32
+	testLDAPInvalidCredentials = 49
+)
+
+func (s *testLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(id int64, response *ber.Packet) {
+		message := ber.NewSequence("")
+		message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+		message.AppendChild(response)
+		conn.Write(message.Bytes())
+	}
+	result := func(tag ber.Tag, code int) *ber.Packet {
+		response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+		response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+		response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+		response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+		return response
+	}
+	for {
+		message, err := ber.ReadPacket(reader)
+		if err != nil || len(message.Children) < 2 {
+			return
+		}
+		id, request := message.Children[0].Value.(int64), message.Children[1]
+		switch request.Tag {
+		case testLDAPBindRequest:
+			dn, password := request.Children[1].Data.String(), request.Children[2].Data.String()
+			s.logOperation("bind ", dn)
+			code := testLDAPInvalidCredentials
+			if entry, ok := s.entries[dn]; (ok && entry.password == password) || (len(dn) == 0 && len(password) == 0) {
+				code = testLDAPSuccess
+			}
+			reply(id, result(testLDAPBindResponse, code))
+		case testLDAPSearchRequest:
+			baseDN, scope, filter := request.Children[0].Data.String(), request.Children[1].Value.(int64), request.Children[6]
+			s.logOperation("search ", baseDN, " ", testLDAPFilterString(filter))
+			if _, ok := s.entries[baseDN]; !ok && scope == ldap.ScopeBaseObject {
+				reply(id, result(testLDAPSearchDone, testLDAPNoSuchObject))
+				continue
+			}
+			for dn, entry := range s.entries {
+				if (scope == ldap.ScopeBaseObject && dn != baseDN) || !strings.HasSuffix(dn, baseDN) || !testLDAPMatch(filter, entry) {
+					continue
+				}
+				response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, testLDAPSearchEntry, nil, "")
+				response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+				attributes := ber.NewSequence("")
+				for _, name := range request.Children[7].Children {
+					values := entry.attributes[strings.ToLower(name.Data.String())]
+					if len(values) == 0 {
+						continue
+					}
+					attribute := ber.NewSequence("")
+					attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name.Data.String(), ""))
+					set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+					for _, value := range values {
+						set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+					}
+					attribute.AppendChild(set)
+					attributes.AppendChild(attribute)
+				}
+				response.AppendChild(attributes)
+				reply(id, response)
+			}
+			reply(id, result(testLDAPSearchDone, testLDAPSuccess))
+		case testLDAPUnbindRequest:
+			return
+		}
+	}
+}
+
+//testLDAPMatch checks entry with filter: "&", equality and presence
+func testLDAPMatch(filter *ber.Packet, entry testLDAPEntry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !testLDAPMatch(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterEqualityMatch:
+		for _, value := range entry.attributes[strings.ToLower(filter.Children[0].Data.String())] {
+			if strings.EqualFold(value, filter.Children[1].Data.String()) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return strings.EqualFold(filter.Data.String(), "objectClass") || len(entry.attributes[strings.ToLower(filter.Data.String())]) > 0
+	}
+	return false
+}
+func testLDAPFilterString(filter *ber.Packet) string {
+	switch filter.Tag {
+	case ldap.FilterEqualityMatch:
+		return fmt.Sprint("(", filter.Children[0].Data.String(), "=", filter.Children[1].Data.String(), ")")
+	case ldap.FilterPresent:
+		return fmt.Sprint("(", filter.Data.String(), "=*)")
+	}
+	parts := make([]string, len(filter.Children))
+	for i, child := range filter.Children {
+		parts[i] = testLDAPFilterString(child)
+	}
+	return fmt.Sprint("(&", strings.Join(parts, ""), ")")
+}
+
+func newTestLDAPAuthenticator(s *testLDAPServer) *LDAPAuthenticator {
+	return &LDAPAuthenticator{
+		URL:             s.URL(),
+		BindDN:          testLDAPServiceDN,
+		BindPassword:    testLDAPServicePwd,
+		BaseDN:          testLDAPBaseDN,
+		FolderAttribute: "homeDirectory",
+		GroupPermissions: map[string][]string{
+			"uploaders":    {PermissionUpload, PermissionMkdir},
+			testLDAPAdmins: {PermissionAll, PermissionRecursiveDelete},
+		},
+		Folder:  "/ldap/{user}",
+		Timeout: 5 * time.Second,
+	}
+}
+
+func equalOperations(got []string, want ...string) bool {
+	return strings.Join(got, "\n") == strings.Join(want, "\n")
+}
+
+func TestLDAPSearchThenBind(t *testing.T) {
+	s := startTestLDAPServer(t)
+	defer s.listener.Close()
+	auth := newTestLDAPAuthenticator(s)
+
+	user, err := auth.Authenticate("bob", "bob secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ops := s.operations(); !equalOperations(ops, "bind "+testLDAPServiceDN, "search "+testLDAPBaseDN+" (uid=bob)", "bind uid=bob,"+testLDAPBaseDN) {
+		t.Errorf("operations of login:\n%s", strings.Join(ops, "\n"))
+	}
+	if user.UserName != "bob" || user.Folder != "/home/bob" {
+		t.Errorf("user %s with folder %s", user.UserName, user.Folder)
+	}
+
+	tests := []struct {
+		userName string
+		pswd     string
+		err      error
+	}{
+		{"bob", "wrong", ErrWrongPassword},
+		{"bob", "", ErrWrongPassword},
+		{"dave", "dave secret", ErrUnknownUser},
+		{"*", "bob secret", ErrUnknownUser},
+		{"bob)(uid=*", "bob secret", ErrUnknownUser},
+		{"", "secret", ErrUnknownUser},
+	}
+	for _, test := range tests {
+		if _, err := auth.Authenticate(test.userName, test.pswd); err != test.err {
+			t.Errorf("%q: error %v, want %v", test.userName, err, test.err)
+		}
+	}
+	//unknown user of LDAP is asked from next backend
+	next := &testBackend{name: "json", users: map[string]string{"dave": "dave secret"}}
+	if user, err := (AuthChain{auth, next}).Authenticate("dave", "dave secret"); err != nil || user.Folder != "/json" {
+		t.Errorf("next backend after unknown LDAP user: %v, %v", user, err)
+	}
+	if user, err := auth.Lookup("carol"); err != nil || user.Folder != "/ldap/carol" {
+		t.Errorf("Lookup: %v, %v", user, err)
+	}
+	if _, err := auth.Lookup("dave"); err != ErrUnknownUser {
+		t.Errorf("Lookup of unknown user: %v", err)
+	}
+
+	//wrong password of search account is error of backend, chain stops
+	auth.BindPassword = "wrong"
+	next.calls = 0
+	if _, err := (AuthChain{auth, next}).Authenticate("dave", "dave secret"); err == nil || err == ErrUnknownUser || next.calls != 0 {
+		t.Errorf("chain after bind error of search account: %v, next backend asked %d times", err, next.calls)
+	}
+}
+
+func TestLDAPDirectBind(t *testing.T) {
+	s := startTestLDAPServer(t)
+	defer s.listener.Close()
+	auth := newTestLDAPAuthenticator(s)
+	auth.UserDN = "uid={user}," + testLDAPBaseDN
+	auth.BindDN, auth.BindPassword = "", ""
+	if _, err := auth.Authenticate("bob", "bob secret"); err != nil {
+		t.Fatal(err)
+	}
+	if ops := s.operations(); !equalOperations(ops, "bind uid=bob,"+testLDAPBaseDN, "search uid=bob,"+testLDAPBaseDN+" (objectClass=*)") {
+		t.Errorf("operations of login:\n%s", strings.Join(ops, "\n"))
+	}
+	//wrong password can't be told from unknown user
+	for _, userName := range []string{"bob", "dave"} {
+		if _, err := auth.Authenticate(userName, "wrong"); err != ErrWrongPassword {
+			t.Errorf("%s: error %v, want %v", userName, err, ErrWrongPassword)
+		}
+	}
+	if _, err := auth.Lookup("dave"); err != ErrUnknownUser {
+		t.Errorf("Lookup of unknown user: %v", err)
+	}
+}
+
+func TestLDAPGroupPermissions(t *testing.T) {
+	s := startTestLDAPServer(t)
+	defer s.listener.Close()
+	auth := newTestLDAPAuthenticator(s)
+	tests := []struct {
+		name        string
+		permissions []string
+		userName    string
+		allowed     []string
+		denied      []string
+		recursive   bool
+	}{
+		{"no groups", nil, "carol", ReadOnlyPermissions, []string{PermissionUpload, PermissionDelete, PermissionSite}, false},
+		{"group by name", nil, "bob", []string{PermissionList, PermissionDownload, PermissionUpload, PermissionMkdir}, []string{PermissionDelete, PermissionOverwrite}, false},
+		{"group by DN", nil, "eve", AllPermissions, nil, true},
+		{"base permissions", []string{PermissionList}, "carol", []string{PermissionList}, []string{PermissionDownload, PermissionUpload}, false},
+		{"base permissions and group", []string{PermissionList}, "bob", []string{PermissionList, PermissionUpload}, []string{PermissionDownload}, false},
+	}
+	for _, test := range tests {
+		auth.Permissions = test.permissions
+		user, err := auth.Authenticate(test.userName, test.userName+" secret")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		//defaults of server don't widen permissions of LDAP user
+		for _, permission := range test.allowed {
+			if !user.Can(permission, []string{PermissionAll}) {
+				t.Errorf("%s: %s is denied", test.name, permission)
+			}
+		}
+		for _, permission := range test.denied {
+			if user.Can(permission, []string{PermissionAll}) {
+				t.Errorf("%s: %s is allowed", test.name, permission)
+			}
+		}
+		if user.RecursiveDelete != test.recursive {
+			t.Errorf("%s: recursive delete %v", test.name, user.RecursiveDelete)
+		}
+	}
+}
+
+func TestLDAPCache(t *testing.T) {
+	s := startTestLDAPServer(t)
+	defer s.listener.Close()
+	auth := newTestLDAPAuthenticator(s)
+	auth.CacheTime = time.Minute
+	if _, err := auth.Authenticate("bob", "bob secret"); err != nil {
+		t.Fatal(err)
+	}
+	s.operations()
+	if _, err := auth.Authenticate("bob", "bob secret"); err != nil {
+		t.Fatal(err)
+	}
+	if ops := s.operations(); len(ops) != 0 {
+		t.Errorf("cached user asked from server:\n%s", strings.Join(ops, "\n"))
+	}
+	//other password is checked by server
+	if _, err := auth.Authenticate("bob", "wrong"); err != ErrWrongPassword {
+		t.Errorf("wrong password of cached user: %v", err)
+	}
+	if ops := s.operations(); len(ops) == 0 {
+		t.Errorf("wrong password of cached user is not checked by server")
+	}
+}
@@ -0,0 +1,107 @@
+// Password hashes
+package FTPAuth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+//Password hashes are stored as "$argon2id$v=19$m=memory,t=time,p=threads$salt$hash" (PHC string format,
+//salt and hash in unpadded base64, memory in KiB). Hashes without scheme are legacy ones (hex of password with
+//SHA-256 of empty string), they are replaced with current scheme after successful login. Hashes with parameters
+//over passwordMax* limits are refused, so damaged or crafted users file doesn't make login check take minutes
+//or gigabytes of memory
+const (
+	passwordScheme     = "argon2id"
+	passwordMemory     = 64 * 1024
+	passwordTime       = 3
+	passwordThreads    = 4
+	passwordSaltSize   = 16
+	passwordKeySize    = 32
+	passwordMaxMemory  = 1024 * 1024
+	passwordMaxTime    = 32
+	passwordMaxKeySize = 64
+)
+
+//passwordParams are argon2id parameters of stored hash
+type passwordParams struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+//Hashes pswd with new random salt
+func HashPswd(pswd *string) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	key := argon2.IDKey([]byte(*pswd), salt, passwordTime, passwordMemory, passwordThreads, passwordKeySize)
+	*pswd = fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", passwordScheme, argon2.Version, passwordMemory, passwordTime, passwordThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+//legacyHash returns hash made by old HashPswd
+func legacyHash(pswd string) string {
+	hash := sha256.New()
+	return hex.EncodeToString(hash.Sum([]byte(pswd)))
+}
+
+//parsePasswordHash returns parameters of argon2id hash, ok is false for other schemes and refused parameters
+func parsePasswordHash(hash string) (passwordParams, bool) {
+	params := passwordParams{}
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != passwordScheme || parts[2] != fmt.Sprint("v=", argon2.Version) {
+		return params, false
+	}
+	var threads uint32
+	if n, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &threads); err != nil || n != 3 {
+		return params, false
+	}
+	if parts[3] != fmt.Sprintf("m=%d,t=%d,p=%d", params.memory, params.time, threads) {
+		//trailing data
+		return params, false
+	}
+	if params.time == 0 || params.time > passwordMaxTime || threads == 0 || threads > 255 ||
+		params.memory < 8*threads || params.memory > passwordMaxMemory {
+		return params, false
+	}
+	params.threads = uint8(threads)
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, false
+	}
+	params.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(params.key) == 0 || len(params.key) > passwordMaxKeySize {
+		return params, false
+	}
+	return params, true
+}
+
+//CheckPswd reports if pswd matches password hash of user. Hashes are compared in constant time
+func (U *User) CheckPswd(pswd string) bool {
+	if !strings.HasPrefix(U.Password, "$") {
+		return subtle.ConstantTimeCompare([]byte(legacyHash(pswd)), []byte(U.Password)) == 1
+	}
+	params, ok := parsePasswordHash(U.Password)
+	if !ok {
+		//unknown scheme or refused parameters
+		return false
+	}
+	key := argon2.IDKey([]byte(pswd), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1
+}
+
+//PswdNeedsRehash reports if password hash of user is legacy or weaker than current one
+func (U *User) PswdNeedsRehash() bool {
+	params, ok := parsePasswordHash(U.Password)
+	return !ok || params.memory < passwordMemory || params.time < passwordTime || len(params.key) < passwordKeySize
+}
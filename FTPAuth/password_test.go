@@ -0,0 +1,129 @@
+package FTPAuth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//argon2idVector is fixed hash of "password" with salt "somesalt" and weaker parameters than current ones,
+//stored hashes must stay valid
+const argon2idVector = "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"
+
+//pswdHash returns stored password hash with parameters
+func pswdHash(memory int, time int, threads int, salt string, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=19$m=%d,t=%d,p=%d$%s$%s", memory, time, threads,
+		base64.RawStdEncoding.EncodeToString([]byte(salt)), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestCheckPswd(t *testing.T) {
+	pswd := "secret"
+	HashPswd(&pswd)
+	if !strings.HasPrefix(pswd, "$argon2id$v=19$m=65536,t=3,p=4$") {
+		t.Fatalf("hash %q has wrong scheme", pswd)
+	}
+	key := make([]byte, 32)
+	tests := []struct {
+		name   string
+		hash   string
+		pswd   string
+		ok     bool
+		rehash bool
+	}{
+		{"current", pswd, "secret", true, false},
+		{"current wrong password", pswd, "secret2", false, false},
+		{"reference", argon2idVector, "password", true, true},
+		{"reference wrong password", argon2idVector, "Password", false, true},
+		{"legacy", legacyHash("secret"), "secret", true, true},
+		{"legacy wrong password", legacyHash("secret"), "Secret", false, true},
+		{"too much memory", pswdHash(passwordMaxMemory+1, 1, 1, "somesalt", key), "password", false, true},
+		{"too many passes", pswdHash(1024, passwordMaxTime+1, 1, "somesalt", key), "password", false, true},
+		{"too many threads", pswdHash(1024, 1, 256, "somesalt", key), "password", false, true},
+		{"zero passes", pswdHash(1024, 0, 1, "somesalt", key), "password", false, true},
+		{"zero threads", pswdHash(1024, 1, 0, "somesalt", key), "password", false, true},
+		{"key too long", pswdHash(1024, 1, 1, "somesalt", make([]byte, passwordMaxKeySize+1)), "password", false, true},
+		{"huge memory", "$argon2id$v=19$m=4294967295,t=1,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "password", false, true},
+		{"trailing parameter", "$argon2id$v=19$m=65536,t=2,p=1,x=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "password", false, true},
+		{"other version", "$argon2id$v=16$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "password", false, true},
+		{"argon2i", "$argon2i$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$wWKIMhR9lyDFvRz9YTZweHKfbftvj+qf+YFY4NeBbtA", "password", false, true},
+		{"unknown scheme", "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "U*U", false, true},
+		{"empty", "", "", false, true},
+	}
+	for _, test := range tests {
+		user := &User{Password: test.hash}
+		if ok := user.CheckPswd(test.pswd); ok != test.ok {
+			t.Errorf("%s: CheckPswd = %v, want %v", test.name, ok, test.ok)
+		}
+		if rehash := user.PswdNeedsRehash(); rehash != test.rehash {
+			t.Errorf("%s: PswdNeedsRehash = %v, want %v", test.name, rehash, test.rehash)
+		}
+	}
+}
+
+//testUsers returns users saved to file of temporary folder
+func testUsers(t *testing.T, dir string, users ...User) *Users {
+	fileName := filepath.Join(dir, usersFileName)
+	data, _ := json.Marshal(users)
+	if err := ioutil.WriteFile(fileName, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Users{Users: users, usersFile: file}
+}
+
+func TestLegacyPswdUpgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftpauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	users := testUsers(t, dir, User{UserName: "bob", Password: legacyHash("secret"), Folder: "/bob"})
+	if _, err := users.Authenticate("bob", "wrong"); err != ErrWrongPassword {
+		t.Fatalf("wrong password: error %v", err)
+	}
+	if users.Users[0].Password != legacyHash("secret") {
+		t.Fatalf("hash changed after wrong password")
+	}
+	if _, err := users.Authenticate("bob", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	upgraded := users.Users[0].Password
+	if !strings.HasPrefix(upgraded, "$argon2id$") || users.Users[0].PswdNeedsRehash() {
+		t.Fatalf("legacy hash is not upgraded: %q", upgraded)
+	}
+	var saved []User
+	data, _ := ioutil.ReadFile(filepath.Join(dir, usersFileName))
+	if err := json.Unmarshal(data, &saved); err != nil || len(saved) != 1 || saved[0].Password != upgraded {
+		t.Fatalf("upgraded hash is not saved: %s", data)
+	}
+	if _, err := users.Authenticate("bob", "secret"); err != nil || users.Users[0].Password != upgraded {
+		t.Errorf("login with upgraded hash: %v", err)
+	}
+}
+
+func TestSaveError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftpauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := testUsers(t, dir, User{UserName: "bob", Password: legacyHash("secret")})
+	os.RemoveAll(dir)
+	if err := users.Save(); err == nil {
+		t.Errorf("Save to removed folder returned no error")
+	}
+	if err := users.RehashPswd("bob", "secret"); err == nil {
+		t.Errorf("RehashPswd returned no error of Save")
+	}
+	//login succeeds, failed save is logged
+	if _, err := users.Authenticate("bob", "secret"); err != nil {
+		t.Errorf("login with unsaved hash: %v", err)
+	}
+}
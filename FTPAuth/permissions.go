@@ -0,0 +1,94 @@
+// Permissions of users
+package FTPAuth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//Permissions of users: commands needing them are refused without them
+const (
+	//PermissionList - LIST, NLST, MLSD, MLST, STAT path, SIZE, MDTM, SITE VERSIONS
+	PermissionList = "list"
+	//PermissionDownload - RETR
+	PermissionDownload = "download"
+	//PermissionUpload - STOR, STOU, APPE of new files
+	PermissionUpload = "upload"
+	//PermissionOverwrite - STOR, APPE and RNTO changing existing files, SITE RESTORE
+	PermissionOverwrite = "overwrite"
+	//PermissionDelete - DELE
+	PermissionDelete = "delete"
+	//PermissionRename - RNFR and RNTO
+	PermissionRename = "rename"
+	//PermissionMkdir - MKD
+	PermissionMkdir = "mkdir"
+	//PermissionRmdir - RMD, SITE RMDIR
+	PermissionRmdir = "rmdir"
+	//PermissionChmod - changes of attributes: MFMT, MFCT
+	PermissionChmod = "chmod"
+	//PermissionSite - SITE commands
+	PermissionSite = "site"
+	//PermissionAll grants all permissions above
+	PermissionAll = "all"
+	//PermissionRecursiveDelete sets RecursiveDelete of user (SITE RMDIR -R, with rmdir and delete permissions)
+	PermissionRecursiveDelete = "recursivedelete"
+)
+
+//AllPermissions are permissions granted by PermissionAll
+var AllPermissions = []string{PermissionList, PermissionDownload, PermissionUpload, PermissionOverwrite, PermissionDelete,
+	PermissionRename, PermissionMkdir, PermissionRmdir, PermissionChmod, PermissionSite}
+
+//ReadOnlyPermissions are permissions of anonymous user and of LDAP users if LDAP backend has no Permissions
+var ReadOnlyPermissions = []string{PermissionList, PermissionDownload}
+
+//ValidPermission reports if permission is known
+func ValidPermission(permission string) bool {
+	permission = strings.ToLower(strings.TrimSpace(permission))
+	if permission == PermissionAll || permission == PermissionRecursiveDelete {
+		return true
+	}
+	for _, known := range AllPermissions {
+		if permission == known {
+			return true
+		}
+	}
+	return false
+}
+
+//Can reports if user has permission. defaults are permissions of user without Permissions (all if empty)
+func (U *User) Can(permission string, defaults []string) bool {
+	permissions := U.Permissions
+	if permissions == nil {
+		if len(defaults) == 0 {
+			return true
+		}
+		permissions = defaults
+	}
+	for _, granted := range permissions {
+		granted = strings.ToLower(strings.TrimSpace(granted))
+		if granted == permission || granted == PermissionAll {
+			return true
+		}
+	}
+	return false
+}
+
+//Grant gives permission to user. User without Permissions gets only granted ones
+func (U *User) Grant(permission string) error {
+	permission = strings.ToLower(strings.TrimSpace(permission))
+	if !ValidPermission(permission) {
+		return errors.New(fmt.Sprint("Unknown permission \"", permission, "\""))
+	}
+	if permission == PermissionRecursiveDelete {
+		U.RecursiveDelete = true
+		return nil
+	}
+	for _, granted := range U.Permissions {
+		if granted == permission {
+			return nil
+		}
+	}
+	U.Permissions = append(U.Permissions, permission)
+	return nil
+}
@@ -0,0 +1,64 @@
+package FTPAuth
+
+import "testing"
+
+func TestCan(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions []string
+		defaults    []string
+		allowed     []string
+		denied      []string
+	}{
+		{"no permissions, no defaults", nil, nil, AllPermissions, nil},
+		{"no permissions, empty defaults", nil, []string{}, AllPermissions, nil},
+		{"no permissions, read-only defaults", nil, ReadOnlyPermissions, ReadOnlyPermissions, []string{PermissionUpload, PermissionDelete, PermissionSite}},
+		{"no permissions, defaults", nil, []string{"upload", "LIST "}, []string{PermissionUpload, PermissionList}, []string{PermissionDownload, PermissionDelete}},
+		{"no permissions, all by default", nil, []string{PermissionAll}, AllPermissions, nil},
+		{"empty permissions", []string{}, []string{PermissionAll}, nil, AllPermissions},
+		{"own permissions", []string{PermissionDownload}, []string{PermissionAll}, []string{PermissionDownload}, []string{PermissionList, PermissionUpload}},
+		{"all", []string{"All"}, nil, AllPermissions, nil},
+		{"read-only", ReadOnlyPermissions, []string{PermissionAll}, ReadOnlyPermissions, []string{PermissionUpload, PermissionOverwrite, PermissionRename}},
+	}
+	for _, test := range tests {
+		user := &User{Permissions: test.permissions}
+		for _, permission := range test.allowed {
+			if !user.Can(permission, test.defaults) {
+				t.Errorf("%s: %s is denied", test.name, permission)
+			}
+		}
+		for _, permission := range test.denied {
+			if user.Can(permission, test.defaults) {
+				t.Errorf("%s: %s is allowed", test.name, permission)
+			}
+		}
+	}
+}
+
+func TestGrant(t *testing.T) {
+	user := &User{}
+	if !user.Can(PermissionUpload, nil) {
+		t.Fatalf("user without permissions has no access")
+	}
+	for _, permission := range []string{" Upload", "upload", PermissionRecursiveDelete} {
+		if err := user.Grant(permission); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := user.Grant("format"); err == nil {
+		t.Errorf("unknown permission granted")
+	}
+	//user with granted permissions has only them
+	if len(user.Permissions) != 1 || !user.Can(PermissionUpload, []string{PermissionAll}) || user.Can(PermissionList, nil) {
+		t.Errorf("permissions after grant: %v", user.Permissions)
+	}
+	if !user.RecursiveDelete {
+		t.Errorf("recursive delete is not granted")
+	}
+	//shared read-only permissions are not changed by grant
+	anonymous := &User{Permissions: ReadOnlyPermissions}
+	anonymous.Grant(PermissionDelete)
+	if len(ReadOnlyPermissions) != 2 || (&User{Permissions: ReadOnlyPermissions}).Can(PermissionDelete, nil) {
+		t.Errorf("grant changed ReadOnlyPermissions: %v", ReadOnlyPermissions)
+	}
+}
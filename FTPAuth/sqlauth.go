@@ -0,0 +1,91 @@
+// SQL database authentication backend (SQLite or other database/sql database)
+package FTPAuth
+
+import (
+	"FTPServ/FTPServConfig"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//DefaultSQLUsersQuery selects password hash and folder of user, folder may be NULL or empty
+const DefaultSQLUsersQuery = "SELECT password, folder FROM users WHERE username = ?"
+
+//DefaultSQLDriver is database/sql driver of "sqlite" backend: name of pure Go modernc.org/sqlite driver,
+//linked into server by blank import of main package. Other drivers are added to main package the same way and
+//selected by SQLDriver of config with their names ("sqlite3" of cgo github.com/mattn/go-sqlite3, "postgres",
+//"mysql"), query must use placeholders of driver
+const DefaultSQLDriver = "sqlite"
+
+//SQLAuthenticator checks users of SQL database. Query selects password hash (format of users.json)
+//and folder of user by user name. Folder is template of folder for users without it
+type SQLAuthenticator struct {
+	DB     *sql.DB
+	Query  string
+	Folder string
+}
+
+//NewSQLAuthenticator opens database with database/sql driver
+func NewSQLAuthenticator(driverName string, dataSource string, query string, folder string) (*SQLAuthenticator, error) {
+	registered := false
+	for _, name := range sql.Drivers() {
+		registered = registered || name == driverName
+	}
+	if !registered {
+		return nil, errors.New(fmt.Sprint("SQL driver \"", driverName, "\" is not linked into server (blank import of driver package is needed)"))
+	}
+	db, err := sql.Open(driverName, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if len(strings.TrimSpace(query)) == 0 {
+		query = DefaultSQLUsersQuery
+	}
+	return &SQLAuthenticator{DB: db, Query: query, Folder: folder}, nil
+}
+func newSQLAuthenticatorFromConfig(config *FTPServConfig.ConfigStorage, users *Users) (Authenticator, error) {
+	if len(config.SQLiteFile) == 0 {
+		return nil, errors.New("SQLiteFile is not set")
+	}
+	driverName := config.SQLDriver
+	if len(driverName) == 0 {
+		driverName = DefaultSQLDriver
+	}
+	return NewSQLAuthenticator(driverName, config.SQLiteFile, config.SQLUsersQuery, config.AuthUserFolder)
+}
+
+func (auth *SQLAuthenticator) Name() string {
+	return "sqlite"
+}
+
+//Lookup returns user with password hash from database
+func (auth *SQLAuthenticator) Lookup(userName string) (*User, error) {
+	var password, folder sql.NullString
+	err := auth.DB.QueryRow(auth.Query, userName).Scan(&password, &folder)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownUser
+	}
+	if err != nil {
+		return nil, err
+	}
+	user := &User{UserName: userName, Password: password.String, Folder: userFolder(auth.Folder, userName)}
+	if len(strings.TrimSpace(folder.String)) > 0 {
+		user.Folder = folder.String
+	}
+	return user, nil
+}
+func (auth *SQLAuthenticator) Authenticate(userName string, pswd string) (*User, error) {
+	user, err := auth.Lookup(userName)
+	if err != nil {
+		return nil, err
+	}
+	if !user.CheckPswd(pswd) {
+		return nil, ErrWrongPassword
+	}
+	return user, nil
+}
@@ -8,13 +8,12 @@ import (
 	"FTPServ/Logger"
 	"FTPServ/ftpfs"
 	"bufio"
-	"bytes"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
-	"runtime"
 	"strings"
+	"sync"
 )
 
 type FTPConnection struct {
@@ -29,19 +28,19 @@ type FTPConnection struct {
 	FileSystem           ftpfs.FileSystem
 	GlobalConfig         *FTPServConfig.ConfigStorage
 	ServerAddress        string
-	actionBuffer         FTPConnectionBuffer
+	Session              *Session
 	TLSConfig            *FTPtls.FTPTLSServerParameters
 	UsingTLS             bool
 	Logger               *Logger.LoggerConfig
 	ConnectionID         uint
+	//Authenticator checks USER and PASS
+	Authenticator FTPAuth.Authenticator
+	writerLock    sync.Mutex
+	//transfers - running RETR and STOR goroutines, connection is closed only after they end
+	transfers sync.WaitGroup
 }
-type FTPConnectionBuffer struct {
-	RenameObj *ftpfs.RenameableObj
-}
-
-var users *FTPAuth.Users
 
-func InitConnection(Connection net.Conn, serverAddr string, EndConnChannel chan string, ServerConfig *FTPServConfig.ConfigStorage, Users *FTPAuth.Users, TLSConfig *FTPtls.FTPTLSServerParameters, id uint) (*FTPConnection, error) {
+func InitConnection(Connection net.Conn, serverAddr string, EndConnChannel chan string, ServerConfig *FTPServConfig.ConfigStorage, Authenticator FTPAuth.Authenticator, TLSConfig *FTPtls.FTPTLSServerParameters, id uint) (*FTPConnection, error) {
 	FTPConn := new(FTPConnection)
 	if Connection == nil {
 		return nil, errors.New("Connection is nil")
@@ -51,14 +50,18 @@ func InitConnection(Connection net.Conn, serverAddr string, EndConnChannel chan
 	FTPConn.Reader = bufio.NewReader(Connection)
 	FTPConn.FTPConnClosedString = EndConnChannel
 	FTPConn.GlobalConfig = ServerConfig
-	users = Users
+	FTPConn.Authenticator = Authenticator
 	FTPConn.ServerAddress = serverAddr
 	dc, err := FTPDataTransfer.NewConnection(serverAddr, ServerConfig)
 	if err != nil {
 		return nil, err
 	}
+	if clientAddr, ok := Connection.RemoteAddr().(*net.TCPAddr); ok {
+		dc.ClientAddress = clientAddr.IP
+	}
 	FTPConn.DataConnection = dc
 	FTPConn.TLSConfig = TLSConfig
+	FTPConn.Session = NewSession()
 	//id, err := CBModule.GetCurrentConnCount()
 	//if err != nil {
 	FTPConn.ConnectionID = id
@@ -69,6 +72,9 @@ func InitConnection(Connection net.Conn, serverAddr string, EndConnChannel chan
 	return FTPConn, nil
 }
 func (FTPConn *FTPConnection) writeMessageToWriter(str string) {
+	//replies of running transfer are sent from another goroutine
+	FTPConn.writerLock.Lock()
+	defer FTPConn.writerLock.Unlock()
 	FTPConn.Writer.WriteString(fmt.Sprint(str, "\r\n"))
 	err := FTPConn.Writer.Flush()
 	if err != nil {
@@ -77,13 +83,18 @@ func (FTPConn *FTPConnection) writeMessageToWriter(str string) {
 }
 func (FTPConn *FTPConnection) sendResponseToClient(command string, comment interface{}) error {
 	defer FTPConn.Logger.Log(Logger.UserAction, "Command ", command, " sent to Client")
+	if text, ok := comment.(string); ok && strings.HasPrefix(text, "-") {
+		//multiline reply: "code-first line\r\n...\r\ncode last line"
+		FTPConn.writeMessageToWriter(fmt.Sprint(command, text))
+		return nil
+	}
 	switch command {
 	case "200":
 		FTPConn.writeMessageToWriter(fmt.Sprint("200 ", comment))
 	case "211":
 		fallthrough
 	case "213":
-		FTPConn.writeMessageToWriter(fmt.Sprint(command, comment))
+		FTPConn.writeMessageToWriter(fmt.Sprint(command, " ", comment))
 		break
 	case "215":
 		FTPConn.writeMessageToWriter(fmt.Sprint("215 ", "UNIX TYPE: L8"))
@@ -94,7 +105,7 @@ func (FTPConn *FTPConnection) sendResponseToClient(command string, comment inter
 	case "250":
 		FTPConn.writeMessageToWriter(fmt.Sprint("250 ", comment, ""))
 	case "257":
-		FTPConn.writeMessageToWriter(fmt.Sprint("257 \"", "/", "\" is current root"))
+		FTPConn.writeMessageToWriter(fmt.Sprint("257 ", comment))
 	case "331":
 		FTPConn.writeMessageToWriter("331 Password")
 	case "530":
@@ -104,14 +115,23 @@ func (FTPConn *FTPConnection) sendResponseToClient(command string, comment inter
 	}
 	return nil
 }
+
+//SendResponse writes reply to client. Use it from handlers registered with RegisterCommand
+func (FTPConn *FTPConnection) SendResponse(code string, comment interface{}) error {
+	return FTPConn.sendResponseToClient(code, comment)
+}
 func (FTPConn *FTPConnection) IsAuthenticated() bool {
-	return FTPConn.User != nil
+	return FTPConn.Session.IsLoggedIn()
 }
 func (FTPConn *FTPConnection) CloseConnection(TCPClosed bool) error {
 	//close DataConnection
 	//FTPConn.DataConnection.CloseConnection()
 	//check Connection closed
+	FTPConn.Session.Close()
 	if FTPConn.DataConnection != nil {
+		//running transfer uses data connection: it is stopped first
+		FTPConn.DataConnection.Abort()
+		FTPConn.transfers.Wait()
 		FTPConn.DataConnection.CloseConnection()
 		FTPConn.DataConnection = nil
 	}
@@ -125,6 +145,16 @@ func (FTPConn *FTPConnection) CloseConnection(TCPClosed bool) error {
 	FTPConn.Logger.Log(Logger.UserAction, "Connection closed")
 	return nil
 }
+//startTransfer runs transfer in background, so ABOR can be read while data is transferred
+func (FTPConn *FTPConnection) startTransfer(transfer func()) {
+	FTPConn.Session.BeginTransfer()
+	FTPConn.DataConnection.ClearAbort()
+	FTPConn.transfers.Add(1)
+	go func() {
+		defer FTPConn.transfers.Done()
+		transfer()
+	}()
+}
 func (FTPConn *FTPConnection) InitTLSConnection() error {
 	conn := tls.Server(FTPConn.TCPConn, FTPConn.TLSConfig.TLSConfig)
 	if conn == nil {
@@ -142,340 +172,29 @@ func (FTPConn *FTPConnection) InitTLSConnection() error {
 func (FTPConn *FTPConnection) ParseIncomingConnection() {
 	FTPConn.sendResponseToClient("220", "")
 	for {
-		reader := make([]byte, 512)
-		_, err := FTPConn.TCPConn.Read(reader)
+		command, err := readControlLine(FTPConn.Reader, MaxCommandLineLength)
+		if err == ErrCommandLineTooLong {
+			FTPConn.Logger.Log(Logger.UserAction, "Command line too long, skipped")
+			FTPConn.sendResponseToClient("500", "Command line too long")
+			continue
+		}
 		if err != nil {
-			FTPConn.Logger.Log(Logger.CriticalMessage, "parseIncomingConnection, Conn.Read error: ", err, "\r\nConnection closed.")
+			FTPConn.Logger.Log(Logger.CriticalMessage, "parseIncomingConnection, read error: ", err, "\r\nConnection closed.")
 			FTPConn.CloseConnection(false)
 			return
 		}
-		reader = bytes.Trim(reader, "\x00")
-		input := string(reader)
-		commands := strings.Split(input, "\r\n")
-		for _, command := range commands {
-			if len(strings.TrimSpace(command)) == 0 {
-				continue
-			}
+		if len(strings.TrimSpace(command)) == 0 {
+			continue
+		}
+		if verb, _ := splitCommandLine(command); verb == "PASS" {
+			FTPConn.Logger.Log(Logger.UserAction, "Got command: PASS ****")
+		} else {
 			FTPConn.Logger.Log(Logger.UserAction, fmt.Sprint("Got command: ", command))
-			triSymbolCommand := command[:3]
-			switch string(triSymbolCommand) {
-			case "CCC":
-				break
-			case "CWD":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					break
-				}
-				if len(command) <= 3 {
-					FTPConn.sendResponseToClient("550", "No path specified")
-					break
-				}
-				directory := command[4:]
-				err := FTPConn.FileSystem.CWD(directory)
-				if err != nil {
-					if err.Error() == "Not a dir" {
-						FTPConn.sendResponseToClient("550", "Not a directory")
-						break
-					}
-					FTPConn.Logger.Log(Logger.CriticalMessage, "CWD: ", err)
-					FTPConn.sendResponseToClient("550", "Couldn't get directory")
-				}
-				FTPConn.sendResponseToClient("250", "DirectoryChanged")
-				break
-			case "ENC":
-				break
-			case "MFF":
-				break
-			case "MIC":
-				break
-			case "MKD":
-				if len(command) <= 3 {
-					FTPConn.sendResponseToClient("550", "No directory name in args")
-					break
-				}
-				dirName := command[4:]
-				err := FTPConn.FileSystem.MakeDir(dirName)
-				if err != nil {
-					FTPConn.sendResponseToClient("550", "Couldn't create specified directory")
-					break
-				}
-				FTPConn.sendResponseToClient("250", fmt.Sprint("Directory ", dirName, " created!"))
-				break
-			case "PWD":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					break
-				}
-				FTPConn.sendResponseToClient("257", "/")
-				break
-			case "RMD":
-				break
-			}
-			if len(command) <= 3 {
-				continue
-			}
-			fourSymbolCommand := command[:4]
-			switch string(fourSymbolCommand) {
-			case "FEAT":
-				FTPConn.sendResponseToClient("211", "-Server feature:\r\n SIZE\r\n AUTH\r\n STOR\r\n211 END")
-			case "LIST":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					break
-				}
-				var key string
-				if len(command) >= 7 {
-					key = command[6:]
-				}
-				_ = key
-				listing, err := FTPConn.FileSystem.LIST("")
-				if err != nil {
-					if err.Error() == "Not a dir" {
-						FTPConn.sendResponseToClient("550", "Not a directory")
-						break
-					}
-				}
-				FTPConn.sendResponseToClient("150", "Here comes the directory listing")
-				sendingdir := strings.Join(listing, "\r\n")
-				err = FTPConn.DataConnection.TransferASCIIData(sendingdir)
-				if err != nil {
-					FTPConn.DataConnection.CloseConnection()
-					FTPConn.sendResponseToClient("550", "Could not send data")
-					FTPConn.Logger.Log(Logger.CriticalMessage, "Couldn't send LIST data (key -l): ", err)
-					break
-				}
-				FTPConn.sendResponseToClient("226", "Directory sent OK")
-				break
-			case "PASV":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					break
-				}
-				FTPConn.DataConnection.UsingTLS = FTPConn.UsingTLS
-				FTPConn.DataConnection.TLSConfig = FTPConn.TLSConfig
-				passPortAddress, err := FTPConn.DataConnection.InitPassiveConnection()
-				if err != nil {
-					FTPConn.Logger.Log(Logger.CriticalMessage, "PASV: couldn't open passive port...", err)
-					FTPConn.sendResponseToClient("425", "PASV start error...")
-					break
-				}
-				FTPConn.sendResponseToClient("227", fmt.Sprint("Entering Passive Mode (", passPortAddress, ")."))
-			case "TYPE":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					break
-				}
-				sendType := command[5:]
-				FTPConn.TransferType = sendType
-				FTPConn.sendResponseToClient("200", "Set type successful!")
-			case "SIZE":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					break
-				}
-				path := command[5:]
-				size, err := FTPConn.FileSystem.GetFileSize(path)
-				if err != nil {
-					FTPConn.sendResponseToClient("550", "Could not get file size")
-					break
-				}
-				FTPConn.sendResponseToClient("213", fmt.Sprint(" ", size))
-			case "STAT":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					break
-				}
-				path := command[5:]
-				stat, err := FTPConn.FileSystem.STAT(path)
-				if err != nil {
-					FTPConn.Logger.Log(Logger.CriticalMessage, "STAT error: ", err)
-					FTPConn.sendResponseToClient("550", "Couldn't get STAT")
-				}
-				FTPConn.sendResponseToClient("213", "-Status")
-				FTPConn.sendResponseToClient(stat, "")
-				FTPConn.sendResponseToClient("213", " End of status")
-			case "PBSZ":
-				FTPConn.sendResponseToClient("200", "OK")
-			case "PROT":
-				FTPConn.sendResponseToClient("200", "OK")
-			case "AUTH":
-				if len(command) <= 5 {
-					FTPConn.sendResponseToClient("500", "No protocol type specified")
-				}
-				if FTPConn.UsingTLS {
-					FTPConn.sendResponseToClient("500", "Already using TLS...")
-				}
-				Authtype := command[5:]
-				FTPConn.Logger.Log(Logger.UserAction, "Client asks for protection using: ", Authtype)
-				switch strings.ToUpper(Authtype) {
-				case "TLS":
-					fallthrough
-				case "SSL":
-					FTPConn.sendResponseToClient("234", "")
-					if err := FTPConn.InitTLSConnection(); err != nil {
-						FTPConn.sendResponseToClient("500", "Couldn't use TLS...")
-						FTPConn.Logger.Log(Logger.CriticalMessage, "TLS error: ", err)
-						break
-					}
-					FTPConn.UsingTLS = true
-				}
-			case "RNFR":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					break
-				}
-				oldName := command[5:]
-				renameobj, err := FTPConn.FileSystem.NewRenameableObj(oldName)
-				if err != nil {
-					FTPConn.sendResponseToClient("550", "Can't rename obj")
-					FTPConn.Logger.Log(Logger.CriticalMessage, "Rename object error: ", err)
-					break
-				}
-				FTPConn.actionBuffer.RenameObj = renameobj
-				FTPConn.sendResponseToClient("350", "Waiting for RNTO")
-			case "RNTO":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					break
-				}
-				newName := command[5:]
-				if FTPConn.actionBuffer.RenameObj == nil {
-					FTPConn.sendResponseToClient("550", "No RNFR command executed")
-					break
-				}
-				FTPConn.actionBuffer.RenameObj.NewName = newName
-				err := FTPConn.FileSystem.Rename(FTPConn.actionBuffer.RenameObj)
-				if err != nil {
-					FTPConn.sendResponseToClient("550", "Couldn't rename object")
-					FTPConn.Logger.Log(Logger.CriticalMessage, "RNTO error: ", err)
-					break
-				}
-				FTPConn.sendResponseToClient("250", "Object renamed")
-			case "STOR":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					break
-				}
-				fileName := command[5:]
-				file, err := FTPConn.FileSystem.STOR(fileName)
-				if err != nil {
-					FTPConn.sendResponseToClient("550", "Can't create new specified file")
-					FTPConn.Logger.Log(Logger.CriticalMessage, "STOR error: ", err)
-					break
-				}
-				FTPConn.sendResponseToClient("150", "Ready to receive data")
-				err = FTPConn.DataConnection.ReceiveBinaryFile(file.Name())
-				if err != nil {
-					FTPConn.Logger.Log(Logger.CriticalMessage, "STOR error (receiving data): ", err)
-					FTPConn.sendResponseToClient("550", "Can't write specified data")
-					break
-				}
-				FTPConn.sendResponseToClient("226", "File transfer complete")
-			case "MFMT":
-				FTPConn.sendResponseToClient("500", "Not implemented")
-			case "USER":
-				//new user
-				userName := bytes.Trim(reader[5:], "\n")
-				userName = bytes.Trim(userName, "\r")
-				userNameStr := string(userName)
-				if strings.ToLower(userNameStr) == "anonymous" {
-					if FTPConn.GlobalConfig.Anonymous == false {
-						FTPConn.sendResponseToClient("530", "")
-						//FTPConn.CloseConnection()
-						break
-					} else {
-						FTPConn.sendResponseToClient("230", "")
-						break
-					}
-				}
-				user := users.CheckUserName(userNameStr)
-				if user == nil {
-					FTPConn.Logger.Log(Logger.UserAction, "Command \"USER\": wrong user name!")
-					FTPConn.sendResponseToClient("430", "Wrong username")
-					break
-				}
-				FTPConn.User = user
-				FTPConn.sendResponseToClient("331", "")
-				break
-			case "PASS":
-				pswd := command[5:]
-				if FTPConn.User == nil {
-					FTPConn.sendResponseToClient("430", "Wrong username")
-					break
-				}
-				if FTPConn.User.CheckPswd(pswd) == false {
-					FTPConn.sendResponseToClient("430", "Wrong password")
-					FTPConn.User = nil
-					break
-				}
-				//костыль, лень переделывать было
-				//id := CBModule.RegConnection(FTPConn.User.UserName, FTPConn.TCPConn.RemoteAddr().String(), time.Now())
-				//FTPConn.ConnectionID = id
-				//FTPConn.Logger.ConnID = id
-				FTPConn.FileSystem.InitFileSystem(FTPConn.GlobalConfig, FTPConn.User)
-				FTPConn.sendResponseToClient("230", "Authenticated")
-				//new pass
-				break
-			case "PORT":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					break
-				}
-				port := command[5:]
-				err := FTPConn.DataConnection.InitActiveConnection(port)
-				if err != nil {
-					FTPConn.sendResponseToClient("550", fmt.Sprint("Dialing active port error: ", err))
-					break
-				}
-				FTPConn.sendResponseToClient("200", fmt.Sprint("PORT command done", FTPConn.DataConnection.FTPActiveDataConnection.DataPortAddress.String()))
-			case "RETR":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					return
-				}
-				fileName := command[5:]
-				file, err := FTPConn.FileSystem.RETR(fileName)
-				if err != nil {
-					FTPConn.Logger.Log(Logger.CriticalMessage, "RETR Command, fsRETR error: ", err)
-					FTPConn.sendResponseToClient("550", "File transfer error")
-					return
-				}
-				FTPConn.sendResponseToClient("150", fmt.Sprint("Opening binary stream for", fileName))
-				go func() {
-					err = FTPConn.DataConnection.TransferBinaryFile(file)
-					if err != nil {
-						FTPConn.Logger.Log(Logger.CriticalMessage, "RETR command error: ", err)
-						FTPConn.sendResponseToClient("550", "File transfer error")
-						return
-					}
-					FTPConn.sendResponseToClient("226", "Transfer complete")
-				}()
-			case "SYST":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					break
-				}
-				FTPConn.sendResponseToClient("215", runtime.GOOS)
-			case "ABOR":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					break
-				}
-				if FTPConn.DataConnection.DataConnectionsClosed() {
-					FTPConn.sendResponseToClient("226", "Transfer completed. Data Conn closed")
-				} else {
-					FTPConn.sendResponseToClient("225", "Data conn opened. Trying to abort data transfer")
-					FTPConn.DataConnection.DataTranserAbort = true
-				}
-			case "QUIT":
-				if FTPConn.IsAuthenticated() == false {
-					FTPConn.sendResponseToClient("530", "Not logged in")
-					break
-				}
-				FTPConn.Logger.Log(Logger.CriticalMessage, "Closing connection")
-				FTPConn.CloseConnection(true)
-				return
-			}
+		}
+		FTPConn.executeCommand(command)
+		if FTPConn.TCPConn == nil {
+			//connection closed by command (QUIT)
+			return
 		}
 	}
 }
@@ -0,0 +1,750 @@
+package FTPClientConnection
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/FTPDataTransfer"
+	"FTPServ/FTPServConfig"
+	"FTPServ/Logger"
+	"FTPServ/ftpfs"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	builtinCommands := map[string]*FTPCommand{
+		"USER": {Handler: commandUSER, Argument: ArgumentRequired, States: NotLoggedInStates},
+		"PASS": {Handler: commandPASS, Argument: ArgumentOptional, States: States(SessionAwaitingPass)},
+		"QUIT": {Handler: commandQUIT, Argument: ArgumentNone, States: AnyState},
+		"AUTH": {Handler: commandAUTH, Argument: ArgumentRequired, Feature: featureAUTH},
+		"PBSZ": {Handler: commandPBSZ, Argument: ArgumentRequired, Feature: featurePBSZ},
+		"PROT": {Handler: commandPROT, Argument: ArgumentRequired, Feature: featurePROT},
+		"FEAT": {Handler: commandFEAT, Argument: ArgumentNone},
+		"OPTS": {Handler: commandOPTS, Argument: ArgumentRequired, Feature: StaticFeature("UTF8")},
+		"SYST": {Handler: commandSYST, Argument: ArgumentNone},
+		"NOOP": {Handler: commandNOOP, Argument: ArgumentNone, States: AnyState, KeepsRestOffset: true},
+		"HELP": {Handler: commandHELP, Argument: ArgumentOptional},
+		"ACCT": {Handler: commandACCT, Argument: ArgumentRequired},
+		"REIN": {Handler: commandREIN, Argument: ArgumentNone, States: AnyState},
+		"ALLO": {Handler: commandALLO, RequiresAuth: true, Argument: ArgumentRequired, KeepsRestOffset: true},
+		"MODE": {Handler: commandMODE, RequiresAuth: true, Argument: ArgumentRequired, KeepsRestOffset: true},
+		"STRU": {Handler: commandSTRU, RequiresAuth: true, Argument: ArgumentRequired, KeepsRestOffset: true},
+		"TYPE": {Handler: commandTYPE, RequiresAuth: true, Argument: ArgumentRequired, KeepsRestOffset: true},
+		"PWD":  {Handler: commandPWD, RequiresAuth: true, Argument: ArgumentNone},
+		"CWD":  {Handler: commandCWD, RequiresAuth: true, Argument: ArgumentRequired},
+		"CDUP": {Handler: commandCDUP, RequiresAuth: true, Argument: ArgumentNone},
+		"MKD":  {Handler: commandMKD, RequiresAuth: true, Argument: ArgumentRequired, Permission: FTPAuth.PermissionMkdir},
+		"RMD":  {Handler: commandRMD, RequiresAuth: true, Argument: ArgumentRequired, Permission: FTPAuth.PermissionRmdir},
+		"DELE": {Handler: commandDELE, RequiresAuth: true, Argument: ArgumentRequired, Permission: FTPAuth.PermissionDelete},
+		"LIST": {Handler: commandLIST, RequiresAuth: true, Argument: ArgumentOptional, Permission: FTPAuth.PermissionList},
+		"NLST": {Handler: commandNLST, RequiresAuth: true, Argument: ArgumentOptional, Permission: FTPAuth.PermissionList},
+		"MLSD": {Handler: commandMLSD, RequiresAuth: true, Argument: ArgumentOptional, Permission: FTPAuth.PermissionList},
+		"MLST": {Handler: commandMLST, RequiresAuth: true, Argument: ArgumentOptional, Permission: FTPAuth.PermissionList, Feature: featureMLST},
+		"MDTM": {Handler: commandMDTM, RequiresAuth: true, Argument: ArgumentRequired, Permission: FTPAuth.PermissionList, Feature: StaticFeature("MDTM"), KeepsRestOffset: true},
+		"MFMT": {Handler: commandMFMT, RequiresAuth: true, Argument: ArgumentRequired, Permission: FTPAuth.PermissionChmod, Feature: StaticFeature("MFMT")},
+		"MFCT": {Handler: commandMFCT, RequiresAuth: true, Argument: ArgumentRequired, Permission: FTPAuth.PermissionChmod, Feature: featureMFCT},
+		"SIZE": {Handler: commandSIZE, RequiresAuth: true, Argument: ArgumentRequired, Permission: FTPAuth.PermissionList, Feature: StaticFeature("SIZE"), KeepsRestOffset: true},
+		"STAT": {Handler: commandSTAT, RequiresAuth: true, Argument: ArgumentOptional, States: LoggedInStates | States(SessionTransferInProgress)},
+		"PASV": {Handler: commandPASV, RequiresAuth: true, Argument: ArgumentNone, KeepsRestOffset: true},
+		"PORT": {Handler: commandPORT, RequiresAuth: true, Argument: ArgumentRequired, KeepsRestOffset: true},
+		"EPSV": {Handler: commandEPSV, RequiresAuth: true, Argument: ArgumentOptional, KeepsRestOffset: true, Feature: StaticFeature("EPSV")},
+		"EPRT": {Handler: commandEPRT, RequiresAuth: true, Argument: ArgumentRequired, KeepsRestOffset: true, Feature: StaticFeature("EPRT")},
+		"RNFR": {Handler: commandRNFR, RequiresAuth: true, Argument: ArgumentRequired, Permission: FTPAuth.PermissionRename},
+		"RNTO": {Handler: commandRNTO, RequiresAuth: true, Argument: ArgumentRequired, Permission: FTPAuth.PermissionRename, States: States(SessionRenamePending)},
+		"REST": {Handler: commandREST, RequiresAuth: true, Argument: ArgumentRequired, Feature: StaticFeature("REST STREAM")},
+		"STOR": {Handler: commandSTOR, RequiresAuth: true, Argument: ArgumentRequired, Permission: FTPAuth.PermissionUpload, KeepsRestOffset: true},
+		"APPE": {Handler: commandAPPE, RequiresAuth: true, Argument: ArgumentRequired, Permission: FTPAuth.PermissionUpload, KeepsRestOffset: true},
+		"STOU": {Handler: commandSTOU, RequiresAuth: true, Argument: ArgumentOptional, Permission: FTPAuth.PermissionUpload},
+		"RETR": {Handler: commandRETR, RequiresAuth: true, Argument: ArgumentRequired, Permission: FTPAuth.PermissionDownload, KeepsRestOffset: true},
+		"ABOR": {Handler: commandABOR, RequiresAuth: true, Argument: ArgumentNone, States: LoggedInStates | States(SessionTransferInProgress)},
+		//known, but not implemented yet: answered with 502
+		"CCC": {},
+		"ENC": {},
+		"MIC": {},
+		"MFF": {},
+	}
+	//RFC 775 X-prefixed aliases
+	builtinCommands["XPWD"] = builtinCommands["PWD"]
+	builtinCommands["XCWD"] = builtinCommands["CWD"]
+	builtinCommands["XCUP"] = builtinCommands["CDUP"]
+	builtinCommands["XMKD"] = builtinCommands["MKD"]
+	builtinCommands["XRMD"] = builtinCommands["RMD"]
+	for verb, command := range builtinCommands {
+		RegisterCommand(verb, command)
+	}
+}
+
+func commandUSER(FTPConn *FTPConnection, args string) {
+	if strings.ToLower(args) == "anonymous" {
+		if FTPConn.GlobalConfig.Anonymous == false {
+			FTPConn.sendResponseToClient("530", "")
+			return
+		}
+		//anonymous user gets only own folder and can't change anything there
+		user := &FTPAuth.User{UserName: "anonymous", Folder: FTPConn.GlobalConfig.AnonymousFolder,
+			Permissions: FTPAuth.ReadOnlyPermissions}
+		if len(strings.TrimSpace(user.Folder)) == 0 {
+			user.Folder = FTPServConfig.DefaultAnonymousFolder
+		}
+		if err := FTPConn.FileSystem.InitFileSystem(FTPConn.GlobalConfig, user); err != nil {
+			FTPConn.Logger.Log(Logger.CriticalMessage, "Couldn't open storage of anonymous user: ", err)
+			FTPConn.sendResponseToClient("530", "")
+			return
+		}
+		FTPConn.User = user
+		FTPConn.Session.LoggedIn()
+		FTPConn.sendResponseToClient("230", "")
+		return
+	}
+	//user is checked with password, so client can't learn which users exist
+	FTPConn.Session.UserAccepted(args)
+	FTPConn.sendResponseToClient("331", "")
+}
+func commandPASS(FTPConn *FTPConnection, args string) {
+	user, err := FTPConn.Authenticator.Authenticate(FTPConn.Session.PendingUser(), args)
+	if err != nil {
+		FTPConn.Session.LoginFailed()
+		FTPConn.Logger.Log(Logger.UserAction, "Command \"PASS\": login incorrect (", err, ")")
+		FTPConn.sendResponseToClient("530", "Login incorrect")
+		return
+	}
+	if err := FTPConn.FileSystem.InitFileSystem(FTPConn.GlobalConfig, user); err != nil {
+		FTPConn.Session.LoginFailed()
+		FTPConn.Logger.Log(Logger.CriticalMessage, "Couldn't open storage of user ", user.UserName, ": ", err)
+		FTPConn.sendResponseToClient("530", "")
+		return
+	}
+	FTPConn.User = user
+	FTPConn.Session.LoggedIn()
+	FTPConn.sendResponseToClient("230", "Authenticated")
+}
+func commandQUIT(FTPConn *FTPConnection, args string) {
+	FTPConn.Logger.Log(Logger.CriticalMessage, "Closing connection")
+	//RFC 959: running transfer is finished before connection is closed
+	FTPConn.transfers.Wait()
+	FTPConn.sendResponseToClient("221", "Goodbye")
+	FTPConn.CloseConnection(true)
+}
+func commandAUTH(FTPConn *FTPConnection, args string) {
+	if FTPConn.UsingTLS {
+		FTPConn.sendResponseToClient("503", "Already using TLS...")
+		return
+	}
+	if FTPConn.TLSConfig == nil {
+		FTPConn.sendResponseToClient("431", "TLS is not configured on server")
+		return
+	}
+	FTPConn.Logger.Log(Logger.UserAction, "Client asks for protection using: ", args)
+	switch strings.ToUpper(args) {
+	case "TLS", "TLS-C", "SSL":
+		FTPConn.sendResponseToClient("234", "")
+		if err := FTPConn.InitTLSConnection(); err != nil {
+			FTPConn.sendResponseToClient("500", "Couldn't use TLS...")
+			FTPConn.Logger.Log(Logger.CriticalMessage, "TLS error: ", err)
+			return
+		}
+		FTPConn.UsingTLS = true
+	default:
+		FTPConn.sendResponseToClient("504", "Unknown security mechanism")
+	}
+}
+func commandPBSZ(FTPConn *FTPConnection, args string) {
+	FTPConn.sendResponseToClient("200", "OK")
+}
+func commandPROT(FTPConn *FTPConnection, args string) {
+	FTPConn.sendResponseToClient("200", "OK")
+}
+func featureAUTH(FTPConn *FTPConnection) string {
+	if FTPConn.TLSConfig == nil {
+		return ""
+	}
+	return "AUTH TLS"
+}
+func featurePBSZ(FTPConn *FTPConnection) string {
+	if FTPConn.TLSConfig == nil {
+		return ""
+	}
+	return "PBSZ"
+}
+func featurePROT(FTPConn *FTPConnection) string {
+	if FTPConn.TLSConfig == nil {
+		return ""
+	}
+	return "PROT"
+}
+func featureMLST(FTPConn *FTPConnection) string {
+	return ftpfs.MLSTFeature(FTPConn.Session.MLSTFacts())
+}
+func commandFEAT(FTPConn *FTPConnection, args string) {
+	features := ""
+	for _, feature := range FTPConn.features() {
+		features = fmt.Sprint(features, " ", feature, "\r\n")
+	}
+	FTPConn.sendResponseToClient("211", fmt.Sprint("-Features:\r\n", features, "211 End"))
+}
+func commandOPTS(FTPConn *FTPConnection, args string) {
+	option, value := splitCommandLine(args)
+	switch option {
+	case "UTF8":
+		FTPConn.sendResponseToClient("200", "UTF8 always enabled")
+	case "MLST":
+		facts := ftpfs.SelectMLSTFacts(value)
+		FTPConn.Session.SetMLSTFacts(facts)
+		FTPConn.sendResponseToClient("200", fmt.Sprint("MLST OPTS ", strings.Join(facts, ";"), ";"))
+	default:
+		FTPConn.sendResponseToClient("501", fmt.Sprint("Option ", option, " not supported"))
+	}
+}
+func commandSYST(FTPConn *FTPConnection, args string) {
+	FTPConn.sendResponseToClient("215", runtime.GOOS)
+}
+func commandNOOP(FTPConn *FTPConnection, args string) {
+	FTPConn.sendResponseToClient("200", "OK")
+}
+func commandHELP(FTPConn *FTPConnection, args string) {
+	if len(args) != 0 {
+		verb, _ := splitCommandLine(args)
+		command := LookupCommand(verb)
+		if command == nil || command.Handler == nil {
+			FTPConn.sendResponseToClient("502", fmt.Sprint("Unknown command ", verb))
+			return
+		}
+		FTPConn.sendResponseToClient("214", fmt.Sprint("Command ", verb, " is supported"))
+		return
+	}
+	commandsRegistryLock.RLock()
+	verbs := make([]string, 0, len(commandsRegistry))
+	for verb, command := range commandsRegistry {
+		if command.Handler != nil {
+			verbs = append(verbs, verb)
+		}
+	}
+	commandsRegistryLock.RUnlock()
+	sort.Strings(verbs)
+	help := "-The following commands are recognized:\r\n"
+	for i := 0; i < len(verbs); i += 8 {
+		end := i + 8
+		if end > len(verbs) {
+			end = len(verbs)
+		}
+		help = fmt.Sprint(help, " ", strings.Join(verbs[i:end], " "), "\r\n")
+	}
+	FTPConn.sendResponseToClient("214", fmt.Sprint(help, "214 Help OK"))
+}
+func commandACCT(FTPConn *FTPConnection, args string) {
+	FTPConn.sendResponseToClient("202", "ACCT not needed on this server")
+}
+
+//commandREIN logs user out and returns connection to state after greeting.
+//Running transfer is finished first (RFC 959), it uses FileSystem of logged in user
+func commandREIN(FTPConn *FTPConnection, args string) {
+	FTPConn.transfers.Wait()
+	FTPConn.DataConnection.CloseConnection()
+	FTPConn.Session.Reset()
+	FTPConn.User = nil
+	FTPConn.FileSystem = ftpfs.FileSystem{}
+	FTPConn.TransferType = ""
+	FTPConn.sendResponseToClient("220", "")
+}
+func commandALLO(FTPConn *FTPConnection, args string) {
+	FTPConn.sendResponseToClient("202", "No storage allocation necessary")
+}
+func commandMODE(FTPConn *FTPConnection, args string) {
+	if strings.ToUpper(args) != "S" {
+		FTPConn.sendResponseToClient("504", "Only stream mode (S) is supported")
+		return
+	}
+	FTPConn.sendResponseToClient("200", "Mode set to S")
+}
+func commandSTRU(FTPConn *FTPConnection, args string) {
+	if strings.ToUpper(args) != "F" {
+		FTPConn.sendResponseToClient("504", "Only file structure (F) is supported")
+		return
+	}
+	FTPConn.sendResponseToClient("200", "Structure set to F")
+}
+func commandTYPE(FTPConn *FTPConnection, args string) {
+	switch strings.ToUpper(strings.Join(strings.Fields(args), " ")) {
+	case "A", "A N":
+		FTPConn.TransferType = "A"
+	case "I", "L 8":
+		FTPConn.TransferType = "I"
+	case "E", "E N", "A T", "A C":
+		FTPConn.sendResponseToClient("504", "Type not supported")
+		return
+	default:
+		FTPConn.sendResponseToClient("501", "Unknown type")
+		return
+	}
+	FTPConn.sendResponseToClient("200", fmt.Sprint("Type set to ", FTPConn.TransferType))
+}
+
+//quotePath doubles quotes in path for 257 reply (RFC 959)
+func quotePath(path string) string {
+	return fmt.Sprint("\"", strings.Replace(path, "\"", "\"\"", -1), "\"")
+}
+func commandPWD(FTPConn *FTPConnection, args string) {
+	FTPConn.sendResponseToClient("257", fmt.Sprint(quotePath(FTPConn.FileSystem.WorkingDirectory()), " is current directory"))
+}
+func commandCDUP(FTPConn *FTPConnection, args string) {
+	if err := FTPConn.FileSystem.CDUP(); err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "CDUP: ", err)
+		FTPConn.sendResponseToClient("550", "Couldn't change directory")
+		return
+	}
+	FTPConn.sendResponseToClient("250", "DirectoryChanged")
+}
+func commandCWD(FTPConn *FTPConnection, args string) {
+	err := FTPConn.FileSystem.CWD(args)
+	if err != nil {
+		if err.Error() == "Not a dir" {
+			FTPConn.sendResponseToClient("550", "Not a directory")
+			return
+		}
+		FTPConn.Logger.Log(Logger.CriticalMessage, "CWD: ", err)
+		FTPConn.sendResponseToClient("550", "Couldn't get directory")
+		return
+	}
+	FTPConn.sendResponseToClient("250", "DirectoryChanged")
+}
+func commandMKD(FTPConn *FTPConnection, args string) {
+	err := FTPConn.FileSystem.MakeDir(args)
+	if err != nil {
+		FTPConn.sendResponseToClient("550", "Couldn't create specified directory")
+		return
+	}
+	FTPConn.sendResponseToClient("257", fmt.Sprint(quotePath(FTPConn.FileSystem.VirtualPath(args)), " created"))
+}
+func commandRMD(FTPConn *FTPConnection, args string) {
+	err := FTPConn.FileSystem.RMD(args)
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "RMD error: ", err)
+		if err.Error() == "Not a dir" {
+			FTPConn.sendResponseToClient("550", "Not a directory")
+			return
+		}
+		FTPConn.sendResponseToClient("550", "Couldn't remove directory")
+		return
+	}
+	FTPConn.sendResponseToClient("250", "Directory removed")
+}
+func commandDELE(FTPConn *FTPConnection, args string) {
+	err := FTPConn.FileSystem.DELE(args)
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "DELE error: ", err)
+		if err.Error() == "Is a folder" {
+			FTPConn.sendResponseToClient("550", "Is a directory, use RMD")
+			return
+		}
+		FTPConn.sendResponseToClient("550", "Couldn't delete file")
+		return
+	}
+	FTPConn.sendResponseToClient("250", "File deleted")
+}
+func commandLIST(FTPConn *FTPConnection, args string) {
+	options, path := ftpfs.ParseListArgs(args)
+	options.Long = true
+	listing, err := FTPConn.FileSystem.LIST(path, options)
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "LIST error: ", err)
+		FTPConn.sendResponseToClient("550", "Couldn't list directory")
+		return
+	}
+	FTPConn.sendResponseToClient("150", "Here comes the directory listing")
+	sendingdir := strings.Join(listing, "\r\n")
+	err = FTPConn.DataConnection.TransferASCIIData(sendingdir)
+	if err != nil {
+		FTPConn.DataConnection.CloseConnection()
+		FTPConn.sendResponseToClient("425", "Could not send data")
+		FTPConn.Logger.Log(Logger.CriticalMessage, "Couldn't send LIST data: ", err)
+		return
+	}
+	FTPConn.sendResponseToClient("226", "Directory sent OK")
+}
+func commandNLST(FTPConn *FTPConnection, args string) {
+	options, path := ftpfs.ParseListArgs(args)
+	listing, err := FTPConn.FileSystem.LIST(path, options)
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "NLST error: ", err)
+		FTPConn.sendResponseToClient("550", "Couldn't list directory")
+		return
+	}
+	FTPConn.sendResponseToClient("150", "Here comes the directory listing")
+	err = FTPConn.DataConnection.TransferASCIIData(strings.Join(listing, "\r\n"))
+	if err != nil {
+		FTPConn.sendResponseToClient("425", "Could not send data")
+		FTPConn.Logger.Log(Logger.CriticalMessage, "Couldn't send NLST data: ", err)
+		return
+	}
+	FTPConn.sendResponseToClient("226", "Directory sent OK")
+}
+func commandMLST(FTPConn *FTPConnection, args string) {
+	facts, err := FTPConn.FileSystem.MLST(args, FTPConn.Session.MLSTFacts())
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "MLST error: ", err)
+		FTPConn.sendResponseToClient("550", "Couldn't get object facts")
+		return
+	}
+	FTPConn.sendResponseToClient("250", fmt.Sprint("-Listing ", args, "\r\n ", facts, "\r\n250 End"))
+}
+func commandMLSD(FTPConn *FTPConnection, args string) {
+	listing, err := FTPConn.FileSystem.MLSD(args, FTPConn.Session.MLSTFacts())
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "MLSD error: ", err)
+		if err.Error() == "Not a dir" {
+			FTPConn.sendResponseToClient("501", "Not a directory")
+			return
+		}
+		FTPConn.sendResponseToClient("550", "Couldn't list directory")
+		return
+	}
+	FTPConn.sendResponseToClient("150", "Here comes the directory listing")
+	err = FTPConn.DataConnection.TransferASCIIData(strings.Join(listing, "\r\n"))
+	if err != nil {
+		FTPConn.sendResponseToClient("425", "Could not send data")
+		FTPConn.Logger.Log(Logger.CriticalMessage, "Couldn't send MLSD data: ", err)
+		return
+	}
+	FTPConn.sendResponseToClient("226", "Directory sent OK")
+}
+func featureMFCT(FTPConn *FTPConnection) string {
+	if !FTPConn.FileSystem.CreateTimeSupported() {
+		return ""
+	}
+	return "MFCT"
+}
+func commandMDTM(FTPConn *FTPConnection, args string) {
+	modTime, err := FTPConn.FileSystem.MDTM(args)
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "MDTM error: ", err)
+		FTPConn.sendResponseToClient("550", "Could not get modification time")
+		return
+	}
+	FTPConn.sendResponseToClient("213", ftpfs.FormatFTPTime(modTime))
+}
+
+//parseTimeAndPath splits "YYYYMMDDHHMMSS path" argument of MFMT and MFCT
+func parseTimeAndPath(args string) (time.Time, string, error) {
+	fields := strings.SplitN(args, " ", 2)
+	if len(fields) != 2 || len(strings.TrimSpace(fields[1])) == 0 {
+		return time.Time{}, "", errors.New("No path specified")
+	}
+	t, err := ftpfs.ParseFTPTime(fields[0])
+	return t, strings.TrimSpace(fields[1]), err
+}
+func commandMFMT(FTPConn *FTPConnection, args string) {
+	modTime, path, err := parseTimeAndPath(args)
+	if err != nil {
+		FTPConn.sendResponseToClient("501", err)
+		return
+	}
+	err = FTPConn.FileSystem.SetModTime(path, modTime)
+	if err == ftpfs.ErrNotSupported {
+		FTPConn.sendResponseToClient("502", "Modification time can't be set on this file system")
+		return
+	}
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "MFMT error: ", err)
+		FTPConn.sendResponseToClient("550", "Could not set modification time")
+		return
+	}
+	FTPConn.sendResponseToClient("213", fmt.Sprint("Modify=", ftpfs.FormatFTPTime(modTime), "; ", path))
+}
+func commandMFCT(FTPConn *FTPConnection, args string) {
+	createTime, path, err := parseTimeAndPath(args)
+	if err != nil {
+		FTPConn.sendResponseToClient("501", err)
+		return
+	}
+	err = FTPConn.FileSystem.SetCreateTime(path, createTime)
+	if err == ftpfs.ErrNotSupported {
+		FTPConn.sendResponseToClient("502", "Creation time can't be set on this file system")
+		return
+	}
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "MFCT error: ", err)
+		FTPConn.sendResponseToClient("550", "Could not set creation time")
+		return
+	}
+	FTPConn.sendResponseToClient("213", fmt.Sprint("Create=", ftpfs.FormatFTPTime(createTime), "; ", path))
+}
+func commandSIZE(FTPConn *FTPConnection, args string) {
+	size, err := FTPConn.FileSystem.GetFileSize(args)
+	if err != nil {
+		FTPConn.sendResponseToClient("550", "Could not get file size")
+		return
+	}
+	FTPConn.sendResponseToClient("213", size)
+}
+func commandSTAT(FTPConn *FTPConnection, args string) {
+	if len(args) == 0 {
+		status := fmt.Sprint("-FTP server status:\r\n Connected from ", FTPConn.TCPConn.RemoteAddr(), "\r\n")
+		if FTPConn.User != nil {
+			status = fmt.Sprint(status, " Logged in as ", FTPConn.User.UserName, "\r\n")
+		}
+		status = fmt.Sprint(status, " TYPE: ", FTPConn.TransferType, "\r\n Session state: ", FTPConn.Session.State(), "\r\n211 End of status")
+		FTPConn.sendResponseToClient("211", status)
+		return
+	}
+	if !FTPConn.checkPermission("STAT", FTPAuth.PermissionList) {
+		return
+	}
+	_, path := ftpfs.ParseListArgs(args)
+	stat, err := FTPConn.FileSystem.STAT(path)
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "STAT error: ", err)
+		FTPConn.sendResponseToClient("550", "Couldn't get STAT")
+		return
+	}
+	FTPConn.sendResponseToClient("213", fmt.Sprint("-Status of ", path, ":\r\n", strings.Join(stat, "\r\n"), "\r\n213 End of status"))
+}
+func commandPASV(FTPConn *FTPConnection, args string) {
+	if FTPConn.Session.EPSVAll() {
+		FTPConn.sendResponseToClient("503", "EPSV ALL in effect, use EPSV")
+		return
+	}
+	FTPConn.DataConnection.UsingTLS = FTPConn.UsingTLS
+	FTPConn.DataConnection.TLSConfig = FTPConn.TLSConfig
+	passPortAddress, err := FTPConn.DataConnection.InitPassiveConnection()
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "PASV: couldn't open passive port...", err)
+		FTPConn.sendResponseToClient("425", "PASV start error...")
+		return
+	}
+	FTPConn.sendResponseToClient("227", fmt.Sprint("Entering Passive Mode (", passPortAddress, ")."))
+}
+func commandEPSV(FTPConn *FTPConnection, args string) {
+	protocol := 0
+	if strings.ToUpper(args) == "ALL" {
+		FTPConn.Session.SetEPSVAll()
+		FTPConn.sendResponseToClient("200", "EPSV ALL ok")
+		return
+	}
+	if len(args) != 0 {
+		var err error
+		protocol, err = strconv.Atoi(args)
+		if err != nil {
+			FTPConn.sendResponseToClient("501", "Wrong network protocol")
+			return
+		}
+	}
+	FTPConn.DataConnection.UsingTLS = FTPConn.UsingTLS
+	FTPConn.DataConnection.TLSConfig = FTPConn.TLSConfig
+	port, err := FTPConn.DataConnection.InitExtendedPassiveConnection(protocol)
+	if err == FTPDataTransfer.ErrNetworkProtocolNotSupported {
+		FTPConn.sendResponseToClient("522", fmt.Sprint("Network protocol not supported, use (", FTPConn.DataConnection.ServerProtocol(), ")"))
+		return
+	}
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "EPSV: couldn't open passive port...", err)
+		FTPConn.sendResponseToClient("425", "EPSV start error...")
+		return
+	}
+	FTPConn.sendResponseToClient("229", fmt.Sprint("Entering Extended Passive Mode (|||", port, "|)"))
+}
+func commandPORT(FTPConn *FTPConnection, args string) {
+	FTPConn.openActiveConnection("PORT", args, FTPConn.DataConnection.InitActiveConnection)
+}
+func commandEPRT(FTPConn *FTPConnection, args string) {
+	FTPConn.openActiveConnection("EPRT", args, FTPConn.DataConnection.InitExtendedActiveConnection)
+}
+
+//openActiveConnection connects to client address of PORT or EPRT with init and sends reply:
+//501 for wrong or refused address, 522 for unknown network protocol, 425 if client can't be dialed
+func (FTPConn *FTPConnection) openActiveConnection(verb string, args string, init func(clientaddr string) error) {
+	if FTPConn.Session.EPSVAll() {
+		FTPConn.sendResponseToClient("503", "EPSV ALL in effect, use EPSV")
+		return
+	}
+	FTPConn.DataConnection.UsingTLS = FTPConn.UsingTLS
+	FTPConn.DataConnection.TLSConfig = FTPConn.TLSConfig
+	err := init(args)
+	if err == FTPDataTransfer.ErrNetworkProtocolNotSupported {
+		FTPConn.sendResponseToClient("522", "Network protocol not supported, use (1,2)")
+		return
+	}
+	if err == FTPDataTransfer.ErrForeignDataAddress {
+		FTPConn.Logger.Log(Logger.UserAction, verb, " to foreign address refused: ", args)
+		FTPConn.sendResponseToClient("501", "Data connection to address other than client's one is not allowed")
+		return
+	}
+	if _, dialError := err.(*net.OpError); dialError {
+		FTPConn.Logger.Log(Logger.CriticalMessage, verb, ": couldn't connect to client: ", err)
+		FTPConn.sendResponseToClient("425", "Can't open data connection")
+		return
+	}
+	if err != nil {
+		FTPConn.sendResponseToClient("501", err)
+		return
+	}
+	FTPConn.sendResponseToClient("200", fmt.Sprint(verb, " command done ", FTPConn.DataConnection.FTPActiveDataConnection.DataPortAddress.String()))
+}
+func commandRNFR(FTPConn *FTPConnection, args string) {
+	renameobj, err := FTPConn.FileSystem.NewRenameableObj(args)
+	if err != nil {
+		FTPConn.sendResponseToClient("550", "Can't rename obj")
+		FTPConn.Logger.Log(Logger.CriticalMessage, "Rename object error: ", err)
+		return
+	}
+	FTPConn.Session.SetRename(renameobj)
+	FTPConn.sendResponseToClient("350", "Waiting for RNTO")
+}
+func commandRNTO(FTPConn *FTPConnection, args string) {
+	renameobj, err := FTPConn.Session.TakeRename()
+	if err != nil {
+		FTPConn.sendResponseToClient("503", "No RNFR command executed")
+		return
+	}
+	renameobj.NewName = args
+	if !FTPConn.checkOverwrite("RNTO", args) {
+		return
+	}
+	err = FTPConn.FileSystem.Rename(renameobj)
+	if err != nil {
+		FTPConn.sendResponseToClient("550", "Couldn't rename object")
+		FTPConn.Logger.Log(Logger.CriticalMessage, "RNTO error: ", err)
+		return
+	}
+	FTPConn.sendResponseToClient("250", "Object renamed")
+}
+func commandREST(FTPConn *FTPConnection, args string) {
+	offset, err := strconv.ParseInt(args, 10, 64)
+	if err != nil || offset < 0 {
+		FTPConn.sendResponseToClient("501", "Wrong restart offset")
+		return
+	}
+	FTPConn.Session.SetRestOffset(offset)
+	FTPConn.sendResponseToClient("350", fmt.Sprint("Restarting at ", offset, ". Send STOR or RETR to initiate transfer"))
+}
+func commandSTOR(FTPConn *FTPConnection, args string) {
+	offset := FTPConn.Session.TakeRestOffset()
+	if (offset > 0 || FTPConn.FileSystem.OverwritePolicy == ftpfs.OverwritePolicyOverwrite) && !FTPConn.checkOverwrite("STOR", args) {
+		return
+	}
+	file, name, err := FTPConn.FileSystem.STOR(args, offset, false)
+	if err == ftpfs.ErrNoSpace {
+		FTPConn.sendResponseToClient("552", "Exceeded storage allocation")
+		return
+	}
+	if err != nil {
+		FTPConn.sendResponseToClient("550", "Can't create new specified file")
+		FTPConn.Logger.Log(Logger.CriticalMessage, "STOR error: ", err)
+		return
+	}
+	if name == FTPConn.FileSystem.VirtualPath(args) {
+		//name is reported only if file is saved with other name
+		name = ""
+	}
+	FTPConn.receiveFile(file, "STOR", name)
+}
+func commandSTOU(FTPConn *FTPConnection, args string) {
+	file, name, err := FTPConn.FileSystem.STOU(args)
+	if err == ftpfs.ErrNoSpace {
+		FTPConn.sendResponseToClient("552", "Exceeded storage allocation")
+		return
+	}
+	if err != nil {
+		FTPConn.sendResponseToClient("550", "Can't create unique file")
+		FTPConn.Logger.Log(Logger.CriticalMessage, "STOU error: ", err)
+		return
+	}
+	FTPConn.receiveFile(file, "STOU", name)
+}
+func commandAPPE(FTPConn *FTPConnection, args string) {
+	FTPConn.Session.TakeRestOffset()
+	if !FTPConn.checkOverwrite("APPE", args) {
+		return
+	}
+	file, _, err := FTPConn.FileSystem.STOR(args, 0, true)
+	if err == ftpfs.ErrNoSpace {
+		FTPConn.sendResponseToClient("552", "Exceeded storage allocation")
+		return
+	}
+	if err != nil {
+		FTPConn.sendResponseToClient("550", "Can't open specified file for append")
+		FTPConn.Logger.Log(Logger.CriticalMessage, "APPE error: ", err)
+		return
+	}
+	FTPConn.receiveFile(file, "APPE", "")
+}
+
+//receiveFile receives upload in background, so ABOR can be read while data is transferred.
+//name (if not empty) is name of file chosen by server, it is sent in 150 and 226 replies
+func (FTPConn *FTPConnection) receiveFile(file io.WriteCloser, verb string, name string) {
+	if len(name) > 0 {
+		FTPConn.sendResponseToClient("150", fmt.Sprint("FILE: ", name))
+	} else {
+		FTPConn.sendResponseToClient("150", "Ready to receive data")
+	}
+	FTPConn.startTransfer(func() {
+		err := FTPConn.DataConnection.ReceiveBinaryFile(file)
+		if err != nil {
+			ftpfs.AbortUpload(file)
+		} else {
+			//driver may store data only on Close, atomic upload is moved to its place on Close
+			err = file.Close()
+		}
+		FTPConn.Session.EndTransfer()
+		if err == FTPDataTransfer.ErrTransferAborted {
+			FTPConn.sendResponseToClient("426", "Transfer aborted")
+			return
+		}
+		if err == ftpfs.ErrNoSpace {
+			FTPConn.sendResponseToClient("552", "Exceeded storage allocation")
+			return
+		}
+		if err != nil {
+			FTPConn.Logger.Log(Logger.CriticalMessage, verb, " error (receiving data): ", err)
+			FTPConn.sendResponseToClient("550", "Can't write specified data")
+			return
+		}
+		if len(name) > 0 {
+			FTPConn.sendResponseToClient("226", fmt.Sprint("File transfer complete (file name: ", name, ")"))
+			return
+		}
+		FTPConn.sendResponseToClient("226", "File transfer complete")
+	})
+}
+func commandRETR(FTPConn *FTPConnection, args string) {
+	offset := FTPConn.Session.TakeRestOffset()
+	file, size, err := FTPConn.FileSystem.RETR(args, offset)
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "RETR Command, fsRETR error: ", err)
+		FTPConn.sendResponseToClient("550", "File transfer error")
+		return
+	}
+	FTPConn.sendResponseToClient("150", fmt.Sprint("Opening binary stream for ", args))
+	FTPConn.startTransfer(func() {
+		err := FTPConn.DataConnection.TransferBinaryFile(file, size)
+		file.Close()
+		FTPConn.Session.EndTransfer()
+		if err == FTPDataTransfer.ErrTransferAborted {
+			FTPConn.sendResponseToClient("426", "Transfer aborted")
+			return
+		}
+		if err != nil {
+			FTPConn.Logger.Log(Logger.CriticalMessage, "RETR command error: ", err)
+			FTPConn.sendResponseToClient("550", "File transfer error")
+			return
+		}
+		FTPConn.sendResponseToClient("226", "Transfer complete")
+	})
+}
+func commandABOR(FTPConn *FTPConnection, args string) {
+	if FTPConn.Session.State() != SessionTransferInProgress {
+		//no transfer: data connection opened with PASV or PORT is closed
+		FTPConn.DataConnection.CloseConnection()
+		FTPConn.sendResponseToClient("226", "Transfer completed. Data Conn closed")
+		return
+	}
+	//RFC 959: transfer is answered with 426 first, then ABOR itself with 226
+	FTPConn.DataConnection.Abort()
+	FTPConn.transfers.Wait()
+	FTPConn.sendResponseToClient("226", "ABOR command successful")
+}
@@ -0,0 +1,316 @@
+package FTPClientConnection
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/FTPServConfig"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//testAuthenticator knows users by passwords, folder of user is "/name"
+type testAuthenticator map[string]string
+
+func (auth testAuthenticator) Name() string {
+	return "test"
+}
+func (auth testAuthenticator) Lookup(userName string) (*FTPAuth.User, error) {
+	if _, ok := auth[userName]; !ok {
+		return nil, FTPAuth.ErrUnknownUser
+	}
+	return &FTPAuth.User{UserName: userName, Folder: fmt.Sprint("/", userName), RecursiveDelete: true}, nil
+}
+func (auth testAuthenticator) Authenticate(userName string, pswd string) (*FTPAuth.User, error) {
+	user, err := auth.Lookup(userName)
+	if err != nil {
+		return nil, err
+	}
+	if auth[userName] != pswd {
+		return nil, FTPAuth.ErrWrongPassword
+	}
+	return user, nil
+}
+
+//testClient is control connection of test session
+type testClient struct {
+	t    *testing.T
+	conn *textproto.Conn
+}
+
+//startTestServer serves control connections on random port of 127.0.0.1. If config has no FTPRootFolder,
+//temporary folder with folders of users is used
+func startTestServer(t *testing.T, config *FTPServConfig.ConfigStorage, auth testAuthenticator) net.Listener {
+	if len(config.FTPRootFolder) == 0 {
+		config.FTPRootFolder = t.TempDir()
+		for userName := range auth {
+			if err := os.Mkdir(filepath.Join(config.FTPRootFolder, userName), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			FTPConn, err := InitConnection(conn, "127.0.0.1", closed, config, auth, nil, 1)
+			if err != nil {
+				t.Error(err)
+				conn.Close()
+				continue
+			}
+			go FTPConn.ParseIncomingConnection()
+		}
+	}()
+	go func() {
+		for range closed {
+		}
+	}()
+	return listener
+}
+
+func dialTestServer(t *testing.T, listener net.Listener) *testClient {
+	conn, err := textproto.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &testClient{t: t, conn: conn}
+	client.expect(220)
+	return client
+}
+
+//expect reads reply and checks its code
+func (c *testClient) expect(code int) string {
+	c.t.Helper()
+	gotCode, message, err := c.conn.ReadResponse(code)
+	if err != nil {
+		c.t.Fatalf("expected %d, got %d %s (%v)", code, gotCode, message, err)
+	}
+	return message
+}
+
+//cmd sends command and checks reply code
+func (c *testClient) cmd(code int, format string, args ...interface{}) string {
+	c.t.Helper()
+	if err := c.conn.PrintfLine(format, args...); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.expect(code)
+}
+
+//pasv opens passive data connection
+func (c *testClient) pasv() net.Conn {
+	c.t.Helper()
+	message := c.cmd(227, "PASV")
+	var h1, h2, h3, h4, p1, p2 int
+	start := strings.Index(message, "(")
+	if _, err := fmt.Sscanf(message[start+1:], "%d,%d,%d,%d,%d,%d", &h1, &h2, &h3, &h4, &p1, &p2); err != nil {
+		c.t.Fatalf("wrong PASV reply %q: %v", message, err)
+	}
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, p1*256+p2), 5*time.Second)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return conn
+}
+
+//upload stores data with STOR
+func (c *testClient) upload(name string, data string) {
+	c.t.Helper()
+	conn := c.pasv()
+	c.cmd(150, "STOR %s", name)
+	conn.Write([]byte(data))
+	conn.Close()
+	c.expect(226)
+}
+
+//download reads data of RETR, LIST or NLST
+func (c *testClient) download(format string, args ...interface{}) string {
+	c.t.Helper()
+	conn := c.pasv()
+	c.cmd(150, format, args...)
+	data, err := ioutil.ReadAll(conn)
+	conn.Close()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.expect(226)
+	return string(data)
+}
+
+func TestAbortTransfer(t *testing.T) {
+	config := &FTPServConfig.ConfigStorage{DataPortLow: 42300, DataPortHigh: 42399}
+	listener := startTestServer(t, config, testAuthenticator{"bob": "secret"})
+	defer listener.Close()
+
+	client := dialTestServer(t, listener)
+	client.cmd(331, "USER bob")
+	client.cmd(230, "PASS secret")
+	client.cmd(226, "ABOR")
+
+	//ABOR of running upload: 426 for transfer, then 226 for ABOR
+	conn := client.pasv()
+	client.cmd(150, "STOR aborted.txt")
+	conn.Write([]byte("partial data"))
+	client.cmd(426, "ABOR")
+	client.expect(226)
+	conn.Close()
+	client.cmd(257, "PWD")
+
+	//REIN waits for running transfer, then logs user out
+	conn = client.pasv()
+	client.cmd(150, "STOR rein.txt")
+	conn.Write([]byte("data sent before REIN"))
+	if err := client.conn.PrintfLine("REIN"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte(", data sent after REIN"))
+	conn.Close()
+	client.expect(226)
+	client.expect(220)
+	client.cmd(530, "PWD")
+	client.cmd(331, "USER bob")
+	client.cmd(230, "PASS secret")
+	if data := client.download("RETR rein.txt"); data != "data sent before REIN, data sent after REIN" {
+		t.Errorf("file uploaded during REIN has %q", data)
+	}
+	client.cmd(221, "QUIT")
+}
+
+func TestActiveDataConnection(t *testing.T) {
+	config := &FTPServConfig.ConfigStorage{DataPortLow: 42400, DataPortHigh: 42499}
+	listener := startTestServer(t, config, testAuthenticator{"bob": "secret"})
+	defer listener.Close()
+	dataListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dataListener.Close()
+	go func() {
+		for {
+			conn, err := dataListener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := dataListener.Addr().(*net.TCPAddr).Port
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	client := dialTestServer(t, listener)
+	client.cmd(331, "USER bob")
+	client.cmd(230, "PASS secret")
+	tests := []struct {
+		command string
+		code    int
+	}{
+		{fmt.Sprintf("PORT 127,0,0,1,%d,%d", port/256, port%256), 200},
+		{fmt.Sprintf("EPRT |1|127.0.0.1|%d|", port), 200},
+		{fmt.Sprintf("PORT 127,0,0,2,%d,%d", port/256, port%256), 501},
+		{fmt.Sprintf("EPRT |1|127.0.0.2|%d|", port), 501},
+		{fmt.Sprintf("PORT 127,0,0,1,%d,%d", closedPort/256, closedPort%256), 425},
+		{fmt.Sprintf("EPRT |1|127.0.0.1|%d|", closedPort), 425},
+		{"PORT 127,0,0,1,300", 501},
+		{"EPRT |1|localhost|21|", 501},
+		{"EPRT |3|127.0.0.1|21|", 522},
+	}
+	for _, test := range tests {
+		message := client.cmd(test.code, test.command)
+		if test.code == 200 && !strings.HasSuffix(message, fmt.Sprint(" done 127.0.0.1:", port)) {
+			t.Errorf("%s: reply %q", test.command, message)
+		}
+	}
+	client.cmd(200, "EPSV ALL")
+	client.cmd(503, "PORT 127,0,0,1,%d,%d", port/256, port%256)
+	client.cmd(503, "EPRT |1|127.0.0.1|%d|", port)
+	client.cmd(221, "QUIT")
+}
+
+func TestMakeDirReply(t *testing.T) {
+	config := &FTPServConfig.ConfigStorage{DataPortLow: 42500, DataPortHigh: 42599}
+	listener := startTestServer(t, config, testAuthenticator{"bob": "secret"})
+	defer listener.Close()
+
+	client := dialTestServer(t, listener)
+	client.cmd(331, "USER bob")
+	client.cmd(230, "PASS secret")
+	tests := []struct {
+		command string
+		reply   string
+	}{
+		{"MKD docs", `"/docs" created`},
+		{"CWD docs", ""},
+		{"MKD sub", `"/docs/sub" created`},
+		{`MKD say "hi"`, `"/docs/say ""hi""" created`},
+		{"MKD ../other/", `"/other" created`},
+		{"XMKD /docs/x", `"/docs/x" created`},
+	}
+	for _, test := range tests {
+		if len(test.reply) == 0 {
+			client.cmd(250, test.command)
+			continue
+		}
+		if reply := client.cmd(257, test.command); reply != test.reply {
+			t.Errorf("%s: reply %q, want %q", test.command, reply, test.reply)
+		}
+	}
+	client.cmd(550, "MKD sub")
+	client.cmd(221, "QUIT")
+}
+
+func TestTimeCommands(t *testing.T) {
+	config := &FTPServConfig.ConfigStorage{FTPRootFolder: t.TempDir()}
+	if err := ioutil.WriteFile(filepath.Join(config.FTPRootFolder, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	FTPConn, replies := newTestConnection()
+	FTPConn.User = &FTPAuth.User{UserName: "bob", Folder: "/"}
+	if err := FTPConn.FileSystem.InitFileSystem(config, FTPConn.User); err != nil {
+		t.Fatal(err)
+	}
+	FTPConn.Session.LoggedIn()
+	mfctCode := "502"
+	if FTPConn.FileSystem.CreateTimeSupported() {
+		mfctCode = "213"
+	}
+	tests := []struct {
+		line  string
+		reply string
+	}{
+		{"MFMT 20200102030405 file.txt", "213 Modify=20200102030405; file.txt"},
+		{"MDTM file.txt", "213 20200102030405"},
+		{"MFMT 20200102030405.250 file.txt", "213 Modify=20200102030405; file.txt"},
+		{"MFMT 20200102030405", "501"},
+		{"MFMT 2020-01-02 file.txt", "501"},
+		{"MFMT 20200102030405 missing.txt", "550"},
+		{"MDTM missing.txt", "550"},
+		{"MFCT 20200102030405 file.txt", mfctCode},
+		{"MFCT yesterday file.txt", "501"},
+	}
+	for _, test := range tests {
+		FTPConn.executeCommand(test.line)
+		FTPConn.Writer.Flush()
+		reply := strings.TrimRight(replies.String(), "\r\n")
+		if code := lastReplyCode(replies); code != test.reply[:3] || len(test.reply) > 3 && reply != test.reply {
+			t.Errorf("%q answered with %q, want %q", test.line, reply, test.reply)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package FTPClientConnection
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/Logger"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//ArgumentPolicy describes how command treats its argument
+type ArgumentPolicy uint
+
+const (
+	//ArgumentOptional - command may be sent with or without argument
+	ArgumentOptional ArgumentPolicy = iota
+	//ArgumentRequired - command without argument is rejected with 501
+	ArgumentRequired
+	//ArgumentNone - command with argument is rejected with 501
+	ArgumentNone
+)
+
+//CommandHandler is called with the connection and everything after the verb (trimmed)
+type CommandHandler func(FTPConn *FTPConnection, args string)
+
+//FTPCommand is an entry of the commands registry
+type FTPCommand struct {
+	//Handler runs the command. Command with nil Handler is answered with 502
+	Handler CommandHandler
+	//RequiresAuth - command is answered with 530 until user logged in
+	RequiresAuth bool
+	//Argument - argument parsing policy
+	Argument ArgumentPolicy
+	//States - session states in which command is allowed, 503 is sent in others.
+	//If empty, LoggedInStates are used for commands with RequiresAuth and LoggedInStates|NotLoggedInStates for others
+	States StateSet
+	//KeepsRestOffset - command doesn't reset offset set with REST (PASV, PORT, TYPE, transfer commands)
+	KeepsRestOffset bool
+	//Permission - user permission (FTPAuth.PermissionList...) needed for command, 550 is sent without it
+	Permission string
+	//Feature returns line for FEAT reply (empty - nothing advertised). May depend on connection (TLS, OPTS)
+	Feature func(FTPConn *FTPConnection) string
+}
+
+//StaticFeature makes Feature function which always advertises feature
+func StaticFeature(feature string) func(FTPConn *FTPConnection) string {
+	return func(FTPConn *FTPConnection) string {
+		return feature
+	}
+}
+
+//AnyState - command may be sent in every state except SessionClosing
+var AnyState = LoggedInStates | NotLoggedInStates | States(SessionTransferInProgress)
+
+func (command *FTPCommand) allowedStates() StateSet {
+	if command.States != 0 {
+		return command.States
+	}
+	if command.RequiresAuth {
+		return LoggedInStates
+	}
+	return LoggedInStates | NotLoggedInStates
+}
+
+var commandsRegistry = make(map[string]*FTPCommand)
+var commandsRegistryLock sync.RWMutex
+
+//RegisterCommand adds (or replaces) handler for specified verb.
+//Use it to extend server with own commands without editing this package
+func RegisterCommand(verb string, command *FTPCommand) error {
+	verb = strings.ToUpper(strings.TrimSpace(verb))
+	if len(verb) == 0 || strings.ContainsAny(verb, " \r\n") {
+		return errors.New(fmt.Sprint("RegisterCommand: wrong verb \"", verb, "\""))
+	}
+	if command == nil {
+		return errors.New("RegisterCommand: command is nil")
+	}
+	commandsRegistryLock.Lock()
+	commandsRegistry[verb] = command
+	commandsRegistryLock.Unlock()
+	return nil
+}
+
+//UnregisterCommand removes verb from registry. Removed verb is answered with 500
+func UnregisterCommand(verb string) {
+	commandsRegistryLock.Lock()
+	delete(commandsRegistry, strings.ToUpper(verb))
+	commandsRegistryLock.Unlock()
+}
+
+//LookupCommand returns registered command for verb or nil
+func LookupCommand(verb string) *FTPCommand {
+	commandsRegistryLock.RLock()
+	defer commandsRegistryLock.RUnlock()
+	return commandsRegistry[strings.ToUpper(verb)]
+}
+
+//features returns FEAT lines of all registered commands, sorted
+func (FTPConn *FTPConnection) features() []string {
+	commandsRegistryLock.RLock()
+	defer commandsRegistryLock.RUnlock()
+	features := make([]string, 0)
+	for _, command := range commandsRegistry {
+		if command.Handler == nil || command.Feature == nil {
+			continue
+		}
+		if feature := command.Feature(FTPConn); len(feature) != 0 {
+			features = append(features, feature)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+//splitCommandLine splits "VERB args" to upper-cased verb and argument
+func splitCommandLine(line string) (string, string) {
+	line = strings.TrimLeft(line, " ")
+	verb := line
+	args := ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		verb = line[:i]
+		args = strings.TrimSpace(line[i+1:])
+	}
+	return strings.ToUpper(verb), args
+}
+
+//executeCommand finds handler for command line and runs it
+func (FTPConn *FTPConnection) executeCommand(line string) {
+	verb, args := splitCommandLine(line)
+	command := LookupCommand(verb)
+	if command == nil {
+		FTPConn.sendResponseToClient("500", fmt.Sprint("Syntax error, command \"", verb, "\" unrecognized"))
+		return
+	}
+	if command.Handler == nil {
+		FTPConn.sendResponseToClient("502", fmt.Sprint("Command ", verb, " not implemented"))
+		return
+	}
+	state := FTPConn.Session.State()
+	if state == SessionClosing {
+		return
+	}
+	allowed := command.allowedStates()
+	if !allowed.Contains(state) {
+		if command.RequiresAuth && NotLoggedInStates.Contains(state) {
+			FTPConn.sendResponseToClient("530", "Not logged in")
+			return
+		}
+		FTPConn.Logger.Log(Logger.UserAction, "Command ", verb, " rejected in state: ", state)
+		FTPConn.sendResponseToClient("503", fmt.Sprint("Bad sequence of commands (", verb, " not allowed: ", state, ")"))
+		return
+	}
+	if allowed.Contains(SessionAuthenticated) {
+		//pending RNFR must be followed by RNTO, REST - by transfer command
+		FTPConn.Session.DropPending(command.KeepsRestOffset)
+	}
+	switch command.Argument {
+	case ArgumentRequired:
+		if len(args) == 0 {
+			FTPConn.sendResponseToClient("501", fmt.Sprint("Syntax error: ", verb, " requires an argument"))
+			return
+		}
+	case ArgumentNone:
+		if len(args) != 0 {
+			FTPConn.sendResponseToClient("501", fmt.Sprint("Syntax error: ", verb, " takes no arguments"))
+			return
+		}
+	}
+	if !FTPConn.checkPermission(verb, command.Permission) {
+		return
+	}
+	command.Handler(FTPConn, args)
+}
+
+//permitted reports if logged in user has permission, empty permission is always granted
+func (FTPConn *FTPConnection) permitted(permission string) bool {
+	if len(permission) == 0 {
+		return true
+	}
+	return FTPConn.User != nil && FTPConn.User.Can(permission, FTPConn.GlobalConfig.DefaultPermissions)
+}
+
+//checkPermission sends 550 if user has no permission for command
+func (FTPConn *FTPConnection) checkPermission(command string, permission string) bool {
+	if FTPConn.permitted(permission) {
+		return true
+	}
+	FTPConn.Logger.Log(Logger.UserAction, "Command ", command, " denied, user has no permission: ", permission)
+	FTPConn.sendResponseToClient("550", "Permission denied")
+	return false
+}
+
+//checkOverwrite sends 550 if file exists and user has no permission to change it
+func (FTPConn *FTPConnection) checkOverwrite(command string, fileName string) bool {
+	if FTPConn.permitted(FTPAuth.PermissionOverwrite) || !FTPConn.FileSystem.FileExists(fileName) {
+		return true
+	}
+	return FTPConn.checkPermission(command, FTPAuth.PermissionOverwrite)
+}
@@ -0,0 +1,124 @@
+package FTPClientConnection
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/FTPServConfig"
+	"FTPServ/Logger"
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+//newTestConnection makes connection without network, replies are written to returned buffer
+func newTestConnection() (*FTPConnection, *bytes.Buffer) {
+	replies := new(bytes.Buffer)
+	FTPConn := &FTPConnection{
+		Writer:       bufio.NewWriter(replies),
+		Session:      NewSession(),
+		GlobalConfig: &FTPServConfig.ConfigStorage{},
+		Logger:       Logger.NewLogger(0, &net.TCPAddr{}),
+	}
+	return FTPConn, replies
+}
+
+//lastReplyCode returns code of last reply written to buffer and clears it
+func lastReplyCode(replies *bytes.Buffer) string {
+	lines := strings.Split(strings.TrimRight(replies.String(), "\r\n"), "\r\n")
+	replies.Reset()
+	if last := lines[len(lines)-1]; len(last) >= 3 {
+		return last[:3]
+	}
+	return ""
+}
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		line string
+		verb string
+		args string
+	}{
+		{"NOOP", "NOOP", ""},
+		{"noop", "NOOP", ""},
+		{"RETR file name.txt", "RETR", "file name.txt"},
+		{"  cwd   /pub  ", "CWD", "/pub"},
+		{"STOR ", "STOR", ""},
+	}
+	for _, test := range tests {
+		if verb, args := splitCommandLine(test.line); verb != test.verb || args != test.args {
+			t.Errorf("splitCommandLine(%q) = %q, %q, want %q, %q", test.line, verb, args, test.verb, test.args)
+		}
+	}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	for _, verb := range []string{"", " ", "X Y", "X\rY"} {
+		if err := RegisterCommand(verb, &FTPCommand{Handler: commandNOOP}); err == nil {
+			t.Errorf("verb %q registered", verb)
+		}
+	}
+	if err := RegisterCommand("XTST", nil); err == nil {
+		t.Errorf("nil command registered")
+	}
+	if err := RegisterCommand(" xtst ", &FTPCommand{Handler: commandNOOP}); err != nil {
+		t.Fatal(err)
+	}
+	if LookupCommand("Xtst") == nil {
+		t.Errorf("registered command not found")
+	}
+	UnregisterCommand("xtst")
+	if LookupCommand("XTST") != nil {
+		t.Errorf("command found after UnregisterCommand")
+	}
+}
+
+func TestExecuteCommand(t *testing.T) {
+	var gotArgs []string
+	RegisterCommand("XTST", &FTPCommand{
+		Handler: func(FTPConn *FTPConnection, args string) {
+			gotArgs = append(gotArgs, args)
+			FTPConn.sendResponseToClient("200", "OK")
+		},
+		RequiresAuth: true,
+		Argument:     ArgumentRequired,
+	})
+	RegisterCommand("XDEL", &FTPCommand{Handler: commandNOOP, RequiresAuth: true, Permission: FTPAuth.PermissionDelete})
+	defer UnregisterCommand("XTST")
+	defer UnregisterCommand("XDEL")
+
+	FTPConn, replies := newTestConnection()
+	tests := []struct {
+		name  string
+		line  string
+		login bool
+		code  string
+	}{
+		{"unknown verb", "XYZZY", false, "500"},
+		{"not implemented", "CCC", false, "502"},
+		{"before login", "XTST arg", false, "530"},
+		{"PASS before USER", "PASS secret", false, "503"},
+		{"argument required", "XTST", true, "501"},
+		{"no argument allowed", "NOOP now", true, "501"},
+		{"RNTO without RNFR", "RNTO new.txt", true, "503"},
+		{"USER after login", "USER bob", true, "503"},
+		{"lower case verb", "xtst  some args ", true, "200"},
+		{"no permission", "XDEL", true, "550"},
+	}
+	for _, test := range tests {
+		FTPConn.User = nil
+		FTPConn.Session = NewSession()
+		if test.login {
+			FTPConn.User = &FTPAuth.User{UserName: "bob", Permissions: []string{FTPAuth.PermissionList}}
+			FTPConn.Session.LoggedIn()
+		}
+		FTPConn.executeCommand(test.line)
+		FTPConn.Writer.Flush()
+		if code := lastReplyCode(replies); code != test.code {
+			t.Errorf("%s: %q answered with %s, want %s", test.name, test.line, code, test.code)
+		}
+	}
+	if len(gotArgs) != 1 || gotArgs[0] != "some args" {
+		t.Errorf("handler called with %q", gotArgs)
+	}
+}
@@ -0,0 +1,101 @@
+package FTPClientConnection
+
+import (
+	"bufio"
+	"errors"
+)
+
+//MaxCommandLineLength limits length of one control channel line (without CRLF)
+var MaxCommandLineLength = 8192
+
+var ErrCommandLineTooLong = errors.New("Command line too long")
+
+//telnet commands (RFC 854) which clients may send in control channel
+const (
+	telnetIAC  byte = 255
+	telnetDONT byte = 254
+	telnetDO   byte = 253
+	telnetWONT byte = 252
+	telnetWILL byte = 251
+	telnetSB   byte = 250
+	telnetSE   byte = 240
+)
+
+//readControlLine reads one CRLF (or bare LF) terminated line from reader.
+//Telnet sequences (IAC IP, IAC DM sent before ABOR, option negotiation) are removed,
+//IAC IAC is returned as single 0xFF byte, CR NUL as CR.
+//If line is longer than maxLength, its rest is skipped up to line end and ErrCommandLineTooLong returned
+func readControlLine(reader *bufio.Reader, maxLength int) (string, error) {
+	line := make([]byte, 0, 128)
+	tooLong := false
+	previousCR := false
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == telnetIAC {
+			cmd, err := reader.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			switch cmd {
+			case telnetIAC:
+				//escaped 0xFF is data
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				//option negotiation: skip option code
+				if _, err := reader.ReadByte(); err != nil {
+					return "", err
+				}
+				continue
+			case telnetSB:
+				//subnegotiation: skip up to IAC SE
+				if err := skipTelnetSubnegotiation(reader); err != nil {
+					return "", err
+				}
+				continue
+			default:
+				//IP, DM, AO, AYT and other two-byte commands
+				continue
+			}
+		}
+		if b == '\n' {
+			break
+		}
+		if b == 0 && previousCR {
+			//CR NUL is bare carriage return (RFC 854)
+			previousCR = false
+			continue
+		}
+		previousCR = b == '\r'
+		if tooLong {
+			continue
+		}
+		if len(line) >= maxLength+1 {
+			//keep one more byte for trailing CR
+			tooLong = true
+			continue
+		}
+		line = append(line, b)
+	}
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	if tooLong || len(line) > maxLength {
+		return "", ErrCommandLineTooLong
+	}
+	return string(line), nil
+}
+func skipTelnetSubnegotiation(reader *bufio.Reader) error {
+	previousIAC := false
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if previousIAC && b == telnetSE {
+			return nil
+		}
+		previousIAC = b == telnetIAC && !previousIAC
+	}
+}
@@ -0,0 +1,65 @@
+package FTPClientConnection
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadControlLine(t *testing.T) {
+	iac := string([]byte{telnetIAC})
+	tests := []struct {
+		name  string
+		input string
+		lines []string
+		err   error
+	}{
+		{"CRLF", "NOOP\r\n", []string{"NOOP"}, io.EOF},
+		{"bare LF", "NOOP\n", []string{"NOOP"}, io.EOF},
+		{"pipelined commands", "USER bob\r\nPASS secret\r\nPWD\r\n", []string{"USER bob", "PASS secret", "PWD"}, io.EOF},
+		{"empty line", "\r\nNOOP\r\n", []string{"", "NOOP"}, io.EOF},
+		{"no line end", "NOOP", nil, io.EOF},
+		{"IAC IP IAC DM before ABOR", iac + "\xf4" + iac + "\xf2ABOR\r\n", []string{"ABOR"}, io.EOF},
+		{"escaped 0xFF", "RETR a" + iac + iac + "b\r\n", []string{"RETR a\xffb"}, io.EOF},
+		{"option negotiation", iac + "\xfb\x01NO" + iac + "\xfe\x03OP\r\n", []string{"NOOP"}, io.EOF},
+		{"subnegotiation", "NO" + iac + "\xfa\x18" + iac + iac + "x" + iac + "\xf0OP\r\n", []string{"NOOP"}, io.EOF},
+		{"CR NUL", "RETR a\r\x00b\r\n", []string{"RETR a\rb"}, io.EOF},
+		{"NUL without CR", "RETR a\x00b\r\n", []string{"RETR a\x00b"}, io.EOF},
+		{"IAC at end of input", "NOOP" + iac, nil, io.EOF},
+		{"line of max length", strings.Repeat("x", 16) + "\r\nNOOP\r\n", []string{strings.Repeat("x", 16), "NOOP"}, io.EOF},
+		{"line too long", strings.Repeat("x", 17) + "\r\nNOOP\r\n", nil, ErrCommandLineTooLong},
+		{"very long line", strings.Repeat("x", 1000) + "\nNOOP\r\n", nil, ErrCommandLineTooLong},
+	}
+	for _, test := range tests {
+		//bytes come one by one: lines are split across reads
+		for _, oneByte := range []bool{false, true} {
+			var input io.Reader = strings.NewReader(test.input)
+			if oneByte {
+				input = iotest.OneByteReader(input)
+			}
+			reader := bufio.NewReaderSize(input, 16)
+			for _, want := range test.lines {
+				line, err := readControlLine(reader, 16)
+				if err != nil || line != want {
+					t.Errorf("%s: line %q, %v, want %q", test.name, line, err, want)
+				}
+			}
+			if _, err := readControlLine(reader, 16); err != test.err {
+				t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+			}
+		}
+	}
+}
+
+func TestReadControlLineAfterTooLong(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader(strings.Repeat("x", 100) + "\r\nNOOP\r\n"))
+	if _, err := readControlLine(reader, 16); err != ErrCommandLineTooLong {
+		t.Fatalf("error %v, want %v", err, ErrCommandLineTooLong)
+	}
+	//rest of long line is skipped, next command is read
+	if line, err := readControlLine(reader, 16); line != "NOOP" || err != nil {
+		t.Errorf("line after long one: %q, %v", line, err)
+	}
+}
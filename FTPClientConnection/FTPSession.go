@@ -0,0 +1,276 @@
+package FTPClientConnection
+
+import (
+	"FTPServ/ftpfs"
+	"errors"
+	"sync"
+)
+
+//SessionState is a state of FTP control connection
+type SessionState uint
+
+const (
+	//SessionConnected - greeting sent, waiting for USER (also after REIN)
+	SessionConnected SessionState = iota
+	//SessionAwaitingPass - USER accepted, waiting for PASS
+	SessionAwaitingPass
+	//SessionAuthenticated - user logged in, no pending actions
+	SessionAuthenticated
+	//SessionRenamePending - RNFR accepted, waiting for RNTO
+	SessionRenamePending
+	//SessionRestPending - REST accepted, waiting for transfer command
+	SessionRestPending
+	//SessionTransferInProgress - data transfer is running
+	SessionTransferInProgress
+	//SessionClosing - QUIT received or connection lost
+	SessionClosing
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case SessionConnected:
+		return "connected"
+	case SessionAwaitingPass:
+		return "awaiting PASS"
+	case SessionAuthenticated:
+		return "authenticated"
+	case SessionRenamePending:
+		return "RNFR pending"
+	case SessionRestPending:
+		return "REST pending"
+	case SessionTransferInProgress:
+		return "transfer in progress"
+	case SessionClosing:
+		return "closing"
+	}
+	return "unknown"
+}
+
+//StateSet is a set of session states
+type StateSet uint
+
+//States makes StateSet from specified states
+func States(states ...SessionState) StateSet {
+	var set StateSet
+	for _, state := range states {
+		set |= 1 << state
+	}
+	return set
+}
+
+//Contains reports if state is in set
+func (set StateSet) Contains(state SessionState) bool {
+	return set&(1<<state) != 0
+}
+
+//LoggedInStates - states in which user is logged in and may run commands
+var LoggedInStates = States(SessionAuthenticated, SessionRenamePending, SessionRestPending)
+
+//NotLoggedInStates - states before successful PASS
+var NotLoggedInStates = States(SessionConnected, SessionAwaitingPass)
+
+var ErrBadSequence = errors.New("Bad sequence of commands")
+
+//Session keeps state of control connection and data of pending actions (USER, RNFR, REST)
+type Session struct {
+	lock        sync.Mutex
+	state       SessionState
+	pendingUser string
+	renameObj   *ftpfs.RenameableObj
+	restOffset  int64
+	epsvAll     bool
+	mlstFacts   []string
+}
+
+func NewSession() *Session {
+	return &Session{state: SessionConnected, mlstFacts: ftpfs.MLSTFacts}
+}
+
+//State returns current session state
+func (s *Session) State() SessionState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.state
+}
+
+//IsLoggedIn reports if user passed authentication
+func (s *Session) IsLoggedIn() bool {
+	return LoggedInStates.Contains(s.State()) || s.State() == SessionTransferInProgress
+}
+
+//Reset returns session to SessionConnected state (REIN)
+func (s *Session) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.state = SessionConnected
+	s.pendingUser = ""
+	s.renameObj = nil
+	s.restOffset = 0
+	s.epsvAll = false
+	s.mlstFacts = ftpfs.MLSTFacts
+}
+
+//SetMLSTFacts stores facts selected with OPTS MLST
+func (s *Session) SetMLSTFacts(facts []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.mlstFacts = facts
+}
+
+//MLSTFacts returns facts sent in MLST and MLSD replies
+func (s *Session) MLSTFacts() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.mlstFacts
+}
+
+//SetEPSVAll marks that client sent "EPSV ALL": other data connection setup commands are rejected
+func (s *Session) SetEPSVAll() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.epsvAll = true
+}
+
+//EPSVAll reports if "EPSV ALL" was sent
+func (s *Session) EPSVAll() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.epsvAll
+}
+
+//UserAccepted stores user name from USER command, it is checked with password by PASS
+func (s *Session) UserAccepted(userName string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !NotLoggedInStates.Contains(s.state) {
+		return ErrBadSequence
+	}
+	s.pendingUser = userName
+	s.state = SessionAwaitingPass
+	return nil
+}
+
+//PendingUser returns user name sent with USER command
+func (s *Session) PendingUser() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pendingUser
+}
+
+//LoggedIn finishes authentication
+func (s *Session) LoggedIn() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.state != SessionAwaitingPass && s.state != SessionConnected {
+		return ErrBadSequence
+	}
+	s.pendingUser = ""
+	s.state = SessionAuthenticated
+	return nil
+}
+
+//LoginFailed returns session to SessionConnected
+func (s *Session) LoginFailed() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pendingUser = ""
+	s.state = SessionConnected
+}
+
+//SetRename stores RNFR object
+func (s *Session) SetRename(obj *ftpfs.RenameableObj) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !LoggedInStates.Contains(s.state) {
+		return ErrBadSequence
+	}
+	s.renameObj = obj
+	s.restOffset = 0
+	s.state = SessionRenamePending
+	return nil
+}
+
+//TakeRename returns RNFR object and leaves SessionRenamePending
+func (s *Session) TakeRename() (*ftpfs.RenameableObj, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.state != SessionRenamePending {
+		return nil, ErrBadSequence
+	}
+	obj := s.renameObj
+	s.renameObj = nil
+	s.state = SessionAuthenticated
+	return obj, nil
+}
+
+//SetRestOffset stores REST offset
+func (s *Session) SetRestOffset(offset int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !LoggedInStates.Contains(s.state) {
+		return ErrBadSequence
+	}
+	s.renameObj = nil
+	s.restOffset = offset
+	s.state = SessionRestPending
+	if offset == 0 {
+		s.state = SessionAuthenticated
+	}
+	return nil
+}
+
+//TakeRestOffset returns pending REST offset (0 if none) and clears it
+func (s *Session) TakeRestOffset() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	offset := s.restOffset
+	s.restOffset = 0
+	if s.state == SessionRestPending {
+		s.state = SessionAuthenticated
+	}
+	return offset
+}
+
+//DropPending clears pending RNFR always and pending REST if keepRest is false
+func (s *Session) DropPending(keepRest bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.state == SessionRenamePending {
+		s.renameObj = nil
+		s.state = SessionAuthenticated
+	}
+	if s.state == SessionRestPending && !keepRest {
+		s.restOffset = 0
+		s.state = SessionAuthenticated
+	}
+}
+
+//BeginTransfer switches session to SessionTransferInProgress
+func (s *Session) BeginTransfer() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !LoggedInStates.Contains(s.state) {
+		return ErrBadSequence
+	}
+	s.renameObj = nil
+	s.state = SessionTransferInProgress
+	return nil
+}
+
+//EndTransfer returns session from SessionTransferInProgress
+func (s *Session) EndTransfer() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.state == SessionTransferInProgress {
+		s.state = SessionAuthenticated
+	}
+}
+
+//Close switches session to SessionClosing. Session can't leave this state
+func (s *Session) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.state = SessionClosing
+	s.pendingUser = ""
+	s.renameObj = nil
+}
@@ -0,0 +1,137 @@
+package FTPClientConnection
+
+import (
+	"FTPServ/ftpfs"
+	"testing"
+)
+
+//sessionStep is action applied to session and error it must return
+type sessionStep struct {
+	name string
+	do   func(s *Session) error
+	err  error
+}
+
+func stepUser(s *Session) error { return s.UserAccepted("bob") }
+func stepLogin(s *Session) error {
+	return s.LoggedIn()
+}
+func stepLoginFailed(s *Session) error {
+	s.LoginFailed()
+	return nil
+}
+func stepRNFR(s *Session) error { return s.SetRename(&ftpfs.RenameableObj{}) }
+func stepRNTO(s *Session) error {
+	_, err := s.TakeRename()
+	return err
+}
+func stepREST(s *Session) error  { return s.SetRestOffset(100) }
+func stepREST0(s *Session) error { return s.SetRestOffset(0) }
+func stepBegin(s *Session) error { return s.BeginTransfer() }
+func stepEnd(s *Session) error   { s.EndTransfer(); return nil }
+func stepReset(s *Session) error { s.Reset(); return nil }
+func stepClose(s *Session) error { s.Close(); return nil }
+func stepDrop(s *Session) error  { s.DropPending(false); return nil }
+func stepKeep(s *Session) error  { s.DropPending(true); return nil }
+func stepTakeRest(s *Session) error {
+	s.TakeRestOffset()
+	return nil
+}
+
+func TestSessionTransitions(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []sessionStep
+		state SessionState
+	}{
+		{"new session", nil, SessionConnected},
+		{"USER", []sessionStep{{"USER", stepUser, nil}}, SessionAwaitingPass},
+		{"USER PASS", []sessionStep{{"USER", stepUser, nil}, {"PASS", stepLogin, nil}}, SessionAuthenticated},
+		{"USER twice", []sessionStep{{"USER", stepUser, nil}, {"USER", stepUser, nil}}, SessionAwaitingPass},
+		{"wrong PASS", []sessionStep{{"USER", stepUser, nil}, {"PASS", stepLoginFailed, nil}}, SessionConnected},
+		{"USER after login", []sessionStep{{"USER", stepUser, nil}, {"PASS", stepLogin, nil}, {"USER", stepUser, ErrBadSequence}}, SessionAuthenticated},
+		{"PASS after login", []sessionStep{{"USER", stepUser, nil}, {"PASS", stepLogin, nil}, {"PASS", stepLogin, ErrBadSequence}}, SessionAuthenticated},
+		{"RNFR before login", []sessionStep{{"RNFR", stepRNFR, ErrBadSequence}}, SessionConnected},
+		{"RNFR", []sessionStep{{"PASS", stepLogin, nil}, {"RNFR", stepRNFR, nil}}, SessionRenamePending},
+		{"RNFR RNTO", []sessionStep{{"PASS", stepLogin, nil}, {"RNFR", stepRNFR, nil}, {"RNTO", stepRNTO, nil}}, SessionAuthenticated},
+		{"RNTO without RNFR", []sessionStep{{"PASS", stepLogin, nil}, {"RNTO", stepRNTO, ErrBadSequence}}, SessionAuthenticated},
+		{"RNFR other command", []sessionStep{{"PASS", stepLogin, nil}, {"RNFR", stepRNFR, nil}, {"NOOP", stepKeep, nil}}, SessionAuthenticated},
+		{"REST", []sessionStep{{"PASS", stepLogin, nil}, {"REST", stepREST, nil}}, SessionRestPending},
+		{"REST 0", []sessionStep{{"PASS", stepLogin, nil}, {"REST", stepREST0, nil}}, SessionAuthenticated},
+		{"REST kept", []sessionStep{{"PASS", stepLogin, nil}, {"REST", stepREST, nil}, {"SIZE", stepKeep, nil}}, SessionRestPending},
+		{"REST dropped", []sessionStep{{"PASS", stepLogin, nil}, {"REST", stepREST, nil}, {"CWD", stepDrop, nil}}, SessionAuthenticated},
+		{"REST RETR", []sessionStep{{"PASS", stepLogin, nil}, {"REST", stepREST, nil}, {"RETR", stepTakeRest, nil}}, SessionAuthenticated},
+		{"RNFR REST", []sessionStep{{"PASS", stepLogin, nil}, {"RNFR", stepRNFR, nil}, {"REST", stepREST, nil}}, SessionRestPending},
+		{"transfer", []sessionStep{{"PASS", stepLogin, nil}, {"RETR", stepBegin, nil}}, SessionTransferInProgress},
+		{"transfer end", []sessionStep{{"PASS", stepLogin, nil}, {"RETR", stepBegin, nil}, {"end", stepEnd, nil}}, SessionAuthenticated},
+		{"transfer twice", []sessionStep{{"PASS", stepLogin, nil}, {"RETR", stepBegin, nil}, {"RETR", stepBegin, ErrBadSequence}}, SessionTransferInProgress},
+		{"transfer before login", []sessionStep{{"RETR", stepBegin, ErrBadSequence}}, SessionConnected},
+		{"RNFR in transfer", []sessionStep{{"PASS", stepLogin, nil}, {"RETR", stepBegin, nil}, {"RNFR", stepRNFR, ErrBadSequence}}, SessionTransferInProgress},
+		{"REIN", []sessionStep{{"PASS", stepLogin, nil}, {"RNFR", stepRNFR, nil}, {"REIN", stepReset, nil}}, SessionConnected},
+		{"QUIT", []sessionStep{{"PASS", stepLogin, nil}, {"QUIT", stepClose, nil}}, SessionClosing},
+		{"QUIT in transfer", []sessionStep{{"PASS", stepLogin, nil}, {"RETR", stepBegin, nil}, {"QUIT", stepClose, nil}, {"end", stepEnd, nil}}, SessionClosing},
+		{"login after QUIT", []sessionStep{{"QUIT", stepClose, nil}, {"USER", stepUser, ErrBadSequence}, {"PASS", stepLogin, ErrBadSequence}}, SessionClosing},
+	}
+	for _, test := range tests {
+		s := NewSession()
+		for _, step := range test.steps {
+			if err := step.do(s); err != step.err {
+				t.Errorf("%s: %s returned %v, want %v", test.name, step.name, err, step.err)
+			}
+		}
+		if state := s.State(); state != test.state {
+			t.Errorf("%s: state %s, want %s", test.name, state, test.state)
+		}
+	}
+}
+
+func TestSessionPendingData(t *testing.T) {
+	s := NewSession()
+	s.UserAccepted("bob")
+	if user := s.PendingUser(); user != "bob" {
+		t.Fatalf("pending user %q, want bob", user)
+	}
+	s.LoggedIn()
+	if user := s.PendingUser(); user != "" {
+		t.Errorf("pending user %q kept after login", user)
+	}
+	s.SetRestOffset(100)
+	if offset := s.TakeRestOffset(); offset != 100 {
+		t.Errorf("REST offset %d, want 100", offset)
+	}
+	if offset := s.TakeRestOffset(); offset != 0 {
+		t.Errorf("REST offset %d taken twice", offset)
+	}
+	obj := &ftpfs.RenameableObj{}
+	s.SetRestOffset(100)
+	s.SetRename(obj)
+	if offset := s.TakeRestOffset(); offset != 0 {
+		t.Errorf("REST offset %d kept after RNFR", offset)
+	}
+	s.SetRename(obj)
+	if taken, err := s.TakeRename(); taken != obj || err != nil {
+		t.Errorf("RNFR object %v, %v", taken, err)
+	}
+	s.SetEPSVAll()
+	s.SetMLSTFacts([]string{"size"})
+	s.Reset()
+	if s.EPSVAll() || len(s.MLSTFacts()) != len(ftpfs.MLSTFacts) {
+		t.Errorf("REIN kept EPSV ALL or MLST facts")
+	}
+}
+
+func TestStateSet(t *testing.T) {
+	for _, state := range []SessionState{SessionAuthenticated, SessionRenamePending, SessionRestPending} {
+		if !LoggedInStates.Contains(state) || NotLoggedInStates.Contains(state) {
+			t.Errorf("%s must be logged in state", state)
+		}
+	}
+	for _, state := range []SessionState{SessionConnected, SessionAwaitingPass} {
+		if LoggedInStates.Contains(state) || !NotLoggedInStates.Contains(state) {
+			t.Errorf("%s must be not logged in state", state)
+		}
+	}
+	if AnyState.Contains(SessionClosing) || !AnyState.Contains(SessionTransferInProgress) {
+		t.Errorf("AnyState must contain transfer and not closing")
+	}
+}
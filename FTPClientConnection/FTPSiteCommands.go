@@ -0,0 +1,193 @@
+package FTPClientConnection
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/Logger"
+	"FTPServ/ftpfs"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var siteCommandsRegistry = make(map[string]*FTPCommand)
+var siteCommandsRegistryLock sync.RWMutex
+
+func init() {
+	RegisterCommand("SITE", &FTPCommand{Handler: commandSITE, RequiresAuth: true, Argument: ArgumentRequired, Permission: FTPAuth.PermissionSite})
+	RegisterSiteCommand("HELP", &FTPCommand{Handler: siteHELP, Argument: ArgumentNone})
+	RegisterSiteCommand("RMDIR", &FTPCommand{Handler: siteRMDIR, Argument: ArgumentRequired, Permission: FTPAuth.PermissionRmdir})
+	RegisterSiteCommand("VERSIONS", &FTPCommand{Handler: siteVERSIONS, Argument: ArgumentRequired, Permission: FTPAuth.PermissionList})
+	RegisterSiteCommand("RESTORE", &FTPCommand{Handler: siteRESTORE, Argument: ArgumentRequired, Permission: FTPAuth.PermissionOverwrite})
+	RegisterSiteCommand("QUOTA", &FTPCommand{Handler: siteQUOTA, Argument: ArgumentOptional})
+}
+
+//RegisterSiteCommand adds (or replaces) SITE subcommand. States and KeepsRestOffset fields are not used:
+//subcommand runs in states allowed for SITE. Permission of subcommand is checked with "site" permission
+func RegisterSiteCommand(name string, command *FTPCommand) error {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if len(name) == 0 || strings.ContainsAny(name, " \r\n") {
+		return errors.New(fmt.Sprint("RegisterSiteCommand: wrong name \"", name, "\""))
+	}
+	if command == nil || command.Handler == nil {
+		return errors.New(fmt.Sprint("RegisterSiteCommand: no handler for ", name))
+	}
+	siteCommandsRegistryLock.Lock()
+	siteCommandsRegistry[name] = command
+	siteCommandsRegistryLock.Unlock()
+	return nil
+}
+
+//LookupSiteCommand returns registered SITE subcommand or nil
+func LookupSiteCommand(name string) *FTPCommand {
+	siteCommandsRegistryLock.RLock()
+	defer siteCommandsRegistryLock.RUnlock()
+	return siteCommandsRegistry[strings.ToUpper(name)]
+}
+
+func commandSITE(FTPConn *FTPConnection, args string) {
+	name, subargs := splitCommandLine(args)
+	command := LookupSiteCommand(name)
+	if command == nil {
+		FTPConn.sendResponseToClient("500", fmt.Sprint("SITE ", name, " unrecognized. Use SITE HELP"))
+		return
+	}
+	switch command.Argument {
+	case ArgumentRequired:
+		if len(subargs) == 0 {
+			FTPConn.sendResponseToClient("501", fmt.Sprint("Syntax error: SITE ", name, " requires an argument"))
+			return
+		}
+	case ArgumentNone:
+		if len(subargs) != 0 {
+			FTPConn.sendResponseToClient("501", fmt.Sprint("Syntax error: SITE ", name, " takes no arguments"))
+			return
+		}
+	}
+	if !FTPConn.checkPermission(fmt.Sprint("SITE ", name), command.Permission) {
+		return
+	}
+	command.Handler(FTPConn, subargs)
+}
+func siteHELP(FTPConn *FTPConnection, args string) {
+	siteCommandsRegistryLock.RLock()
+	names := make([]string, 0, len(siteCommandsRegistry))
+	for name := range siteCommandsRegistry {
+		names = append(names, name)
+	}
+	siteCommandsRegistryLock.RUnlock()
+	sort.Strings(names)
+	FTPConn.sendResponseToClient("214", fmt.Sprint("-SITE commands:\r\n ", strings.Join(names, " "), "\r\n214 End"))
+}
+
+//siteRMDIR removes directory: "SITE RMDIR path" - empty one, "SITE RMDIR -R path" - with its content
+func siteRMDIR(FTPConn *FTPConnection, args string) {
+	recursive := false
+	if strings.HasPrefix(args, "-R ") || strings.HasPrefix(args, "-r ") {
+		recursive = true
+		args = strings.TrimSpace(args[3:])
+	}
+	if !recursive {
+		commandRMD(FTPConn, args)
+		return
+	}
+	if !FTPConn.checkPermission("SITE RMDIR -R", FTPAuth.PermissionDelete) {
+		return
+	}
+	if FTPConn.User == nil || FTPConn.User.RecursiveDelete == false {
+		FTPConn.Logger.Log(Logger.UserAction, "SITE RMDIR -R denied for ", args)
+		FTPConn.sendResponseToClient("550", "Permission denied")
+		return
+	}
+	if err := FTPConn.FileSystem.RemoveDirRecursive(args); err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "SITE RMDIR -R error: ", err)
+		FTPConn.sendResponseToClient("550", "Couldn't remove directory")
+		return
+	}
+	FTPConn.sendResponseToClient("250", "Directory removed with its content")
+}
+
+//siteVERSIONS lists previous versions of file: "SITE VERSIONS path"
+func siteVERSIONS(FTPConn *FTPConnection, args string) {
+	versions, err := FTPConn.FileSystem.Versions(args)
+	if err == ftpfs.ErrVersioningDisabled {
+		FTPConn.sendResponseToClient("502", "Versioning is disabled")
+		return
+	}
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "SITE VERSIONS error: ", err)
+		FTPConn.sendResponseToClient("550", "Couldn't list versions")
+		return
+	}
+	lines := make([]string, 0, len(versions))
+	for _, version := range versions {
+		state := "replaced"
+		if version.Deleted {
+			state = "deleted"
+		}
+		kind := fmt.Sprint(version.Size, " bytes")
+		if version.IsDir {
+			kind = "directory"
+		}
+		lines = append(lines, fmt.Sprint(" ", version.ID, " ", version.Time.Format("2006-01-02 15:04:05"), " UTC ", state, " ", kind, "\r\n"))
+	}
+	FTPConn.sendResponseToClient("211", fmt.Sprint("-Versions of ", FTPConn.FileSystem.VirtualPath(args), ": ", len(versions), "\r\n", strings.Join(lines, ""), "211 End"))
+}
+
+//siteRESTORE restores version listed by SITE VERSIONS: "SITE RESTORE version path".
+//Current file is kept as a version
+func siteRESTORE(FTPConn *FTPConnection, args string) {
+	id, fileName := splitCommandLine(args)
+	if len(fileName) == 0 {
+		FTPConn.sendResponseToClient("501", "Syntax error: SITE RESTORE version path")
+		return
+	}
+	err := FTPConn.FileSystem.RestoreVersion(fileName, id)
+	if err == ftpfs.ErrVersioningDisabled {
+		FTPConn.sendResponseToClient("502", "Versioning is disabled")
+		return
+	}
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "SITE RESTORE error: ", err)
+		FTPConn.sendResponseToClient("550", "Couldn't restore version")
+		return
+	}
+	FTPConn.Logger.Log(Logger.UserAction, "Version ", id, " of ", fileName, " restored")
+	FTPConn.sendResponseToClient("250", "Version restored")
+}
+
+//siteQUOTA shows quota usage of user: "SITE QUOTA", "SITE QUOTA RECALC" - usage is computed again
+func siteQUOTA(FTPConn *FTPConnection, args string) {
+	if len(args) > 0 {
+		if strings.ToUpper(args) != "RECALC" {
+			FTPConn.sendResponseToClient("501", "Syntax error: SITE QUOTA [RECALC]")
+			return
+		}
+		if err := FTPConn.FileSystem.RecalculateQuota(); err != nil {
+			FTPConn.Logger.Log(Logger.CriticalMessage, "SITE QUOTA RECALC error: ", err)
+			FTPConn.sendResponseToClient("550", "Couldn't compute quota usage")
+			return
+		}
+	}
+	usage, ok, err := FTPConn.FileSystem.Quota()
+	if err != nil {
+		FTPConn.Logger.Log(Logger.CriticalMessage, "SITE QUOTA error: ", err)
+		FTPConn.sendResponseToClient("550", "Couldn't compute quota usage")
+		return
+	}
+	if !ok {
+		FTPConn.sendResponseToClient("211", "No quota")
+		return
+	}
+	FTPConn.sendResponseToClient("211", fmt.Sprint("-Quota:\r\n Bytes used: ", usage.Bytes, " of ", quotaLimitString(usage.BytesLimit),
+		"\r\n Files used: ", usage.Files, " of ", quotaLimitString(usage.FilesLimit), "\r\n211 End"))
+}
+
+//quotaLimitString returns limit for SITE QUOTA reply
+func quotaLimitString(limit int64) string {
+	if limit == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(limit)
+}
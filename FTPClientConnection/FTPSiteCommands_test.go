@@ -0,0 +1,53 @@
+package FTPClientConnection
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/FTPServConfig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveDirRecursive(t *testing.T) {
+	tests := []struct {
+		name            string
+		recursiveDelete bool
+		line            string
+		code            string
+		removed         bool
+	}{
+		{"RMD of non-empty dir", true, "RMD dir", "550", false},
+		{"XRMD of non-empty dir", true, "XRMD dir", "550", false},
+		{"SITE RMDIR of non-empty dir", true, "SITE RMDIR dir", "550", false},
+		{"SITE RMDIR -R without RecursiveDelete", false, "SITE RMDIR -R dir", "550", false},
+		{"SITE RMDIR -R", true, "SITE RMDIR -R dir", "250", true},
+		{"SITE RMDIR -r", true, "SITE RMDIR -r dir", "250", true},
+		{"SITE RMDIR -R of root", true, "SITE RMDIR -R /", "550", false},
+		{"SITE RMDIR -R of file", true, "SITE RMDIR -R dir/sub/file.txt", "550", false},
+	}
+	for _, test := range tests {
+		config := &FTPServConfig.ConfigStorage{FTPRootFolder: t.TempDir()}
+		filePath := filepath.Join(config.FTPRootFolder, "dir", "sub", "file.txt")
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filePath, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		FTPConn, replies := newTestConnection()
+		FTPConn.User = &FTPAuth.User{UserName: "bob", Folder: "/", RecursiveDelete: test.recursiveDelete}
+		if err := FTPConn.FileSystem.InitFileSystem(config, FTPConn.User); err != nil {
+			t.Fatal(err)
+		}
+		FTPConn.Session.LoggedIn()
+		FTPConn.executeCommand(test.line)
+		FTPConn.Writer.Flush()
+		if code := lastReplyCode(replies); code != test.code {
+			t.Errorf("%s: %q answered with %s, want %s", test.name, test.line, code, test.code)
+		}
+		if _, err := os.Stat(filepath.Join(config.FTPRootFolder, "dir")); os.IsNotExist(err) != test.removed {
+			t.Errorf("%s: dir removed - %v, want %v", test.name, os.IsNotExist(err), test.removed)
+		}
+	}
+}
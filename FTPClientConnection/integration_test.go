@@ -0,0 +1,107 @@
+package FTPClientConnection
+
+import (
+	"FTPServ/FTPServConfig"
+	"strings"
+	"testing"
+)
+
+func TestMemorySession(t *testing.T) {
+	config := &FTPServConfig.ConfigStorage{Storage: "memory", DataPortLow: 42100, DataPortHigh: 42199}
+	listener := startTestServer(t, config, testAuthenticator{"bob": "secret", "eve": "evil"})
+	defer listener.Close()
+
+	client := dialTestServer(t, listener)
+	client.cmd(530, "PWD")
+	client.cmd(331, "USER bob")
+	client.cmd(530, "PASS wrong")
+	client.cmd(331, "USER bob")
+	client.cmd(230, "PASS secret")
+	client.cmd(200, "TYPE I")
+
+	data := strings.Repeat("memory storage ", 10000)
+	client.upload("hello.txt", data)
+	if got := client.download("RETR hello.txt"); got != data {
+		t.Errorf("RETR returned %d bytes, want %d", len(got), len(data))
+	}
+	client.cmd(213, "SIZE hello.txt")
+	if reply := client.cmd(211, "SITE QUOTA"); reply != "No quota" {
+		t.Errorf("SITE QUOTA replied %q", reply)
+	}
+	client.cmd(257, "MKD docs")
+	client.upload("docs/readme.txt", "readme")
+	listing := client.download("LIST")
+	if !strings.Contains(listing, "hello.txt") || !strings.Contains(listing, "docs") {
+		t.Errorf("LIST output misses uploaded objects:\n%s", listing)
+	}
+	if names := client.download("NLST docs"); !strings.Contains(names, "readme.txt") {
+		t.Errorf("NLST docs returned %q", names)
+	}
+	client.cmd(250, "DELE hello.txt")
+	conn := client.pasv()
+	client.cmd(550, "RETR hello.txt")
+	conn.Close()
+	client.cmd(550, "DELE hello.txt")
+	if listing := client.download("LIST"); strings.Contains(listing, "hello.txt") {
+		t.Errorf("deleted file is listed:\n%s", listing)
+	}
+
+	//other user doesn't see files of bob
+	other := dialTestServer(t, listener)
+	other.cmd(331, "USER eve")
+	other.cmd(230, "PASS evil")
+	if listing := other.download("LIST"); strings.Contains(listing, "docs") {
+		t.Errorf("files of other user are listed:\n%s", listing)
+	}
+	conn = other.pasv()
+	other.cmd(550, "RETR ../bob/docs/readme.txt")
+	conn.Close()
+	other.cmd(221, "QUIT")
+
+	client.cmd(221, "QUIT")
+}
+
+//TestDefaultPermissions checks permissions of users without own ones and of anonymous user
+func TestDefaultPermissions(t *testing.T) {
+	//users file without permissions keeps full access
+	config := &FTPServConfig.ConfigStorage{Storage: "memory", DataPortLow: 42200, DataPortHigh: 42249}
+	listener := startTestServer(t, config, testAuthenticator{"alice": "secret"})
+	defer listener.Close()
+	client := dialTestServer(t, listener)
+	client.cmd(331, "USER alice")
+	client.cmd(230, "PASS secret")
+	client.cmd(257, "MKD docs")
+	client.upload("docs/new.txt", "new")
+	client.cmd(250, "DELE docs/new.txt")
+	client.cmd(221, "QUIT")
+
+	config = &FTPServConfig.ConfigStorage{Storage: "memory", DataPortLow: 42250, DataPortHigh: 42299, Anonymous: true, AnonymousFolder: "/pub",
+		DefaultPermissions: []string{"download"}}
+	listener = startTestServer(t, config, testAuthenticator{"reader": "secret"})
+	defer listener.Close()
+	client = dialTestServer(t, listener)
+	client.cmd(331, "USER reader")
+	client.cmd(230, "PASS secret")
+	conn := client.pasv()
+	client.cmd(550, "LIST")
+	conn.Close()
+	//metadata can't be read without list permission
+	client.cmd(550, "SIZE new.txt")
+	client.cmd(550, "MDTM new.txt")
+	client.cmd(550, "MKD docs")
+	conn = client.pasv()
+	client.cmd(550, "STOR new.txt")
+	conn.Close()
+	client.cmd(550, "DELE new.txt")
+	client.cmd(221, "QUIT")
+
+	anonymous := dialTestServer(t, listener)
+	anonymous.cmd(230, "USER anonymous")
+	anonymous.download("LIST")
+	anonymous.cmd(550, "SIZE new.txt")
+	conn = anonymous.pasv()
+	anonymous.cmd(550, "STOR new.txt")
+	conn.Close()
+	anonymous.cmd(550, "MKD docs")
+	anonymous.cmd(221, "QUIT")
+}
@@ -10,11 +10,11 @@ import (
 	"fmt"
 	"github.com/cheggaaa/pb"
 	"io"
-	"math"
 	"net"
-	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 var config *FTPServConfig.ConfigStorage
@@ -25,9 +25,15 @@ type FTPDataConnection struct {
 	FTPActiveDataConnection  *ftpActiveDataConnection
 	TCPServerAddress         string
 	GlobalConfig             *FTPServConfig.ConfigStorage
-	DataTranserAbort         bool
 	UsingTLS                 bool
 	TLSConfig                *FTPtls.FTPTLSServerParameters
+	//ClientAddress - address of control connection client, PORT and EPRT may connect only to it
+	ClientAddress net.IP
+	//aborted is set by Abort from control connection goroutine while transfer runs in another one
+	aborted int32
+	//lock guards connections above and transferConn: transfer closes them when it ends
+	lock         sync.Mutex
+	transferConn net.Conn
 }
 
 type ftpPassiveDataConnection struct {
@@ -38,7 +44,7 @@ type ftpPassiveDataConnection struct {
 }
 type ftpActiveDataConnection struct {
 	DataPortAddress net.TCPAddr
-	Connection      *net.TCPConn
+	Connection      net.Conn
 	Writer          *bufio.Writer
 	Reader          *bufio.Reader
 	UsingTLS        bool
@@ -51,228 +57,335 @@ const (
 	DataConnectionModePassive                    = iota
 )
 
+//network protocols of RFC 2428 EPRT/EPSV commands
+const (
+	NetworkProtocolIPv4 = 1
+	NetworkProtocolIPv6 = 2
+)
+
+//DefaultBufferSize is used if BufferSize is not set in config
+const DefaultBufferSize = 32 * 1024
+
+//ErrTransferAborted is returned by transfer stopped with ABOR
+var ErrTransferAborted = errors.New("Data transfer aborted")
+
+//ErrForeignDataAddress is returned for PORT/EPRT with address other than client's one (FTP bounce)
+var ErrForeignDataAddress = errors.New("Data connection address differs from client address")
+
+//ErrNetworkProtocolNotSupported is returned for EPRT/EPSV with protocol other than server's control connection one
+var ErrNetworkProtocolNotSupported = errors.New("Network protocol not supported")
+
 func (d *FTPDataConnection) DataConnectionsClosed() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
 	return d.FTPPassiveDataConnection == nil && d.FTPActiveDataConnection == nil
 }
 func NewConnection(serveraddr string, servconf *FTPServConfig.ConfigStorage) (*FTPDataConnection, error) {
 	if serveraddr == "" || servconf == nil {
 		return nil, errors.New("NewDataConnection: wrong parameters")
 	}
+	if net.ParseIP(serveraddr) == nil {
+		return nil, errors.New(fmt.Sprint("NewDataConnection: wrong server address ", serveraddr))
+	}
 	dc := new(FTPDataConnection)
 	dc.TCPServerAddress = serveraddr
 	dc.GlobalConfig = servconf
 	return dc, nil
 }
+//CloseConnection closes listener (passive mode) or connection (active mode).
+//Connection is forgotten even if close fails, so next PASV/PORT may open new one
 func (d *FTPDataConnection) CloseConnection() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var err error
 	if d.FTPPassiveDataConnection != nil {
 		if d.FTPPassiveDataConnection.Listener != nil {
-			err := d.FTPPassiveDataConnection.Listener.Close()
-			if err != nil {
-				return err
-			}
-			d.FTPPassiveDataConnection.Listener = nil
-			d.FTPPassiveDataConnection = nil
+			err = d.FTPPassiveDataConnection.Listener.Close()
 		}
+		d.FTPPassiveDataConnection = nil
 	}
 	//close active connection
 	if d.FTPActiveDataConnection != nil {
 		if d.FTPActiveDataConnection.Connection != nil {
-			err := d.FTPActiveDataConnection.Connection.Close()
-			if err != nil {
-				return err
+			if closeErr := d.FTPActiveDataConnection.Connection.Close(); err == nil {
+				err = closeErr
 			}
-			d.FTPActiveDataConnection.Connection = nil
-			d.FTPActiveDataConnection = nil
 		}
+		d.FTPActiveDataConnection = nil
+	}
+	d.transferConn = nil
+	return err
+}
+
+//ClearAbort must be called before transfer is started, so ABOR sent before it doesn't stop it
+func (d *FTPDataConnection) ClearAbort() {
+	atomic.StoreInt32(&d.aborted, 0)
+}
+
+//Abort stops running transfer: its data connection (or passive listener) is closed,
+//so transfer blocked in network read or write ends with ErrTransferAborted
+func (d *FTPDataConnection) Abort() {
+	atomic.StoreInt32(&d.aborted, 1)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.transferConn != nil {
+		d.transferConn.Close()
+	}
+	if d.FTPPassiveDataConnection != nil && d.FTPPassiveDataConnection.Listener != nil {
+		d.FTPPassiveDataConnection.Listener.Close()
 	}
-	return nil
+}
+
+//abortRequested reports if Abort was called after ClearAbort
+func (d *FTPDataConnection) abortRequested() bool {
+	return atomic.LoadInt32(&d.aborted) != 0
+}
+
+//ServerProtocol returns RFC 2428 network protocol of server control connection address
+func (d *FTPDataConnection) ServerProtocol() int {
+	if net.ParseIP(d.TCPServerAddress).To4() != nil {
+		return NetworkProtocolIPv4
+	}
+	return NetworkProtocolIPv6
 }
 
 //для ответа клиенту
-func (d *FTPDataConnection) GetDataPortAddress() (string, error) {
-	ipAddress := d.TCPServerAddress
-	ipAddressSplitted := strings.Split(ipAddress, ".")
-	dataPortString, err := d.countPassiveConnDataPort()
-	if err != nil {
-		return "", err
+func (d *FTPDataConnection) GetDataPortAddress(port int) (string, error) {
+	ip := net.ParseIP(d.TCPServerAddress).To4()
+	if ip == nil {
+		return "", errors.New("PASV is available for IPv4 connections only, use EPSV")
 	}
-	ipAddressSplitted = append(ipAddressSplitted, dataPortString[0], dataPortString[1])
-	ipAddressPort := strings.Join(ipAddressSplitted, ",")
-	return ipAddressPort, nil
+	part1 := port / 256
+	part2 := port % 256
+	return fmt.Sprintf("%d,%d,%d,%d,%d,%d", ip[0], ip[1], ip[2], ip[3], part1, part2), nil
 }
 
 //для жизни
-func (d *FTPDataConnection) countPassiveConnDataPort() ([]string, error) {
-	portnum := -1
+//listenPassiveDataPort opens listener on first free port of configured data port range
+func (d *FTPDataConnection) listenPassiveDataPort() (net.Listener, int, error) {
 	for i := d.GlobalConfig.DataPortLow; i <= d.GlobalConfig.DataPortHigh; i++ {
-		port, err := net.Listen("tcp", fmt.Sprint(d.TCPServerAddress, ":", i))
+		lstn, err := net.Listen("tcp", net.JoinHostPort(d.TCPServerAddress, strconv.Itoa(i)))
 		if err == nil {
-			port.Close()
-			portnum = i
-			break
+			return lstn, i, nil
 		}
 	}
-	if portnum == -1 {
-		return nil, errors.New("No free dataports...")
-	}
-	part2 := math.Mod(float64(portnum), 256)
-	part1 := (float64(portnum) - part2) / 256
-	dataPortString := []string{strconv.Itoa(int(part1)), strconv.Itoa(int(part2))}
-	return dataPortString, nil
+	return nil, 0, errors.New("No free dataports...")
 }
+
+//InitPassiveConnection opens passive data port (PASV) and returns it in h1,h2,h3,h4,p1,p2 form
 func (d *FTPDataConnection) InitPassiveConnection() (string, error) {
-	if d.FTPPassiveDataConnection != nil {
-		if d.FTPPassiveDataConnection.Listener != nil {
-			err := d.CloseConnection()
-			if err != nil {
-				return "", err
-			}
-		}
+	if d.ServerProtocol() != NetworkProtocolIPv4 {
+		return "", errors.New("PASV is available for IPv4 connections only, use EPSV")
 	}
-	pportaddr, err := d.GetDataPortAddress()
+	port, err := d.InitExtendedPassiveConnection(0)
 	if err != nil {
 		return "", err
 	}
-	ftppassconn, err := d.initPassiveConnection(pportaddr)
+	return d.GetDataPortAddress(port)
+}
+
+//InitExtendedPassiveConnection opens passive data port (EPSV) and returns its number.
+//protocol is RFC 2428 network protocol requested by client, 0 - protocol of control connection
+func (d *FTPDataConnection) InitExtendedPassiveConnection(protocol int) (int, error) {
+	if protocol != 0 && protocol != d.ServerProtocol() {
+		return 0, ErrNetworkProtocolNotSupported
+	}
+	d.CloseConnection()
+	lstn, port, err := d.listenPassiveDataPort()
 	if err != nil {
-		return "", err
+		return 0, err
 	}
-	d.FTPPassiveDataConnection = ftppassconn
-	d.FTPPassiveDataConnection.UsingTLS = d.UsingTLS
-	d.FTPPassiveDataConnection.TLSConfig = d.TLSConfig
+	PassConn := new(ftpPassiveDataConnection)
+	PassConn.DataPortAddress = *lstn.Addr().(*net.TCPAddr)
+	PassConn.UsingTLS = d.UsingTLS
+	PassConn.TLSConfig = d.TLSConfig
+	if PassConn.UsingTLS {
+		lstn = tls.NewListener(lstn, PassConn.TLSConfig.TLSConfig)
+	}
+	PassConn.Listener = lstn
+	d.lock.Lock()
+	d.FTPPassiveDataConnection = PassConn
 	d.dataConnectionMode = DataConnectionModePassive
-	Logger.Log(fmt.Sprint("(DataConn *FTPDataConnection) Init(PASSIVE) PASV ADDRESS: ", pportaddr))
-	return pportaddr, ftppassconn.openConnection()
+	d.lock.Unlock()
+	Logger.Log(fmt.Sprint("(DataConn *FTPDataConnection) Init(PASSIVE) PASV ADDRESS: ", PassConn.DataPortAddress.String()))
+	return port, nil
 }
+
+//InitActiveConnection connects to client address sent with PORT (h1,h2,h3,h4,p1,p2)
 func (d *FTPDataConnection) InitActiveConnection(clientaddr string) error {
-	if d.FTPActiveDataConnection != nil {
-		if d.FTPActiveDataConnection.Connection != nil {
-			err := d.CloseConnection()
-			if err != nil {
-				return err
-			}
-		}
-	}
 	aportaddr, err := d.parseDataPortAddr(clientaddr)
 	if err != nil {
 		return err
 	}
+	return d.initActiveConnection(aportaddr)
+}
+
+//InitExtendedActiveConnection connects to client address sent with EPRT (|proto|address|port|)
+func (d *FTPDataConnection) InitExtendedActiveConnection(clientaddr string) error {
+	aportaddr, err := d.parseExtendedDataPortAddr(clientaddr)
+	if err != nil {
+		return err
+	}
+	return d.initActiveConnection(aportaddr)
+}
+func (d *FTPDataConnection) initActiveConnection(aportaddr net.TCPAddr) error {
+	if !aportaddr.IP.Equal(d.ClientAddress) {
+		return ErrForeignDataAddress
+	}
+	d.CloseConnection()
 	conn, err := net.DialTCP("tcp", nil, &aportaddr)
 	if err != nil {
 		return err
 	}
 	ActiveConn := new(ftpActiveDataConnection)
 	ActiveConn.DataPortAddress = aportaddr
+	ActiveConn.UsingTLS = d.UsingTLS
+	ActiveConn.TLSConfig = d.TLSConfig
 	ActiveConn.Connection = conn
-	ActiveConn.Reader = bufio.NewReader(conn)
-	ActiveConn.Writer = bufio.NewWriter(conn)
+	if ActiveConn.UsingTLS {
+		ActiveConn.Connection = tls.Server(conn, ActiveConn.TLSConfig.TLSConfig)
+	}
+	ActiveConn.Reader = bufio.NewReader(ActiveConn.Connection)
+	ActiveConn.Writer = bufio.NewWriter(ActiveConn.Connection)
+	d.lock.Lock()
 	d.FTPActiveDataConnection = ActiveConn
 	d.dataConnectionMode = DataConnectionModeActive
+	d.lock.Unlock()
 	return nil
 }
-func (p *ftpPassiveDataConnection) openConnection() error {
-	fmt.Println(&p.DataPortAddress)
-	lstn, err := net.Listen("tcp", p.DataPortAddress.String())
-	if p.UsingTLS {
-		lstn = tls.NewListener(lstn, p.TLSConfig.TLSConfig)
+
+func (d *FTPDataConnection) parseDataPortAddr(dataPort string) (net.TCPAddr, error) {
+	PortParamsSplitted := strings.Split(strings.TrimSpace(dataPort), ",")
+	if len(PortParamsSplitted) != 6 {
+		return net.TCPAddr{}, errors.New(fmt.Sprint("Wrong data port address: ", dataPort))
 	}
-	if err != nil {
-		return err
+	nums := make([]int, 6)
+	for i, param := range PortParamsSplitted {
+		num, err := strconv.Atoi(strings.TrimSpace(param))
+		if err != nil || num < 0 || num > 255 {
+			return net.TCPAddr{}, errors.New(fmt.Sprint("Wrong data port address: ", dataPort))
+		}
+		nums[i] = num
 	}
-	p.Listener = lstn
-	return nil
+	portnum := nums[4]*256 + nums[5]
+	ip := net.IPv4(byte(nums[0]), byte(nums[1]), byte(nums[2]), byte(nums[3]))
+	tcpaddr := net.TCPAddr{IP: ip, Port: portnum}
+	return tcpaddr, nil
 }
-func (d *FTPDataConnection) initPassiveConnection(DataPort string) (*ftpPassiveDataConnection, error) {
-	tcpaddr, err := d.parseDataPortAddr(DataPort)
+
+//parseExtendedDataPortAddr parses RFC 2428 address: <d><net-prt><d><net-addr><d><tcp-port><d>
+func (d *FTPDataConnection) parseExtendedDataPortAddr(dataPort string) (net.TCPAddr, error) {
+	dataPort = strings.TrimSpace(dataPort)
+	if len(dataPort) < 4 {
+		return net.TCPAddr{}, errors.New(fmt.Sprint("Wrong extended data port address: ", dataPort))
+	}
+	delimiter := dataPort[:1]
+	params := strings.Split(dataPort, delimiter)
+	//leading and trailing delimiters give empty first and last fields
+	if len(params) != 5 || params[0] != "" || params[4] != "" {
+		return net.TCPAddr{}, errors.New(fmt.Sprint("Wrong extended data port address: ", dataPort))
+	}
+	protocol, err := strconv.Atoi(params[1])
 	if err != nil {
-		return nil, err
+		return net.TCPAddr{}, errors.New(fmt.Sprint("Wrong network protocol: ", params[1]))
 	}
-	PassConn := new(ftpPassiveDataConnection)
-	PassConn.DataPortAddress = tcpaddr
-	return PassConn, nil
+	if protocol != NetworkProtocolIPv4 && protocol != NetworkProtocolIPv6 {
+		return net.TCPAddr{}, ErrNetworkProtocolNotSupported
+	}
+	ip := net.ParseIP(params[2])
+	if ip == nil || (protocol == NetworkProtocolIPv4) != (ip.To4() != nil) {
+		return net.TCPAddr{}, errors.New(fmt.Sprint("Wrong network address: ", params[2]))
+	}
+	portnum, err := strconv.Atoi(params[3])
+	if err != nil || portnum <= 0 || portnum > 65535 {
+		return net.TCPAddr{}, errors.New(fmt.Sprint("Wrong port: ", params[3]))
+	}
+	return net.TCPAddr{IP: ip, Port: portnum}, nil
 }
 
-func (d *FTPDataConnection) parseDataPortAddr(dataPort string) (net.TCPAddr, error) {
-	PortParamsSplitted := strings.Split(dataPort, ",")
-	num1, _ := strconv.Atoi(PortParamsSplitted[4])
-	num2, _ := strconv.Atoi(PortParamsSplitted[5])
-	portnum := num1*256 + num2
-	ip := net.ParseIP(fmt.Sprint(PortParamsSplitted[0], ".", PortParamsSplitted[1], ".", PortParamsSplitted[2], ".", PortParamsSplitted[3]))
-	tcpaddr := net.TCPAddr{ip, portnum, ""}
-	return tcpaddr, nil
-}
-func (d *FTPDataConnection) TransferASCIIData(data string) error {
-	if d.dataConnectionMode == DataConnectionModePassive {
-		if d.FTPPassiveDataConnection == nil {
-			return errors.New("No passive TCP connection found for client! Type PASV to run passive mode connection")
-		}
-		if d.FTPPassiveDataConnection.Listener == nil {
-			return errors.New("No passive TCP listener found for client! Type PASV to run passive mode connection")
-		}
-		dataConn, err := d.FTPPassiveDataConnection.Listener.Accept()
-		if err != nil {
-			return err
-		}
-		writer := bufio.NewWriter(dataConn)
-		writer.Write([]byte(data))
-		writer.Write([]byte{13, 10})
-		writer.Flush()
-		dataConn.Close()
-		return d.CloseConnection()
+//openDataConnection returns data connection for both modes: accepted passive connection or dialed active one
+//Connection is remembered, so Abort can close it
+func (d *FTPDataConnection) openDataConnection() (net.Conn, error) {
+	d.lock.Lock()
+	if err := d.checkIfConnectionOpened(); err != nil {
+		d.lock.Unlock()
+		return nil, err
 	}
-	if d.dataConnectionMode == DataConnectionModeActive {
-		if d.FTPActiveDataConnection == nil {
-			return errors.New("No active TCP connection found for server. Type PORT (h1,h2,h3,h4,h5,h6) to run active mode connection")
+	if d.dataConnectionMode != DataConnectionModePassive {
+		conn := d.FTPActiveDataConnection.Connection
+		d.transferConn = conn
+		d.lock.Unlock()
+		if d.abortRequested() {
+			return nil, ErrTransferAborted
 		}
-		if d.FTPActiveDataConnection.Connection == nil {
-			return errors.New("No active TCP connection found for server. Type PORT (h1,h2,h3,h4,h5,h6) to run active mode connection")
+		return conn, nil
+	}
+	listener := d.FTPPassiveDataConnection.Listener
+	d.lock.Unlock()
+	conn, err := listener.Accept()
+	if err != nil {
+		if d.abortRequested() {
+			return nil, ErrTransferAborted
 		}
-		d.FTPActiveDataConnection.Writer.Write([]byte(data))
-		d.FTPActiveDataConnection.Writer.Write([]byte{13, 10})
-		d.FTPActiveDataConnection.Writer.Flush()
-		return nil
+		return nil, err
 	}
-	return nil
+	d.lock.Lock()
+	d.transferConn = conn
+	d.lock.Unlock()
+	if d.abortRequested() {
+		conn.Close()
+		return nil, ErrTransferAborted
+	}
+	return conn, nil
 }
-func (d *FTPDataConnection) GetBinaryFile() error {
-	return nil
+func (d *FTPDataConnection) closeDataConnection(conn net.Conn) {
+	if d.dataConnectionMode == DataConnectionModePassive {
+		conn.Close()
+	}
 }
-func (d *FTPDataConnection) ReceiveBinaryFile(fileName string) error {
-	if err := d.CheckIfConnectionOpened(); err != nil {
+func (d *FTPDataConnection) TransferASCIIData(data string) error {
+	conn, err := d.openDataConnection()
+	if err != nil {
 		return err
 	}
 	defer d.CloseConnection()
-	if d.dataConnectionMode == DataConnectionModeActive {
-	} else if d.dataConnectionMode == DataConnectionModePassive {
-		conn, err := d.FTPPassiveDataConnection.Listener.Accept()
-		if err != nil {
-			return err
-		}
-		defer conn.Close()
-		d.receiveBinaryData(fileName, conn)
-	}
+	defer d.closeDataConnection(conn)
+	writer := bufio.NewWriter(conn)
+	writer.Write([]byte(data))
+	writer.Write([]byte{13, 10})
+	return writer.Flush()
+}
+func (d *FTPDataConnection) GetBinaryFile() error {
 	return nil
 }
-func (d *FTPDataConnection) TransferBinaryFile(file *os.File) error {
-	if err := d.CheckIfConnectionOpened(); err != nil {
+//ReceiveBinaryFile writes data from data connection to file
+//(file opened by ftpfs STOR already positioned at REST offset or opened for append)
+func (d *FTPDataConnection) ReceiveBinaryFile(file io.Writer) error {
+	conn, err := d.openDataConnection()
+	if err != nil {
 		return err
 	}
 	defer d.CloseConnection()
-	if d.dataConnectionMode == DataConnectionModeActive {
-		d.transferBinaryDataToConnection(file, d.FTPActiveDataConnection.Connection)
-		return nil
-	} else if d.dataConnectionMode == DataConnectionModePassive {
-		conn, err := d.FTPPassiveDataConnection.Listener.Accept()
-		defer conn.Close()
-		if err != nil {
-			return err
-		}
-		d.transferBinaryDataToConnection(file, conn)
-		return nil
+	defer d.closeDataConnection(conn)
+	return d.receiveBinaryData(file, conn)
+}
+
+//TransferBinaryFile sends file to data connection. size is number of bytes left to send (for progress bar)
+func (d *FTPDataConnection) TransferBinaryFile(file io.Reader, size int64) error {
+	conn, err := d.openDataConnection()
+	if err != nil {
+		return err
 	}
-	return nil
+	defer d.CloseConnection()
+	defer d.closeDataConnection(conn)
+	return d.transferBinaryDataToConnection(file, size, conn)
 }
 func (d *FTPDataConnection) CheckIfConnectionOpened() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.checkIfConnectionOpened()
+}
+func (d *FTPDataConnection) checkIfConnectionOpened() error {
 	if d.dataConnectionMode == DataConnectionModeActive {
 		if d.FTPActiveDataConnection == nil {
 			return errors.New("No FTP Data connection (mode:active)")
@@ -290,61 +403,81 @@ func (d *FTPDataConnection) CheckIfConnectionOpened() error {
 	}
 	return nil
 }
-func (d *FTPDataConnection) transferBinaryDataToConnection(file *os.File, conn net.Conn) {
-	sendFileBuff := make([]byte, d.GlobalConfig.BufferSize)
-	stats, _ := file.Stat()
-	size := stats.Size()
+func (d *FTPDataConnection) bufferSize() int {
+	if d.GlobalConfig.BufferSize <= 0 {
+		return DefaultBufferSize
+	}
+	return d.GlobalConfig.BufferSize
+}
+func (d *FTPDataConnection) transferBinaryDataToConnection(file io.Reader, size int64, conn net.Conn) error {
+	sendFileBuff := make([]byte, d.bufferSize())
 	progressbar := pb.StartNew(int(size))
+	defer progressbar.Finish()
 	progress := 0
 	for {
-		if d.DataTranserAbort {
-			d.DataTranserAbort = false
+		if d.abortRequested() {
 			Logger.Log("Data transfer aborted")
-			progressbar.Finish()
-			return
+			return ErrTransferAborted
 		}
 		count, err := file.Read(sendFileBuff)
-		progress += count
-		progressbar.Set(progress)
+		if count > 0 {
+			if _, err := conn.Write(sendFileBuff[:count]); err != nil {
+				if d.abortRequested() {
+					Logger.Log("Data transfer aborted")
+					return ErrTransferAborted
+				}
+				return err
+			}
+			progress += count
+			progressbar.Set(progress)
+		}
 		if err == io.EOF {
-			progressbar.Finish()
 			Logger.Log("Data transfer completed, total ", progress, " bytes")
-			break
+			return nil
+		}
+		if err != nil {
+			return err
 		}
-		conn.Write(sendFileBuff)
 	}
 }
-func (d *FTPDataConnection) receiveBinaryData(fileName string, conn net.Conn) error {
-	receiveBuffer := make([]byte, d.GlobalConfig.BufferSize)
+func (d *FTPDataConnection) receiveBinaryData(file io.Writer, conn net.Conn) error {
+	receiveBuffer := make([]byte, d.bufferSize())
 	Logger.Log("Receiving data from ", conn.RemoteAddr().String(), "...")
-	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, os.ModeAppend)
-	if err != nil {
-		Logger.Log("Can't open source file for edit: ", err)
-		return err
-	}
 	writer := bufio.NewWriter(file)
 	received := 0
 	for {
-		if d.DataTranserAbort {
-			d.DataTranserAbort = false
+		if d.abortRequested() {
+			writer.Flush()
 			Logger.Log("Data transfer aborted")
-			return nil
+			return ErrTransferAborted
 		}
 		rec, err := conn.Read(receiveBuffer)
-		if err == nil {
-			writer.Write(receiveBuffer[:rec])
+		if rec > 0 {
+			if _, err := writer.Write(receiveBuffer[:rec]); err != nil {
+				Logger.Log("Data receiving error: ", err)
+				return err
+			}
 			received += rec
 			fmt.Printf("\rReceiving data, received %d bytes", received)
-		} else {
-			err := writer.Flush()
-			if err != nil {
+		}
+		if err == io.EOF {
+			if err := writer.Flush(); err != nil {
 				Logger.Log("Data receiving error: ", err)
-				file.Close()
 				return err
 			}
 			fmt.Printf("\r\n")
 			Logger.Log("Data received, total ", received, " bytes")
 			return nil
 		}
+		if err != nil && d.abortRequested() {
+			writer.Flush()
+			Logger.Log("Data transfer aborted")
+			return ErrTransferAborted
+		}
+		if err != nil {
+			writer.Flush()
+			Logger.Log("Data receiving error: ", err)
+			return err
+		}
 	}
 }
@@ -0,0 +1,75 @@
+package FTPDataTransfer
+
+import (
+	"FTPServ/FTPServConfig"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestActiveConnectionOnlyToClient(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	tests := []struct {
+		name     string
+		client   net.IP
+		extended bool
+		address  string
+		err      error
+	}{
+		{"PORT to client", net.ParseIP("127.0.0.1"), false, fmt.Sprintf("127,0,0,1,%d,%d", port/256, port%256), nil},
+		{"PORT to other host", net.ParseIP("127.0.0.1"), false, fmt.Sprintf("127,0,0,2,%d,%d", port/256, port%256), ErrForeignDataAddress},
+		{"EPRT to client", net.ParseIP("127.0.0.1"), true, fmt.Sprintf("|1|127.0.0.1|%d|", port), nil},
+		{"EPRT to other host", net.ParseIP("127.0.0.1"), true, fmt.Sprintf("|1|10.1.2.3|%d|", port), ErrForeignDataAddress},
+		{"EPRT to IPv6 host", net.ParseIP("127.0.0.1"), true, fmt.Sprintf("|2|::1|%d|", port), ErrForeignDataAddress},
+		{"IPv4 client of IPv6 socket", net.ParseIP("::ffff:127.0.0.1"), false, fmt.Sprintf("127,0,0,1,%d,%d", port/256, port%256), nil},
+		{"unknown client", nil, true, fmt.Sprintf("|1|127.0.0.1|%d|", port), ErrForeignDataAddress},
+	}
+	for _, test := range tests {
+		d, err := NewConnection("127.0.0.1", &FTPServConfig.ConfigStorage{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.ClientAddress = test.client
+		if test.extended {
+			err = d.InitExtendedActiveConnection(test.address)
+		} else {
+			err = d.InitActiveConnection(test.address)
+		}
+		if err != test.err {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+		}
+		if err == nil && d.CheckIfConnectionOpened() != nil {
+			t.Errorf("%s: data connection is not opened", test.name)
+		}
+		d.CloseConnection()
+	}
+}
+
+func TestAbortBeforeTransfer(t *testing.T) {
+	d, err := NewConnection("127.0.0.1", &FTPServConfig.ConfigStorage{DataPortLow: 40000, DataPortHigh: 40100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.InitExtendedPassiveConnection(0); err != nil {
+		t.Fatal(err)
+	}
+	d.ClearAbort()
+	d.Abort()
+	if err := d.TransferBinaryFile(nil, 0); err != ErrTransferAborted {
+		t.Errorf("aborted transfer returned %v, want %v", err, ErrTransferAborted)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"strings"
 )
@@ -17,6 +18,9 @@ type Configurator struct {
 
 const MaxPeer int = 500
 
+//DefaultAnonymousFolder is folder of anonymous user if AnonymousFolder is empty
+const DefaultAnonymousFolder = "/anonymous"
+
 type ConfigStorage struct {
 	Port           int
 	Anonymous      bool
@@ -25,6 +29,82 @@ type ConfigStorage struct {
 	DataPortHigh   int
 	MaxClientValue int
 	BufferSize     int
+	//AnonymousFolder - folder of user "anonymous" if Anonymous is set ("/anonymous" if empty, relative
+	//to FTPRootFolder). Anonymous user logs in without password and may only list and download files
+	AnonymousFolder string
+	//ListenAddress - IPv4 or IPv6 address for control connection, empty - all addresses of both families
+	ListenAddress string
+	//Storage - storage of user files: "local" (FTPRootFolder, default) or "memory"
+	Storage string
+	//MemoryStorageLimit, MemoryFileSizeLimit - limits of "memory" storage in bytes, 0 - no limit
+	MemoryStorageLimit  int64
+	MemoryFileSizeLimit int64
+	//S3 settings of "s3" storage. S3PathStyle - "endpoint/bucket/key" addressing (MinIO),
+	//S3AccessKey and S3SecretKey - AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are used if empty
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3Prefix    string
+	S3AccessKey string
+	S3SecretKey string
+	S3PathStyle bool
+	S3PartSize  int64
+	//ArchiveMounts - patterns of .zip, .tar, .tar.gz and .tgz files shown as read-only directories:
+	//virtual paths ("/releases/*.zip") or file names ("*.tar.gz")
+	ArchiveMounts []string
+	//AtomicUploads - uploads are written to hidden temporary files and moved to their place when complete.
+	//PartialUploadsMaxAge - temporary files older than it (minutes) are removed on server start, < 0 - not removed
+	AtomicUploads        bool
+	PartialUploadsMaxAge int
+	//OverwritePolicy - STOR of existing file: "reject" (default), "overwrite" or "rename" (to "name.N.ext")
+	OverwritePolicy string
+	//Versioning - previous versions of replaced and appended files are kept in /.versions, deleted objects - in /.trash
+	//of user root folder. KeepVersions - versions kept for each file, VersionsMaxAge - in days (0 - no limit)
+	Versioning     bool
+	KeepVersions   int
+	VersionsMaxAge int
+	//DefaultQuotaBytes, DefaultQuotaFiles - quota of users without own limits, 0 - no limit. Versions and trash are
+	//counted, oldest of them are removed when quota is reached
+	DefaultQuotaBytes int64
+	DefaultQuotaFiles int64
+	//DefaultPermissions - permissions of users without own ones ("list", "download", "upload", "overwrite",
+	//"delete", "rename", "mkdir", "rmdir", "chmod", "site" or "all"), empty - all permissions
+	DefaultPermissions []string
+	//AuthBackends - authentication backends asked in order: "json" (users.json, default), "htpasswd", "sqlite", "ldap",
+	//"command" and "http" (external program or endpoint, see FTPAuth.ExternalAuthRequest).
+	//AuthUserFolder - folder of users of backends without folders, "{user}" is user name ("/{user}" if empty)
+	AuthBackends   []string
+	AuthUserFolder string
+	HtpasswdFile   string
+	//SQLiteFile - database (data source) of "sqlite" backend, SQLDriver - database/sql driver linked into server
+	//("sqlite" of modernc.org/sqlite if empty, see FTPAuth.DefaultSQLDriver),
+	//SQLUsersQuery - query selecting password hash and folder by user name
+	SQLiteFile    string
+	SQLDriver     string
+	SQLUsersQuery string
+	//LDAP backend. LDAPURL - "ldap://host[:port]" or "ldaps://host[:port]", LDAPStartTLS - StartTLS on ldap://.
+	//LDAPUserDN - DN of users for direct bind ("uid={user},ou=people,dc=example,dc=com"). Without it users are
+	//searched in LDAPBaseDN with LDAPUserFilter ("(uid={user})" if empty) as LDAPBindDN (anonymously if empty).
+	//LDAPFolderAttribute - attribute with user folder (AuthUserFolder if not set), LDAPGroupAttribute - attribute
+	//with groups of user ("memberOf" if empty), LDAPGroupPermissions - permissions added to DefaultPermissions
+	//(read-only if empty) for group members by group DN or name. LDAPCacheTime - seconds users are cached after login, 0 - no cache
+	LDAPURL              string
+	LDAPStartTLS         bool
+	LDAPBindDN           string
+	LDAPBindPassword     string
+	LDAPUserDN           string
+	LDAPBaseDN           string
+	LDAPUserFilter       string
+	LDAPFolderAttribute  string
+	LDAPGroupAttribute   string
+	LDAPGroupPermissions map[string][]string
+	LDAPCacheTime        int
+	//ExternalAuthCommand - program and its arguments of "command" backend, ExternalAuthURL - endpoint of "http"
+	//backend, ExternalAuthToken - its bearer token. ExternalAuthTimeout - seconds to answer (10 if 0)
+	ExternalAuthCommand []string
+	ExternalAuthURL     string
+	ExternalAuthToken   string
+	ExternalAuthTimeout int
 }
 
 func LoadConfig() (config *ConfigStorage, err error) {
@@ -37,7 +117,28 @@ func LoadConfig() (config *ConfigStorage, err error) {
 	return config, nil
 }
 func (c *Configurator) Print() {
-	fmt.Println("Dataport = ", c.Config.DataPortLow, "-", c.Config.DataPortHigh, "\r\nPort = ", c.Config.Port, "\r\nMax peers = ", c.Config.MaxClientValue, "\r\nAllow anonymous = ", c.Config.Anonymous, "\r\nRoot folder = ", c.Config.FTPRootFolder, "\r\n")
+	fmt.Println("Dataport = ", c.Config.DataPortLow, "-", c.Config.DataPortHigh, "\r\nPort = ", c.Config.Port, "\r\nMax peers = ", c.Config.MaxClientValue, "\r\nAllow anonymous = ", c.Config.Anonymous, "\r\nRoot folder = ", c.Config.FTPRootFolder, "\r\nListen address = ", c.Config.ListenAddress, "\r\nStorage = ", c.Config.Storage, "\r\nOverwrite policy = ", c.Config.OverwritePolicy, "\r\n")
+	if c.Config.Storage == "s3" {
+		fmt.Println("S3 endpoint = ", c.Config.S3Endpoint, "\r\nS3 bucket = ", c.Config.S3Bucket, "\r\nS3 prefix = ", c.Config.S3Prefix)
+	}
+	if len(c.Config.ArchiveMounts) > 0 {
+		fmt.Println("Archive mounts = ", strings.Join(c.Config.ArchiveMounts, ", "))
+	}
+	if c.Config.AtomicUploads {
+		fmt.Println("Atomic uploads = true, partial uploads max age = ", c.Config.PartialUploadsMaxAge, " min")
+	}
+	if c.Config.Versioning {
+		fmt.Println("Versioning = true, versions kept = ", c.Config.KeepVersions, ", versions max age = ", c.Config.VersionsMaxAge, " days")
+	}
+	if c.Config.DefaultQuotaBytes > 0 || c.Config.DefaultQuotaFiles > 0 {
+		fmt.Println("Default quota = ", c.Config.DefaultQuotaBytes, " bytes, ", c.Config.DefaultQuotaFiles, " files")
+	}
+	if len(c.Config.DefaultPermissions) > 0 {
+		fmt.Println("Default permissions = ", strings.Join(c.Config.DefaultPermissions, ", "))
+	}
+	if len(c.Config.AuthBackends) > 0 {
+		fmt.Println("Authentication backends = ", strings.Join(c.Config.AuthBackends, ", "))
+	}
 	fmt.Println("BufferSize = ", c.Config.BufferSize)
 }
 func (c *Configurator) SetAnonymous(value bool) {
@@ -46,6 +147,19 @@ func (c *Configurator) SetAnonymous(value bool) {
 func (c *Configurator) SetBufferSize(newSize int) {
 	c.Config.BufferSize = newSize
 }
+func (c *Configurator) SetListenAddress(address string) error {
+	if address != "" && net.ParseIP(address) == nil {
+		return errors.New(fmt.Sprint("func SetListenAddress() error: ", address, " is not an IP address"))
+	}
+	c.Config.ListenAddress = address
+	return nil
+}
+func (c *Configurator) SetStorage(storage string) {
+	c.Config.Storage = strings.ToLower(strings.TrimSpace(storage))
+}
+func (c *Configurator) SetOverwritePolicy(policy string) {
+	c.Config.OverwritePolicy = strings.ToLower(strings.TrimSpace(policy))
+}
 func ReadConfig() (*Configurator, error) {
 	file, err := os.Open("config.json")
 	if err != nil {
@@ -80,6 +194,7 @@ func CreateConfig() *Configurator {
 	cfgrt.Config = new(ConfigStorage)
 	cfgrt.Config.Port = 21
 	cfgrt.Config.Anonymous = false
+	cfgrt.Config.AnonymousFolder = DefaultAnonymousFolder
 	cfgrt.Config.MaxClientValue = 100
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
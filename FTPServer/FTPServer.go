@@ -7,6 +7,7 @@ import (
 	"FTPServ/FTPServConfig"
 	"FTPServ/FTPtls"
 	"FTPServ/Logger"
+	"FTPServ/ftpfs"
 	"crypto/tls"
 
 	//"FTPServ/CBModule"
@@ -14,6 +15,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 )
 
 var Config *FTPServConfig.ConfigStorage
@@ -27,6 +29,20 @@ type TCPServer struct {
 
 func StartFTPServer(cnfg *FTPServConfig.ConfigStorage, users *FTPAuth.Users, stopCh chan bool, Secured bool) {
 	Config = cnfg
+	if Config.AtomicUploads && Config.PartialUploadsMaxAge >= 0 {
+		removed, err := ftpfs.CleanPartialUploads(Config, users)
+		if err != nil {
+			Logger.Log("Partial uploads cleanup error: ", err)
+		}
+		if removed > 0 {
+			Logger.Log("Partial uploads removed: ", removed)
+		}
+	}
+	authenticator, err := FTPAuth.NewAuthenticator(Config, users)
+	if err != nil {
+		Logger.Log("func StartFTPServer(): ", err, ". Server stops now")
+		os.Exit(1)
+	}
 	TCPServParameters := new(TCPServer)
 	//для сообщения серверу, что соединение закрыто
 	FTPConnClosedString := make(chan string)
@@ -83,7 +99,12 @@ func StartFTPServer(cnfg *FTPServConfig.ConfigStorage, users *FTPAuth.Users, sto
 			conn.Close()
 			continue
 		}
-		FTPConn, err := FTPClientConnection.InitConnection(conn, TCPServParameters.ServerAddress.IP.String(), FTPConnClosedString, Config, users, TCPServParameters.TLSConfig, (TCPServParameters.PeersCount + 1))
+		//data connections are opened on the address client connected to (IPv4 or IPv6)
+		localAddress := TCPServParameters.ServerAddress.IP.String()
+		if tcpAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+			localAddress = tcpAddr.IP.String()
+		}
+		FTPConn, err := FTPClientConnection.InitConnection(conn, localAddress, FTPConnClosedString, Config, authenticator, TCPServParameters.TLSConfig, (TCPServParameters.PeersCount + 1))
 		if err != nil {
 			Logger.Log("Init new connection error: ", err)
 			FTPConn = nil
@@ -101,17 +122,22 @@ func (s *TCPServer) CreateTCPSocket(secured bool) error {
 		Logger.Log(fmt.Sprint("GetMachineIPAddress returns error: ", err))
 		return errors.New("There was an error while opening TCP Socket")
 	}
-	s.ServerAddress = net.TCPAddr{ipaddr, Config.Port, ""}
-	Logger.Log(fmt.Sprint("Opening TCP socket at: ", s.ServerAddress), "(secured: ", secured, ")")
+	s.ServerAddress = net.TCPAddr{IP: ipaddr, Port: Config.Port}
+	//empty ListenAddress - listen on all IPv4 and IPv6 addresses
+	listenAddress := net.JoinHostPort(Config.ListenAddress, strconv.Itoa(Config.Port))
+	if Config.ListenAddress != "" {
+		s.ServerAddress.IP = net.ParseIP(Config.ListenAddress)
+	}
+	Logger.Log(fmt.Sprint("Opening TCP socket at: ", listenAddress), "(secured: ", secured, ")")
 	var Listener net.Listener
 	if secured {
-		Listener, err = tls.Listen("tcp4", s.ServerAddress.String(), s.TLSConfig.TLSConfig)
+		Listener, err = tls.Listen("tcp", listenAddress, s.TLSConfig.TLSConfig)
 		if err != nil {
 			Logger.Log("Error to listen to TCP (secured): ", err)
 			return errors.New("There was an error while opening TCP-TLS Socket")
 		}
 	} else {
-		Listener, err = net.Listen("tcp4", s.ServerAddress.String())
+		Listener, err = net.Listen("tcp", listenAddress)
 		if err != nil {
 			Logger.Log("Error to listen to TCP: ", err)
 			return errors.New("There was an error while opening TCP Socket")
@@ -119,7 +145,7 @@ func (s *TCPServer) CreateTCPSocket(secured bool) error {
 	}
 	s.Listener = Listener
 	//TCPServParameters.Listener = tls.NewListener(Listener, TCPServParameters.TLSConfig)
-	Logger.Log(fmt.Sprint("FTP Server running at: ", s.ServerAddress, "(secured : ", secured, ").", "\nWaiting for incoming connections..."))
+	Logger.Log(fmt.Sprint("FTP Server running at: ", Listener.Addr(), "(secured : ", secured, ").", "\nWaiting for incoming connections..."))
 	return nil
 }
 
@@ -128,14 +154,23 @@ func getMachineIPAddress() (net.IP, error) {
 	if err != nil {
 		return nil, errors.New(fmt.Sprint("Couldn't get net.InterfaceAddrs: ", err))
 	}
+	//IPv4 address is preferred, global IPv6 one is used on IPv6-only machines
+	var ipv6 net.IP
 	for _, a := range addrs {
 		if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
 			if ipnet.IP.To4() != nil {
 				Logger.Log(fmt.Sprint("Current machine IP is ", ipnet.IP.To4()))
 				return ipnet.IP.To4(), nil
 			}
+			if ipv6 == nil && ipnet.IP.IsGlobalUnicast() {
+				ipv6 = ipnet.IP
+			}
 		}
 	}
+	if ipv6 != nil {
+		Logger.Log(fmt.Sprint("Current machine IP is ", ipv6))
+		return ipv6, nil
+	}
 	defer os.Exit(1)
 	return nil, errors.New("machine has no IP address. Exiting...")
 }
@@ -0,0 +1,524 @@
+// Read-only archive mounts: ZIP and tar files shown as directories
+package ftpfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//permissions of archive members: archives are read-only
+const (
+	archiveDirMode  os.FileMode = os.ModeDir | 0555
+	archiveFileMode os.FileMode = 0444
+)
+
+//ArchiveDriver shows archive files (.zip, .tar, .tar.gz, .tgz) matching Patterns as read-only directories,
+//other paths are served by Driver. Patterns are path.Match patterns of virtual paths ("/releases/*.zip"),
+//patterns without "/" are matched with file name ("*.zip")
+type ArchiveDriver struct {
+	Driver
+	Patterns []string
+	lock     sync.Mutex
+	indexes  map[string]*archiveIndex
+}
+
+//NewArchiveDriver returns driver showing archives of driver matching patterns as directories
+func NewArchiveDriver(driver Driver, patterns []string) *ArchiveDriver {
+	return &ArchiveDriver{Driver: driver, Patterns: patterns, indexes: make(map[string]*archiveIndex)}
+}
+
+//archiveEntry is a member of archive (or directory made for members paths)
+type archiveEntry struct {
+	info     driverFileInfo
+	children map[string]*archiveEntry
+}
+
+//archiveIndex keeps members of archive, it is rebuilt if archive size or modification time changes.
+//Files of zip archive are kept with reader of their local headers
+type archiveIndex struct {
+	size      int64
+	modTime   time.Time
+	root      *archiveEntry
+	zipFiles  map[string]*zip.File
+	zipReader *archiveReaderAt
+}
+
+//archiveFormat returns "zip", "tar" or "tar.gz" by file name, empty string for other files
+func archiveFormat(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	}
+	return ""
+}
+
+//mounted reports if virtual path is an archive path matching Patterns
+func (d *ArchiveDriver) mounted(virtualPath string) bool {
+	if len(archiveFormat(virtualPath)) == 0 {
+		return false
+	}
+	for _, pattern := range d.Patterns {
+		name := virtualPath
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(virtualPath)
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+//splitArchivePath finds mounted archive in virtual path. Returns archive path, path inside it ("/" for archive itself)
+//and archive info. ok is false if path is not in archive
+func (d *ArchiveDriver) splitArchivePath(virtualPath string) (string, string, os.FileInfo, bool) {
+	parts := strings.Split(strings.Trim(path.Clean(fmt.Sprint("/", virtualPath)), "/"), "/")
+	for i := 1; i <= len(parts); i++ {
+		archivePath := fmt.Sprint("/", strings.Join(parts[:i], "/"))
+		if !d.mounted(archivePath) {
+			continue
+		}
+		fi, err := d.Driver.Stat(archivePath)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		return archivePath, fmt.Sprint("/", strings.Join(parts[i:], "/")), fi, true
+	}
+	return "", "", nil, false
+}
+
+//entry returns member of archive for path inside archive
+func (d *ArchiveDriver) entry(op string, virtualPath string) (*archiveEntry, bool, error) {
+	archivePath, innerPath, fi, ok := d.splitArchivePath(virtualPath)
+	if !ok {
+		return nil, false, nil
+	}
+	index, err := d.index(archivePath, fi)
+	if err != nil {
+		return nil, true, err
+	}
+	entry := index.root
+	for _, name := range strings.Split(strings.Trim(innerPath, "/"), "/") {
+		if len(name) == 0 {
+			continue
+		}
+		child, ok := entry.children[name]
+		if !ok {
+			return nil, true, &os.PathError{Op: op, Path: virtualPath, Err: os.ErrNotExist}
+		}
+		entry = child
+	}
+	return entry, true, nil
+}
+
+func (d *ArchiveDriver) Stat(path string) (os.FileInfo, error) {
+	entry, inArchive, err := d.entry("stat", path)
+	if !inArchive {
+		return d.Driver.Stat(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info := entry.info
+	return &info, nil
+}
+func (d *ArchiveDriver) Lstat(path string) (os.FileInfo, error) {
+	if _, _, _, ok := d.splitArchivePath(path); !ok {
+		return d.Driver.Lstat(path)
+	}
+	return d.Stat(path)
+}
+
+//ReadDir returns directory content, mounted archives in it are shown as directories
+func (d *ArchiveDriver) ReadDir(dirPath string) ([]os.FileInfo, error) {
+	entry, inArchive, err := d.entry("readdir", dirPath)
+	if !inArchive {
+		content, err := d.Driver.ReadDir(dirPath)
+		if err != nil {
+			return nil, err
+		}
+		for i, fi := range content {
+			if fi.Mode().IsRegular() && d.mounted(path.Join(dirPath, fi.Name())) {
+				content[i] = &driverFileInfo{name: fi.Name(), mode: archiveDirMode, modTime: fi.ModTime()}
+			}
+		}
+		return content, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !entry.info.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: dirPath, Err: errors.New("not a directory")}
+	}
+	content := make([]os.FileInfo, 0, len(entry.children))
+	for _, child := range entry.children {
+		info := child.info
+		content = append(content, &info)
+	}
+	sort.Slice(content, func(i, j int) bool { return content[i].Name() < content[j].Name() })
+	return content, nil
+}
+func (d *ArchiveDriver) Readlink(path string) (string, error) {
+	if _, _, _, ok := d.splitArchivePath(path); ok {
+		return "", &os.PathError{Op: "readlink", Path: path, Err: errors.New("not a link")}
+	}
+	return d.Driver.Readlink(path)
+}
+
+//Open reads archive member from the beginning of archive (tar) or of member (zip), offset is skipped
+func (d *ArchiveDriver) Open(path string, offset int64) (io.ReadCloser, error) {
+	entry, inArchive, err := d.entry("open", path)
+	if !inArchive {
+		return d.Driver.Open(path, offset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if entry.info.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: path, Err: errors.New("is a directory")}
+	}
+	archivePath, innerPath, fi, _ := d.splitArchivePath(path)
+	reader, err := d.openMember(archivePath, innerPath, fi)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err := io.CopyN(ioutil.Discard, reader, offset); err != nil && err != io.EOF {
+			reader.Close()
+			return nil, err
+		}
+	}
+	return reader, nil
+}
+
+//readOnly returns error for changes inside of archives (and of archives themselves)
+func (d *ArchiveDriver) readOnly(op string, path string) error {
+	if _, _, _, ok := d.splitArchivePath(path); ok {
+		return &os.PathError{Op: op, Path: path, Err: os.ErrPermission}
+	}
+	return nil
+}
+func (d *ArchiveDriver) Create(path string, exclusive bool) (io.WriteCloser, error) {
+	if err := d.readOnly("create", path); err != nil {
+		return nil, err
+	}
+	return d.Driver.Create(path, exclusive)
+}
+func (d *ArchiveDriver) Append(path string) (io.WriteCloser, error) {
+	if err := d.readOnly("append", path); err != nil {
+		return nil, err
+	}
+	return d.Driver.Append(path)
+}
+func (d *ArchiveDriver) WriteAt(path string, offset int64) (io.WriteCloser, error) {
+	if err := d.readOnly("writeat", path); err != nil {
+		return nil, err
+	}
+	return d.Driver.WriteAt(path, offset)
+}
+func (d *ArchiveDriver) Mkdir(path string) error {
+	if err := d.readOnly("mkdir", path); err != nil {
+		return err
+	}
+	return d.Driver.Mkdir(path)
+}
+func (d *ArchiveDriver) Remove(path string) error {
+	if err := d.readOnly("remove", path); err != nil {
+		return err
+	}
+	return d.Driver.Remove(path)
+}
+func (d *ArchiveDriver) RemoveAll(path string) error {
+	if err := d.readOnly("removeall", path); err != nil {
+		return err
+	}
+	return d.Driver.RemoveAll(path)
+}
+func (d *ArchiveDriver) Rename(oldPath string, newPath string) error {
+	if err := d.readOnly("rename", oldPath); err != nil {
+		return err
+	}
+	if err := d.readOnly("rename", newPath); err != nil {
+		return err
+	}
+	return d.Driver.Rename(oldPath, newPath)
+}
+func (d *ArchiveDriver) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	if err := d.readOnly("chtimes", path); err != nil {
+		return err
+	}
+	return d.Driver.Chtimes(path, atime, mtime)
+}
+func (d *ArchiveDriver) CreateTimeSupported() bool {
+	setter, ok := d.Driver.(CreateTimeSetter)
+	return ok && setter.CreateTimeSupported()
+}
+func (d *ArchiveDriver) SetCreateTime(path string, createTime time.Time) error {
+	setter, ok := d.Driver.(CreateTimeSetter)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := d.readOnly("setcreatetime", path); err != nil {
+		return err
+	}
+	return setter.SetCreateTime(path, createTime)
+}
+
+//index returns members of archive, index is built once for each version of archive
+func (d *ArchiveDriver) index(archivePath string, fi os.FileInfo) (*archiveIndex, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if index, ok := d.indexes[archivePath]; ok && index.size == fi.Size() && index.modTime.Equal(fi.ModTime()) {
+		return index, nil
+	}
+	index := &archiveIndex{
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+		root:    &archiveEntry{info: driverFileInfo{name: path.Base(archivePath), mode: archiveDirMode, modTime: fi.ModTime()}, children: make(map[string]*archiveEntry)},
+	}
+	if archiveFormat(archivePath) == "zip" {
+		//only zip directory at the end of archive is read
+		readerAt := &archiveReaderAt{driver: d.Driver, path: archivePath}
+		reader, err := zip.NewReader(readerAt, fi.Size())
+		readerAt.Close()
+		if err != nil {
+			return nil, err
+		}
+		index.zipReader = readerAt
+		index.zipFiles = make(map[string]*zip.File)
+		for _, file := range reader.File {
+			index.add(file.Name, file.FileInfo())
+			name := path.Clean(fmt.Sprint("/", file.Name))
+			if _, ok := index.zipFiles[name]; !ok && !file.FileInfo().IsDir() {
+				index.zipFiles[name] = file
+			}
+		}
+	} else {
+		reader, closer, err := d.openTar(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		defer closer.Close()
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if header.Typeflag == tar.TypeDir || header.Typeflag == tar.TypeReg {
+				index.add(header.Name, header.FileInfo())
+			}
+		}
+	}
+	d.indexes[archivePath] = index
+	return index, nil
+}
+
+//add adds member to index with directories of its path. First of members with the same name is kept
+func (index *archiveIndex) add(name string, fi os.FileInfo) {
+	parts := strings.Split(strings.Trim(path.Clean(fmt.Sprint("/", name)), "/"), "/")
+	if len(parts) == 1 && len(parts[0]) == 0 {
+		return
+	}
+	entry := index.root
+	for i, part := range parts {
+		child, ok := entry.children[part]
+		last := i == len(parts)-1
+		if !ok {
+			child = &archiveEntry{info: driverFileInfo{name: part, mode: archiveDirMode, modTime: index.modTime}, children: make(map[string]*archiveEntry)}
+			if last && !fi.IsDir() {
+				child.info = driverFileInfo{name: part, size: fi.Size(), mode: archiveFileMode, modTime: fi.ModTime()}
+				child.children = nil
+			}
+			entry.children[part] = child
+		} else if last && fi.IsDir() && child.info.IsDir() {
+			child.info.modTime = fi.ModTime()
+		}
+		if !child.info.IsDir() {
+			return
+		}
+		entry = child
+	}
+}
+
+//archiveReaderAt reads archive of driver at any position with Open of driver, so archives of drivers
+//without io.ReaderAt files (object storage) are not read whole. Stream is kept while reads are sequential
+type archiveReaderAt struct {
+	driver Driver
+	path   string
+	lock   sync.Mutex
+	stream io.ReadCloser
+	pos    int64
+}
+
+func (r *archiveReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stream == nil || r.pos != off {
+		r.closeStream()
+		stream, err := r.driver.Open(r.path, off)
+		if err != nil {
+			return 0, err
+		}
+		r.stream, r.pos = stream, off
+	}
+	n, err := io.ReadFull(r.stream, p)
+	r.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err != nil {
+		r.closeStream()
+	}
+	return n, err
+}
+
+//Close closes stream, next ReadAt opens new one
+func (r *archiveReaderAt) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closeStream()
+	return nil
+}
+func (r *archiveReaderAt) closeStream() {
+	if r.stream != nil {
+		r.stream.Close()
+		r.stream = nil
+	}
+}
+
+//zipChecksumReader checks size and CRC-32 of zip member at its end
+type zipChecksumReader struct {
+	reader io.Reader
+	file   *zip.File
+	hash   hash.Hash32
+	read   uint64
+}
+
+func (r *zipChecksumReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.hash.Write(p[:n])
+	r.read += uint64(n)
+	if err == io.EOF {
+		if r.read != r.file.UncompressedSize64 {
+			return n, io.ErrUnexpectedEOF
+		}
+		if r.file.CRC32 != 0 && r.hash.Sum32() != r.file.CRC32 {
+			return n, zip.ErrChecksum
+		}
+	}
+	return n, err
+}
+
+//openZipMember streams data of zip member from its offset in archive
+func (d *ArchiveDriver) openZipMember(archivePath string, index *archiveIndex, file *zip.File) (io.ReadCloser, error) {
+	offset, err := file.DataOffset()
+	index.zipReader.Close()
+	if err != nil {
+		return nil, err
+	}
+	stream, err := d.Driver.Open(archivePath, offset)
+	if err != nil {
+		return nil, err
+	}
+	data := io.LimitReader(stream, int64(file.CompressedSize64))
+	member := &archiveMemberReader{closers: []io.Closer{stream}}
+	switch file.Method {
+	case zip.Store:
+	case zip.Deflate:
+		inflater := flate.NewReader(data)
+		data = inflater
+		member.closers = append([]io.Closer{inflater}, member.closers...)
+	default:
+		stream.Close()
+		return nil, zip.ErrAlgorithm
+	}
+	member.Reader = &zipChecksumReader{reader: data, file: file, hash: crc32.NewIEEE()}
+	return member, nil
+}
+
+//openTar returns tar reader of archive, .tar.gz and .tgz are decompressed
+func (d *ArchiveDriver) openTar(archivePath string) (*tar.Reader, io.Closer, error) {
+	file, err := d.Driver.Open(archivePath, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if archiveFormat(archivePath) != "tar.gz" {
+		return tar.NewReader(file), file, nil
+	}
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return tar.NewReader(gzipReader), &archiveMemberReader{closers: []io.Closer{gzipReader, file}}, nil
+}
+
+//openMember returns reader of archive member
+func (d *ArchiveDriver) openMember(archivePath string, innerPath string, fi os.FileInfo) (io.ReadCloser, error) {
+	if archiveFormat(archivePath) == "zip" {
+		index, err := d.index(archivePath, fi)
+		if err != nil {
+			return nil, err
+		}
+		file, ok := index.zipFiles[innerPath]
+		if !ok {
+			return nil, &os.PathError{Op: "open", Path: fmt.Sprint(archivePath, innerPath), Err: os.ErrNotExist}
+		}
+		return d.openZipMember(archivePath, index, file)
+	}
+	reader, closer, err := d.openTar(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			closer.Close()
+			if err == io.EOF {
+				return nil, &os.PathError{Op: "open", Path: fmt.Sprint(archivePath, innerPath), Err: os.ErrNotExist}
+			}
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg && path.Clean(fmt.Sprint("/", header.Name)) == innerPath {
+			return &archiveMemberReader{Reader: reader, closers: []io.Closer{closer}}, nil
+		}
+	}
+}
+
+//archiveMemberReader reads member and closes archive after it
+type archiveMemberReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *archiveMemberReader) Close() error {
+	var firstErr error
+	for _, closer := range r.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
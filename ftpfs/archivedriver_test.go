@@ -0,0 +1,220 @@
+package ftpfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//countingDriver counts bytes read from files of driver, files don't implement io.ReaderAt as files of object storage
+type countingDriver struct {
+	Driver
+	read  int64
+	opens int64
+}
+type countingReader struct {
+	io.ReadCloser
+	driver *countingDriver
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(&r.driver.read, int64(n))
+	return n, err
+}
+func (d *countingDriver) Open(path string, offset int64) (io.ReadCloser, error) {
+	file, err := d.Driver.Open(path, offset)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&d.opens, 1)
+	return &countingReader{ReadCloser: file, driver: d}, nil
+}
+
+//archiveMembers are files put to test archives
+var archiveMembers = []struct {
+	name string
+	data string
+}{
+	{"readme.txt", "read me"},
+	{"docs/guide.txt", "guide of archive driver"},
+	{"docs/empty.txt", ""},
+}
+
+func makeZip(t *testing.T, large []byte) []byte {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for i, member := range archiveMembers {
+		method := zip.Deflate
+		if i%2 == 1 {
+			method = zip.Store
+		}
+		file, err := writer.CreateHeader(&zip.FileHeader{Name: member.name, Method: method, Modified: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write([]byte(member.data))
+	}
+	if large != nil {
+		file, err := writer.CreateHeader(&zip.FileHeader{Name: "large.bin", Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write(large)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeTar(t *testing.T, compressed bool) []byte {
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	var gz *gzip.Writer
+	if compressed {
+		gz = gzip.NewWriter(&buf)
+		out = gz
+	}
+	writer := tar.NewWriter(out)
+	writer.WriteHeader(&tar.Header{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Now()})
+	for _, member := range archiveMembers {
+		header := &tar.Header{Name: member.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(member.data)), ModTime: time.Now()}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		writer.Write([]byte(member.data))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		gz.Close()
+	}
+	return buf.Bytes()
+}
+
+func newTestArchiveDriver(t *testing.T, archives map[string][]byte) (*ArchiveDriver, *countingDriver) {
+	memory, err := NewMemoryDriver(NewMemoryStorage(0, 0), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range archives {
+		file, err := memory.Create(name, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write(data)
+		file.Close()
+	}
+	counting := &countingDriver{Driver: memory}
+	return NewArchiveDriver(counting, []string{"*.zip", "*.tar", "*.tgz"}), counting
+}
+
+func readMember(d Driver, path string, offset int64) (string, error) {
+	file, err := d.Open(path, offset)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	return string(data), err
+}
+
+func TestArchiveDriver(t *testing.T) {
+	d, _ := newTestArchiveDriver(t, map[string][]byte{
+		"/a.zip": makeZip(t, nil),
+		"/a.tar": makeTar(t, false),
+		"/a.tgz": makeTar(t, true),
+	})
+	for _, archive := range []string{"/a.zip", "/a.tar", "/a.tgz"} {
+		fi, err := d.Stat(archive)
+		if err != nil || !fi.IsDir() {
+			t.Errorf("%s: archive is not shown as directory (%v)", archive, err)
+			continue
+		}
+		list, err := d.ReadDir(archive + "/docs")
+		if err != nil || len(list) != 2 || list[0].Name() != "empty.txt" || list[1].Name() != "guide.txt" {
+			t.Errorf("%s: ReadDir of docs returned %d entries, %v", archive, len(list), err)
+		}
+		for _, member := range archiveMembers {
+			fi, err := d.Stat(archive + "/" + member.name)
+			if err != nil || fi.Size() != int64(len(member.data)) {
+				t.Errorf("%s/%s: Stat %v, %v", archive, member.name, fi, err)
+			}
+			if data, err := readMember(d, archive+"/"+member.name, 0); err != nil || data != member.data {
+				t.Errorf("%s/%s: read %q, %v", archive, member.name, data, err)
+			}
+		}
+		if data, err := readMember(d, archive+"/docs/guide.txt", 6); err != nil || data != "of archive driver" {
+			t.Errorf("%s: read from offset %q, %v", archive, data, err)
+		}
+		if _, err := d.Open(archive+"/missing.txt", 0); !os.IsNotExist(err) {
+			t.Errorf("%s: open of missing member returned %v", archive, err)
+		}
+		if _, err := d.Open(archive+"/docs", 0); err == nil {
+			t.Errorf("%s: directory of archive opened", archive)
+		}
+		if _, err := d.Create(archive+"/new.txt", false); !os.IsPermission(err) {
+			t.Errorf("%s: create in archive returned %v", archive, err)
+		}
+		if err := d.Remove(archive + "/readme.txt"); !os.IsPermission(err) {
+			t.Errorf("%s: remove in archive returned %v", archive, err)
+		}
+	}
+}
+
+//TestArchiveZipRangedReads checks that member of zip is read without reading whole archive
+func TestArchiveZipRangedReads(t *testing.T) {
+	large := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(large)
+	archive := makeZip(t, large)
+	d, counting := newTestArchiveDriver(t, map[string][]byte{"/big.zip": archive})
+	if _, err := d.ReadDir("/big.zip"); err != nil {
+		t.Fatal(err)
+	}
+	if read := atomic.LoadInt64(&counting.read); read > 64<<10 {
+		t.Errorf("index of zip read %d bytes of %d", read, len(archive))
+	}
+	atomic.StoreInt64(&counting.read, 0)
+	if data, err := readMember(d, "/big.zip/readme.txt", 0); err != nil || data != "read me" {
+		t.Errorf("read %q, %v", data, err)
+	}
+	if read := atomic.LoadInt64(&counting.read); read > 64<<10 {
+		t.Errorf("small member read %d bytes of %d", read, len(archive))
+	}
+	data, err := readMember(d, "/big.zip/large.bin", 0)
+	if err != nil || !bytes.Equal([]byte(data), large) {
+		t.Errorf("large member read %d bytes, %v", len(data), err)
+	}
+	//index is kept while archive doesn't change
+	opens := atomic.LoadInt64(&counting.opens)
+	if _, err := d.Stat("/big.zip/readme.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt64(&counting.opens) != opens {
+		t.Errorf("index of unchanged zip built again")
+	}
+}
+
+func TestArchiveZipChecksum(t *testing.T) {
+	archive := makeZip(t, nil)
+	//member data of stored "docs/guide.txt" is changed, its CRC-32 is not
+	i := bytes.Index(archive, []byte(archiveMembers[1].data))
+	if i < 0 {
+		t.Fatal("stored member is not found in archive")
+	}
+	archive[i] ^= 0xff
+	d, _ := newTestArchiveDriver(t, map[string][]byte{"/bad.zip": archive})
+	if _, err := readMember(d, "/bad.zip/docs/guide.txt", 0); err != zip.ErrChecksum {
+		t.Errorf("read of damaged member returned %v, want %v", err, zip.ErrChecksum)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package ftpfs
+
+import "time"
+
+//creation time can't be changed with POSIX calls
+const createTimeSupported = false
+
+func setCreateTime(path string, createTime time.Time) error {
+	return ErrNotSupported
+}
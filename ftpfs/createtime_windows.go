@@ -0,0 +1,23 @@
+package ftpfs
+
+import (
+	"syscall"
+	"time"
+)
+
+const createTimeSupported = true
+
+func setCreateTime(path string, createTime time.Time) error {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	//FILE_FLAG_BACKUP_SEMANTICS is required to open directories
+	handle, err := syscall.CreateFile(pathPtr, syscall.FILE_WRITE_ATTRIBUTES, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(handle)
+	ctime := syscall.NsecToFiletime(createTime.UnixNano())
+	return syscall.SetFileTime(handle, &ctime, nil, nil)
+}
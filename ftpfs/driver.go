@@ -0,0 +1,149 @@
+// Storage driver interface behind FileSystem
+package ftpfs
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/FTPServConfig"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Driver is a storage served by FileSystem. All paths given to driver are virtual: absolute,
+//cleaned with "/" separators, "/" is user root folder. Errors for missing objects must satisfy os.IsNotExist,
+//errors for existing objects (Create with exclusive flag) - os.IsExist
+type Driver interface {
+	//Stat returns info of object, links are followed
+	Stat(path string) (os.FileInfo, error)
+	//Lstat returns info of object, links are not followed
+	Lstat(path string) (os.FileInfo, error)
+	//ReadDir returns directory content (as Lstat does) sorted by name
+	ReadDir(path string) ([]os.FileInfo, error)
+	//Readlink returns target of link
+	Readlink(path string) (string, error)
+	//Open opens file for reading starting at offset
+	Open(path string, offset int64) (io.ReadCloser, error)
+	//Create creates file for writing, existing file is truncated or, if exclusive is set, not opened
+	Create(path string, exclusive bool) (io.WriteCloser, error)
+	//Append opens file for writing at its end, file is created if not exists
+	Append(path string) (io.WriteCloser, error)
+	//WriteAt truncates existing file to offset and opens it for writing from there (REST + STOR)
+	WriteAt(path string, offset int64) (io.WriteCloser, error)
+	Mkdir(path string) error
+	//Remove removes file, link or empty directory
+	Remove(path string) error
+	//RemoveAll removes path with its content
+	RemoveAll(path string) error
+	Rename(oldPath string, newPath string) error
+	Chtimes(path string, atime time.Time, mtime time.Time) error
+}
+
+//CreateTimeSetter is implemented by drivers able to set creation time of objects (MFCT)
+type CreateTimeSetter interface {
+	CreateTimeSupported() bool
+	SetCreateTime(path string, createTime time.Time) error
+}
+
+//driverFileInfo is os.FileInfo for drivers without own one (objects in memory or object storage)
+type driverFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *driverFileInfo) Name() string       { return fi.name }
+func (fi *driverFileInfo) Size() int64        { return fi.size }
+func (fi *driverFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *driverFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *driverFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *driverFileInfo) Sys() interface{}   { return nil }
+
+//DriverFactory makes driver for user files, root of driver is user folder
+type DriverFactory func(config *FTPServConfig.ConfigStorage, user *FTPAuth.User) (Driver, error)
+
+//DefaultStorage is used if Storage is not set in config
+const DefaultStorage = "local"
+
+var driverFactories = make(map[string]DriverFactory)
+var driverFactoriesLock sync.RWMutex
+
+func init() {
+	RegisterDriver(DefaultStorage, newLocalDriverForUser)
+	RegisterDriver("memory", newMemoryDriverForUser)
+	RegisterDriver("s3", newS3DriverForUser)
+}
+
+//RegisterDriver adds (or replaces) storage which may be selected with Storage field of config
+func RegisterDriver(name string, factory DriverFactory) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if len(name) == 0 || factory == nil {
+		return errors.New(fmt.Sprint("RegisterDriver: wrong driver \"", name, "\""))
+	}
+	driverFactoriesLock.Lock()
+	driverFactories[name] = factory
+	driverFactoriesLock.Unlock()
+	return nil
+}
+
+//DriverRegistered reports if storage name may be used in config
+func DriverRegistered(name string) bool {
+	driverFactoriesLock.RLock()
+	defer driverFactoriesLock.RUnlock()
+	_, ok := driverFactories[strings.ToLower(strings.TrimSpace(name))]
+	return ok
+}
+
+//NewDriver makes driver of storage selected in config for user with user mounts,
+//archives of ArchiveMounts are shown as directories, versions are kept if Versioning is set
+func NewDriver(config *FTPServConfig.ConfigStorage, user *FTPAuth.User) (Driver, error) {
+	driver, _, err := newUserDriver(config, user)
+	return driver, err
+}
+
+//newUserDriver makes driver for user and returns quota of user folder (nil if user has no limits).
+//Quota counts user folder only, mounts are not counted
+func newUserDriver(config *FTPServConfig.ConfigStorage, user *FTPAuth.User) (Driver, *Quota, error) {
+	name := strings.ToLower(strings.TrimSpace(config.Storage))
+	if len(name) == 0 {
+		name = DefaultStorage
+	}
+	driver, err := newStorageDriver(name, config, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	driver, quota := quotaDriverIfNeeded(config, user, driver)
+	if len(user.Mounts) > 0 {
+		if driver, err = newMountDriverForUser(config, user, driver); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(config.ArchiveMounts) > 0 {
+		driver = NewArchiveDriver(driver, config.ArchiveMounts)
+	}
+	if config.Versioning {
+		driver = NewVersionDriver(driver, config.KeepVersions, time.Duration(config.VersionsMaxAge)*24*time.Hour)
+	}
+	return driver, quota, nil
+}
+
+//newStorageDriver makes driver of registered storage for user folder
+func newStorageDriver(name string, config *FTPServConfig.ConfigStorage, user *FTPAuth.User) (Driver, error) {
+	driverFactoriesLock.RLock()
+	factory, ok := driverFactories[name]
+	driverFactoriesLock.RUnlock()
+	if !ok {
+		return nil, errors.New(fmt.Sprint("Unknown storage \"", name, "\""))
+	}
+	return factory(config, user)
+}
+
+//userFolder returns cleaned user folder ("/" for root folder)
+func userFolder(user *FTPAuth.User) string {
+	return path.Clean(fmt.Sprint("/", user.Folder))
+}
@@ -0,0 +1,16 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package ftpfs
+
+import "os"
+
+//fileUniqueID returns id of file. No inodes here: path is used
+func fileUniqueID(fi os.FileInfo, path string) string {
+	return pathUniqueID(path)
+}
+
+//fileOwnership returns owner and group names and links count of file
+func fileOwnership(fi os.FileInfo) (string, string, uint64) {
+	return defaultOwnerName, defaultOwnerName, 1
+}
@@ -0,0 +1,27 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package ftpfs
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+//fileUniqueID returns id of file which is the same for all its names (device and inode)
+func fileUniqueID(fi os.FileInfo, path string) string {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%xg%x", uint64(st.Dev), uint64(st.Ino))
+	}
+	return pathUniqueID(path)
+}
+
+//fileOwnership returns owner and group names and links count of file
+func fileOwnership(fi os.FileInfo) (string, string, uint64) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return defaultOwnerName, defaultOwnerName, 1
+	}
+	return lookupUserName(fmt.Sprint(st.Uid)), lookupGroupName(fmt.Sprint(st.Gid)), uint64(st.Nlink)
+}
@@ -6,16 +6,43 @@ import (
 	"FTPServ/FTPServConfig"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
-	"runtime"
+	"path"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 type FileSystem struct {
 	FTPRootFolder       string
 	FTPWorkingDirectory string
 	FSUser              *FTPAuth.User
+	//Driver is storage of user files, set by InitFileSystem
+	Driver Driver
+	//AtomicUploads - STOR writes new files to temporary files moved to their place when upload is complete
+	AtomicUploads bool
+	//OverwritePolicy - what STOR does with existing file: OverwritePolicyReject (default),
+	//OverwritePolicyOverwrite or OverwritePolicyRename
+	OverwritePolicy string
+	//quota of user folder, nil if user has no limits
+	quota *Quota
+}
+
+//Overwrite policies of STOR
+const (
+	OverwritePolicyReject    = "reject"
+	OverwritePolicyOverwrite = "overwrite"
+	OverwritePolicyRename    = "rename"
+)
+
+//ValidOverwritePolicy reports if policy may be used in config or user settings (empty - default policy)
+func ValidOverwritePolicy(policy string) bool {
+	switch strings.ToLower(strings.TrimSpace(policy)) {
+	case "", OverwritePolicyReject, OverwritePolicyOverwrite, OverwritePolicyRename:
+		return true
+	}
+	return false
 }
 type RenameableObj struct {
 	OldName string
@@ -26,12 +53,12 @@ func (fsParams *FileSystem) NewRenameableObj(path string) (*RenameableObj, error
 	if len(path) == 0 {
 		return nil, errors.New("No dir name specified")
 	}
-	fullpath := fmt.Sprint(fsParams.FTPRootFolder, fsParams.checkForSlash(path))
-	_, err := os.Stat(fullpath)
+	oldName := fsParams.VirtualPath(path)
+	_, err := fsParams.Driver.Lstat(oldName)
 	if err != nil {
 		return nil, err
 	}
-	return &RenameableObj{OldName: fullpath, NewName: ""}, nil
+	return &RenameableObj{OldName: oldName, NewName: ""}, nil
 }
 func (fsParams *FileSystem) Rename(RenameProps *RenameableObj) error {
 	if len(RenameProps.OldName) == 0 {
@@ -40,38 +67,128 @@ func (fsParams *FileSystem) Rename(RenameProps *RenameableObj) error {
 	if len(RenameProps.NewName) == 0 {
 		return errors.New("No new name specified")
 	}
-	newPath := fmt.Sprint(fsParams.FTPRootFolder, fsParams.checkForSlash(RenameProps.NewName))
-	err := os.Rename(RenameProps.OldName, newPath)
-	return err
+	return fsParams.Driver.Rename(RenameProps.OldName, fsParams.VirtualPath(RenameProps.NewName))
 }
-func (fsParams *FileSystem) STOR(path string) (*os.File, error) {
+//STOR opens file for upload. Existing file is handled by OverwritePolicy, new files are written
+//to temporary files if AtomicUploads is set. Returns opened file and its path (changed by "rename" policy).
+//offset > 0 - resume (REST): existing file is truncated to offset and written from there,
+//appendMode - APPE: data is appended to existing file or new file is created
+func (fsParams *FileSystem) STOR(path string, offset int64, appendMode bool) (io.WriteCloser, string, error) {
 	if len(path) == 0 {
-		return nil, errors.New("No fileName specified")
+		return nil, "", errors.New("No fileName specified")
+	}
+	filePath := fsParams.VirtualPath(path)
+	if appendMode {
+		file, err := fsParams.Driver.Append(filePath)
+		return file, filePath, err
 	}
-	filePath := fmt.Sprint(fsParams.FTPRootFolder, fsParams.checkForSlash(path))
 	//check if file exist
-	_, err := os.Stat(filePath)
+	fi, err := fsParams.Driver.Stat(filePath)
+	if offset > 0 {
+		if err != nil {
+			return nil, "", err
+		}
+		if fi.IsDir() {
+			return nil, "", errors.New("STOR File is dir")
+		}
+		if fi.Size() < offset {
+			return nil, "", errors.New(fmt.Sprint("Restart offset ", offset, " is beyond file size ", fi.Size()))
+		}
+		file, err := fsParams.Driver.WriteAt(filePath, offset)
+		return file, filePath, err
+	}
 	if err == nil {
-		return nil, errors.New("File exist in specified path")
+		if fi.IsDir() {
+			return nil, "", errors.New("STOR File is dir")
+		}
+		switch fsParams.OverwritePolicy {
+		case OverwritePolicyOverwrite:
+			if fsParams.AtomicUploads {
+				file, err := newAtomicUpload(fsParams.Driver, filePath)
+				return file, filePath, err
+			}
+			file, err := fsParams.Driver.Create(filePath, false)
+			return file, filePath, err
+		case OverwritePolicyRename:
+			return fsParams.createUnique(filePath)
+		default:
+			return nil, "", errors.New("File exist in specified path")
+		}
 	}
-	file, err := os.Create(filePath)
-	if err != nil {
-		return nil, err
+	if fsParams.AtomicUploads {
+		file, err := newAtomicUpload(fsParams.Driver, filePath)
+		return file, filePath, err
 	}
-	file.Close()
-	return file, nil
+	file, err := fsParams.Driver.Create(filePath, true)
+	return file, filePath, err
 }
-func (fsParams *FileSystem) InitFileSystem(config *FTPServConfig.ConfigStorage, user *FTPAuth.User) {
-	fsParams.FSUser = user
-	fsParams.FTPRootFolder = config.FTPRootFolder
-	if user.Folder != "/" {
-		fsParams.FTPRootFolder = fmt.Sprint(fsParams.FTPRootFolder, user.Folder)
+//STOU creates new file with unique name for upload. Name is made from path ("name.1.ext", "name.2.ext"...),
+//"ftp.upload" is used if path is empty. Returns created file and its path
+func (fsParams *FileSystem) STOU(path string) (io.WriteCloser, string, error) {
+	if len(path) == 0 {
+		path = "ftp.upload"
 	}
-	lastCharIndex := len(fsParams.FTPRootFolder)
-	lastChar := fsParams.FTPRootFolder[lastCharIndex-1]
-	if lastChar == '/' {
-		fsParams.FTPRootFolder = fsParams.FTPRootFolder[:len(fsParams.FTPRootFolder)-1]
+	return fsParams.createUnique(fsParams.VirtualPath(path))
+}
+
+//createUnique creates file with first free name made from filePath by uniqueName.
+//Name of atomic upload is checked when upload starts, file created at it meanwhile is replaced
+func (fsParams *FileSystem) createUnique(filePath string) (io.WriteCloser, string, error) {
+	for i := 0; i < maxUniqueNameAttempts; i++ {
+		candidate := uniqueName(filePath, i)
+		if fsParams.AtomicUploads {
+			if _, err := fsParams.Driver.Lstat(candidate); err == nil {
+				continue
+			} else if !os.IsNotExist(err) {
+				return nil, "", err
+			}
+			file, err := newAtomicUpload(fsParams.Driver, candidate)
+			return file, candidate, err
+		}
+		file, err := fsParams.Driver.Create(candidate, true)
+		if err == nil {
+			return file, candidate, nil
+		}
+		if !os.IsExist(err) {
+			return nil, "", err
+		}
+	}
+	return nil, "", errors.New("Couldn't find unique file name")
+}
+
+//maxUniqueNameAttempts limits number of names tried by STOU
+const maxUniqueNameAttempts = 10000
+
+//uniqueName returns name with number before extension: "dir/name.ext" -> "dir/name.N.ext" (N > 0)
+func uniqueName(name string, number int) string {
+	if number == 0 {
+		return name
+	}
+	dir, base := path.Split(name)
+	ext := path.Ext(base)
+	if ext == base {
+		//".hidden" file has no extension
+		ext = ""
+	}
+	return fmt.Sprint(dir, strings.TrimSuffix(base, ext), ".", number, ext)
+}
+//InitFileSystem makes driver of storage selected in config for user
+func (fsParams *FileSystem) InitFileSystem(config *FTPServConfig.ConfigStorage, user *FTPAuth.User) error {
+	driver, quota, err := newUserDriver(config, user)
+	if err != nil {
+		return err
 	}
+	fsParams.FSUser = user
+	fsParams.FTPRootFolder = filepath.Join(config.FTPRootFolder, filepath.FromSlash(userFolder(user)))
+	fsParams.FTPWorkingDirectory = "/"
+	fsParams.Driver = driver
+	fsParams.quota = quota
+	fsParams.AtomicUploads = config.AtomicUploads
+	fsParams.OverwritePolicy = strings.ToLower(strings.TrimSpace(config.OverwritePolicy))
+	if len(strings.TrimSpace(user.OverwritePolicy)) > 0 {
+		fsParams.OverwritePolicy = strings.ToLower(strings.TrimSpace(user.OverwritePolicy))
+	}
+	return nil
 }
 
 func (fsParams *FileSystem) checkForSlash(checking string) string {
@@ -84,86 +201,97 @@ func (fsParams *FileSystem) checkForSlash(checking string) string {
 	}
 	return checking
 }
-func (fsParams *FileSystem) removeFirstSlash(checking string) string {
-	if len(checking) == 0 {
-		return checking
-	}
-	firstsym := checking[0]
-	if firstsym == '/' {
-		return checking[1:]
-	}
-	return checking
-}
 func (fsParams *FileSystem) MakeDir(dirName string) error {
 	if len(dirName) == 0 {
 		return errors.New("No dir name specified")
 	}
-	dirPath := fsParams.FTPRootFolder + fsParams.checkForSlash(dirName)
-	err := os.Mkdir(dirPath, os.ModePerm.Perm())
-	return err
+	return fsParams.Driver.Mkdir(fsParams.VirtualPath(dirName))
 }
-func (fsParams *FileSystem) getFullDirectoryPath() string {
-	return fmt.Sprint(fsParams.FTPRootFolder, fsParams.checkForSlash(fsParams.FTPWorkingDirectory))
-}
-func (fsParams *FileSystem) LIST(directory string) ([]string, error) {
-	if len(directory) == 0 {
-		//using working directory
-		directory = fsParams.FTPWorkingDirectory
+//DELE removes file. Directories are not removed
+func (fsParams *FileSystem) DELE(fileName string) error {
+	if len(fileName) == 0 {
+		return errors.New("No file name specified")
 	}
-	directory = fmt.Sprint(fsParams.FTPRootFolder, fsParams.checkForSlash(directory))
-	err := checkIfDir(directory)
+	filePath := fsParams.VirtualPath(fileName)
+	fi, err := fsParams.Driver.Lstat(filePath)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if fi.IsDir() {
+		return errors.New("Is a folder")
 	}
-	lsOutput, err := executeShellCommands("ls", "-l", directory)
+	return fsParams.Driver.Remove(filePath)
+}
+
+//RMD removes empty directory
+func (fsParams *FileSystem) RMD(dirName string) error {
+	dirPath, err := fsParams.removableDirPath(dirName)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	lsArray := strings.Split(lsOutput, "\n")
-	var outputString string
-	for _, line := range lsArray {
-		if len(line) >= 4 {
-			firstFive := line[:5]
-			if strings.ToLower(firstFive) == "total" {
-				continue
-			}
-			add := line[10]
-			if add == '@' || add == '+' {
-				lineRune := []rune(line)
-				lineRune[10] = rune(' ')
-				line = string(lineRune)
-			}
-		} else {
-			continue
-		}
-		outputString = fmt.Sprint(outputString, "\r\n", line)
+	return fsParams.Driver.Remove(dirPath)
+}
+
+//RemoveDirRecursive removes directory with all its content
+func (fsParams *FileSystem) RemoveDirRecursive(dirName string) error {
+	dirPath, err := fsParams.removableDirPath(dirName)
+	if err != nil {
+		return err
 	}
-	outputArray := strings.Split(outputString, "\r\n")
-	return outputArray, nil
+	return fsParams.Driver.RemoveAll(dirPath)
 }
-func (fsParams *FileSystem) STAT(directory string) (string, error) {
-	if directory == "" {
-		directory = fsParams.FTPWorkingDirectory
+
+//removableDirPath returns virtual path of directory which may be removed (not user root folder)
+func (fsParams *FileSystem) removableDirPath(dirName string) (string, error) {
+	if len(dirName) == 0 {
+		return "", errors.New("No dir name specified")
+	}
+	dirPath := fsParams.VirtualPath(dirName)
+	if dirPath == "/" {
+		return "", errors.New("Root folder can't be removed")
 	}
-	directory = fmt.Sprint(fsParams.FTPRootFolder, fsParams.checkForSlash(fsParams.FTPWorkingDirectory))
-	err := checkIfDir(directory)
+	fi, err := fsParams.Driver.Lstat(dirPath)
 	if err != nil {
 		return "", err
 	}
-	statOutput, err := executeShellCommands("stat", "-ltr", directory)
+	if fi.IsDir() == false {
+		return "", errors.New("Not a dir")
+	}
+	return dirPath, nil
+}
+//LIST returns "ls -l" listing of directory (working directory if empty) or single line for file
+func (fsParams *FileSystem) LIST(directory string, options ListOptions) ([]string, error) {
+	directory = fsParams.VirtualPath(directory)
+	now := time.Now()
+	fi, err := fsParams.Driver.Lstat(directory)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		if target, err := fsParams.Driver.Stat(directory); err == nil && target.IsDir() {
+			fi = target
+		}
+	}
+	if fi.IsDir() == false {
+		linkTarget := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			linkTarget, _ = fsParams.Driver.Readlink(directory)
+		}
+		return []string{formatListEntry(fi, fi.Name(), linkTarget, options, now)}, nil
 	}
-	add := statOutput[10]
-	if add == '@' || add == '+' {
-		lineRune := []rune(statOutput)
-		lineRune[10] = rune(' ')
-		statOutput = string(lineRune)
+	if options.Recursive {
+		return listRecursive(fsParams.Driver, directory, options, now, nil)
 	}
-	return statOutput, nil
+	lines, _, err := listDirectory(fsParams.Driver, directory, options, now)
+	return lines, err
+}
+
+//STAT returns listing of path (working directory if empty) for STAT reply
+func (fsParams *FileSystem) STAT(directory string) ([]string, error) {
+	return fsParams.LIST(directory, ListOptions{All: true, Long: true})
 }
-func checkIfDir(dirName string) error {
-	dirStat, err := os.Stat(dirName)
+func (fsParams *FileSystem) checkIfDir(dirName string) error {
+	dirStat, err := fsParams.Driver.Stat(dirName)
 	if err != nil {
 		return err
 	}
@@ -173,35 +301,46 @@ func checkIfDir(dirName string) error {
 	return nil
 }
 func (fsParams *FileSystem) CWD(directory string) error {
-	directory = fsParams.checkForSlash(directory)
-	directoryForCheck := fmt.Sprint(fsParams.FTPRootFolder, directory)
-	err := checkIfDir(directoryForCheck)
+	directory = fsParams.VirtualPath(directory)
+	err := fsParams.checkIfDir(directory)
 	if err != nil {
 		return err
 	}
 	fsParams.FTPWorkingDirectory = directory
 	return nil
 }
-func (fsParams *FileSystem) RETR(fileName string) (*os.File, error) {
-	workingPath := fsParams.checkForSlash(fsParams.FTPRootFolder)
-	fullFileName := fmt.Sprint(workingPath, "/", fsParams.removeFirstSlash(fileName))
-	fi, err := os.Stat(fullFileName)
+
+//WorkingDirectory returns working directory of user, relative to user root folder
+func (fsParams *FileSystem) WorkingDirectory() string {
+	return fsParams.checkForSlash(fsParams.FTPWorkingDirectory)
+}
+
+//CDUP changes working directory to its parent
+func (fsParams *FileSystem) CDUP() error {
+	return fsParams.CWD("..")
+}
+
+//RETR opens file for download starting at offset (REST). Returns reader and number of bytes to send
+func (fsParams *FileSystem) RETR(fileName string, offset int64) (io.ReadCloser, int64, error) {
+	filePath := fsParams.VirtualPath(fileName)
+	fi, err := fsParams.Driver.Stat(filePath)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if fi.IsDir() {
-		return nil, errors.New("RETR File is dir")
+		return nil, 0, errors.New("RETR File is dir")
+	}
+	if offset > fi.Size() {
+		return nil, 0, errors.New(fmt.Sprint("Restart offset ", offset, " is beyond file size ", fi.Size()))
 	}
-	file, err := os.Open(fullFileName)
+	file, err := fsParams.Driver.Open(filePath, offset)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	return file, nil
+	return file, fi.Size() - offset, nil
 }
 func (fsParams *FileSystem) GetFileSize(FileName string) (size int64, err error) {
-	workingPath := fmt.Sprint(fsParams.FTPRootFolder, fsParams.checkForSlash(fsParams.FTPWorkingDirectory))
-	fileName := fmt.Sprint(workingPath, fsParams.removeFirstSlash(FileName))
-	fileInfo, err := os.Stat(fileName)
+	fileInfo, err := fsParams.Driver.Stat(fsParams.VirtualPath(FileName))
 	if err != nil {
 		return 0, err
 	}
@@ -211,14 +350,8 @@ func (fsParams *FileSystem) GetFileSize(FileName string) (size int64, err error)
 	return fileInfo.Size(), nil
 }
 
-func executeShellCommands(command string, args ...string) (string, error) {
-	if runtime.GOOS == "windows" {
-		return "", errors.New("Can't execute commands of Windows machine")
-	}
-	output, err := exec.Command(command, args...).Output()
-	if err != nil {
-		fmt.Println("ERROR: ", err)
-		return "", err
-	}
-	return string(output[:]), nil
+//FileExists reports if path is existing file (not a folder)
+func (fsParams *FileSystem) FileExists(path string) bool {
+	fileInfo, err := fsParams.Driver.Stat(fsParams.VirtualPath(path))
+	return err == nil && !fileInfo.IsDir()
 }
@@ -0,0 +1,169 @@
+package ftpfs
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/FTPServConfig"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+//newTestFileSystem makes file system of user with folder "/" on local storage in temporary folder
+func newTestFileSystem(t *testing.T, config *FTPServConfig.ConfigStorage) *FileSystem {
+	t.Helper()
+	config.FTPRootFolder = t.TempDir()
+	fsParams := &FileSystem{}
+	if err := fsParams.InitFileSystem(config, &FTPAuth.User{UserName: "test", Folder: "/"}); err != nil {
+		t.Fatal(err)
+	}
+	return fsParams
+}
+
+//store uploads data with STOR at offset (append if offset < 0)
+func store(t *testing.T, fsParams *FileSystem, name string, data string, offset int64) string {
+	t.Helper()
+	file, filePath, err := fsParams.STOR(name, offset, offset < 0)
+	if err != nil {
+		t.Fatalf("STOR %s at %d: %v", name, offset, err)
+	}
+	io.WriteString(file, data)
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+//retrieve downloads file with RETR from offset
+func retrieve(t *testing.T, fsParams *FileSystem, name string, offset int64) string {
+	t.Helper()
+	file, size, err := fsParams.RETR(name, offset)
+	if err != nil {
+		t.Fatalf("RETR %s at %d: %v", name, offset, err)
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != size {
+		t.Errorf("RETR %s at %d: got %d bytes, %d announced", name, offset, len(data), size)
+	}
+	return string(data)
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRestartOffsets(t *testing.T) {
+	fsParams := newTestFileSystem(t, &FTPServConfig.ConfigStorage{})
+	store(t, fsParams, "file.txt", "0123456789", 0)
+
+	for _, test := range []struct {
+		offset int64
+		data   string
+	}{{0, "0123456789"}, {4, "456789"}, {10, ""}} {
+		if data := retrieve(t, fsParams, "file.txt", test.offset); data != test.data {
+			t.Errorf("RETR at %d: %q, want %q", test.offset, data, test.data)
+		}
+	}
+	if _, _, err := fsParams.RETR("file.txt", 11); err == nil {
+		t.Errorf("RETR beyond file size succeeded")
+	}
+
+	//REST + STOR truncates file at offset and continues from there
+	store(t, fsParams, "file.txt", "abc", 6)
+	if data := retrieve(t, fsParams, "file.txt", 0); data != "012345abc" {
+		t.Errorf("after STOR at 6: %q", data)
+	}
+	if _, _, err := fsParams.STOR("file.txt", 100, false); err == nil {
+		t.Errorf("STOR beyond file size succeeded")
+	}
+	if _, _, err := fsParams.STOR("missing.txt", 1, false); err == nil {
+		t.Errorf("STOR at offset of missing file succeeded")
+	}
+
+	//APPE appends to existing file and creates missing one
+	store(t, fsParams, "file.txt", "def", -1)
+	if data := retrieve(t, fsParams, "file.txt", 0); data != "012345abcdef" {
+		t.Errorf("after APPE: %q", data)
+	}
+	store(t, fsParams, "new.txt", "first", -1)
+	store(t, fsParams, "new.txt", " second", -1)
+	if data := retrieve(t, fsParams, "new.txt", 0); data != "first second" {
+		t.Errorf("APPE of new file: %q", data)
+	}
+}
+
+func TestOverwritePolicy(t *testing.T) {
+	//content of file.txt, file.1.txt and file.2.txt after STOR file.txt, empty filePath - STOR is refused
+	tests := []struct {
+		config   string
+		user     string
+		atomic   bool
+		filePath string
+		content  []string
+	}{
+		{"", "", false, "", []string{"old", "first"}},
+		{OverwritePolicyReject, "", true, "", []string{"old", "first"}},
+		{OverwritePolicyOverwrite, "", false, "/file.txt", []string{"new", "first"}},
+		{OverwritePolicyOverwrite, "", true, "/file.txt", []string{"new", "first"}},
+		{OverwritePolicyRename, "", false, "/file.2.txt", []string{"old", "first", "new"}},
+		{OverwritePolicyRename, "", true, "/file.2.txt", []string{"old", "first", "new"}},
+		{" Overwrite ", "", false, "/file.txt", []string{"new", "first"}},
+		{OverwritePolicyOverwrite, OverwritePolicyReject, false, "", []string{"old", "first"}},
+		{OverwritePolicyReject, "RENAME", false, "/file.2.txt", []string{"old", "first", "new"}},
+	}
+	for _, test := range tests {
+		config := &FTPServConfig.ConfigStorage{OverwritePolicy: test.config, AtomicUploads: test.atomic}
+		fsParams := newTestFileSystem(t, config)
+		if err := fsParams.InitFileSystem(config, &FTPAuth.User{UserName: "test", Folder: "/", OverwritePolicy: test.user}); err != nil {
+			t.Fatal(err)
+		}
+		store(t, fsParams, "file.txt", "old", 0)
+		store(t, fsParams, "file.1.txt", "first", 0)
+		name := fmt.Sprintf("config %q, user %q, atomic %v", test.config, test.user, test.atomic)
+
+		file, filePath, err := fsParams.STOR("file.txt", 0, false)
+		if len(test.filePath) == 0 {
+			if err == nil {
+				file.Close()
+				t.Errorf("%s: existing file replaced", name)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		} else {
+			io.WriteString(file, "new")
+			if err := file.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if filePath != test.filePath {
+				t.Errorf("%s: stored to %s, want %s", name, filePath, test.filePath)
+			}
+		}
+		var content []string
+		for _, fileName := range []string{"file.txt", "file.1.txt", "file.2.txt"} {
+			if fsParams.FileExists(fileName) {
+				content = append(content, retrieve(t, fsParams, fileName, 0))
+			}
+		}
+		if !equalStrings(content, test.content) {
+			t.Errorf("%s: files %q, want %q", name, content, test.content)
+		}
+	}
+	for policy, valid := range map[string]bool{"": true, " Reject": true, "overwrite": true, "rename": true, "append": false} {
+		if ValidOverwritePolicy(policy) != valid {
+			t.Errorf("ValidOverwritePolicy(%q) != %v", policy, valid)
+		}
+	}
+}
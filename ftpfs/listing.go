@@ -0,0 +1,210 @@
+// Directory listing in "ls -l" format
+package ftpfs
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+//defaultOwnerName is shown when owner of file can't be found
+const defaultOwnerName = "ftp"
+
+//ListOptions are "ls" options clients send with LIST (-a, -l, -R)
+type ListOptions struct {
+	All       bool
+	Long      bool
+	Recursive bool
+}
+
+//ParseListArgs splits LIST or NLST argument to options and path. Unknown options are ignored,
+//"--" ends options, so paths starting with "-" may be listed ("-- -file")
+func ParseListArgs(args string) (ListOptions, string) {
+	options := ListOptions{}
+	fields := strings.Fields(args)
+	i := 0
+	for ; i < len(fields); i++ {
+		if len(fields[i]) < 2 || fields[i][0] != '-' {
+			break
+		}
+		if fields[i] == "--" {
+			i++
+			break
+		}
+		for _, option := range fields[i][1:] {
+			switch option {
+			case 'a', 'A':
+				options.All = true
+			case 'l':
+				options.Long = true
+			case 'R':
+				options.Recursive = true
+			}
+		}
+	}
+	if i == 0 {
+		return options, strings.TrimSpace(args)
+	}
+	//path may contain spaces: take rest of string after options
+	path := args
+	for j := 0; j < i; j++ {
+		path = strings.TrimLeft(path, " ")
+		path = path[len(fields[j]):]
+	}
+	return options, strings.TrimSpace(path)
+}
+
+var namesCacheLock sync.Mutex
+var userNamesCache = make(map[string]string)
+var groupNamesCache = make(map[string]string)
+
+func lookupUserName(uid string) string {
+	namesCacheLock.Lock()
+	defer namesCacheLock.Unlock()
+	if name, ok := userNamesCache[uid]; ok {
+		return name
+	}
+	name := uid
+	if usr, err := user.LookupId(uid); err == nil {
+		name = usr.Username
+	}
+	userNamesCache[uid] = name
+	return name
+}
+func lookupGroupName(gid string) string {
+	namesCacheLock.Lock()
+	defer namesCacheLock.Unlock()
+	if name, ok := groupNamesCache[gid]; ok {
+		return name
+	}
+	name := gid
+	if grp, err := user.LookupGroupId(gid); err == nil {
+		name = grp.Name
+	}
+	groupNamesCache[gid] = name
+	return name
+}
+
+//FormatListLine makes "ls -l" line for file
+func FormatListLine(fi os.FileInfo, name string, linkTarget string, now time.Time) string {
+	owner, group, nlink := fileOwnership(fi)
+	line := fmt.Sprintf("%s %4d %-8s %-8s %12d %s %s", formatListMode(fi.Mode()), nlink, owner, group, fi.Size(), formatListTime(fi.ModTime(), now), name)
+	if len(linkTarget) != 0 {
+		line = fmt.Sprint(line, " -> ", linkTarget)
+	}
+	return line
+}
+
+//formatListMode makes "drwxr-xr-x" string with setuid, setgid and sticky bits as ls does
+func formatListMode(mode os.FileMode) string {
+	buf := []byte("----------")
+	switch {
+	case mode&os.ModeDir != 0:
+		buf[0] = 'd'
+	case mode&os.ModeSymlink != 0:
+		buf[0] = 'l'
+	case mode&os.ModeNamedPipe != 0:
+		buf[0] = 'p'
+	case mode&os.ModeSocket != 0:
+		buf[0] = 's'
+	case mode&os.ModeCharDevice != 0:
+		buf[0] = 'c'
+	case mode&os.ModeDevice != 0:
+		buf[0] = 'b'
+	}
+	const rwx = "rwxrwxrwx"
+	perm := mode.Perm()
+	for i := 0; i < 9; i++ {
+		if perm&(1<<uint(8-i)) != 0 {
+			buf[i+1] = rwx[i]
+		}
+	}
+	setSpecialBit(buf, 3, mode&os.ModeSetuid != 0, 's')
+	setSpecialBit(buf, 6, mode&os.ModeSetgid != 0, 's')
+	setSpecialBit(buf, 9, mode&os.ModeSticky != 0, 't')
+	return string(buf)
+}
+func setSpecialBit(buf []byte, index int, set bool, symbol byte) {
+	if !set {
+		return
+	}
+	if buf[index] == 'x' {
+		buf[index] = symbol
+		return
+	}
+	buf[index] = symbol - 'a' + 'A'
+}
+
+//formatListTime uses "Jan _2 15:04" for files modified within last six months, "Jan _2  2006" for others
+func formatListTime(modTime time.Time, now time.Time) string {
+	sixMonthsAgo := now.AddDate(0, -6, 0)
+	if modTime.After(sixMonthsAgo) && !modTime.After(now) {
+		return modTime.Format("Jan _2 15:04")
+	}
+	return modTime.Format("Jan _2  2006")
+}
+
+//listDirectory makes listing lines of directory content. ".." of "/" is shown as "/" itself
+func listDirectory(driver Driver, dirPath string, options ListOptions, now time.Time) ([]string, []string, error) {
+	content, err := driver.ReadDir(dirPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines := make([]string, 0, len(content)+2)
+	subdirs := make([]string, 0)
+	if options.All {
+		for _, special := range []string{".", ".."} {
+			specialPath := dirPath
+			if special == ".." {
+				specialPath = path.Dir(dirPath)
+			}
+			if fi, err := driver.Stat(specialPath); err == nil {
+				lines = append(lines, formatListEntry(fi, special, "", options, now))
+			}
+		}
+	}
+	for _, fi := range content {
+		if !options.All && strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		linkTarget := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			linkTarget, _ = driver.Readlink(path.Join(dirPath, fi.Name()))
+		}
+		lines = append(lines, formatListEntry(fi, fi.Name(), linkTarget, options, now))
+		if fi.IsDir() {
+			subdirs = append(subdirs, fi.Name())
+		}
+	}
+	return lines, subdirs, nil
+}
+func formatListEntry(fi os.FileInfo, name string, linkTarget string, options ListOptions, now time.Time) string {
+	if !options.Long {
+		return name
+	}
+	return FormatListLine(fi, name, linkTarget, now)
+}
+
+//listRecursive lists directory and its subdirectories with "path:" headers as "ls -R" does
+func listRecursive(driver Driver, dirPath string, options ListOptions, now time.Time, output []string) ([]string, error) {
+	lines, subdirs, err := listDirectory(driver, dirPath, options, now)
+	if err != nil {
+		return output, err
+	}
+	if len(output) != 0 {
+		output = append(output, "")
+	}
+	output = append(output, fmt.Sprint(dirPath, ":"))
+	output = append(output, lines...)
+	for _, subdir := range subdirs {
+		output, err = listRecursive(driver, path.Join(dirPath, subdir), options, now, output)
+		if err != nil {
+			return output, err
+		}
+	}
+	return output, nil
+}
@@ -0,0 +1,167 @@
+package ftpfs
+
+import (
+	"FTPServ/FTPServConfig"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseListArgs(t *testing.T) {
+	tests := []struct {
+		args    string
+		options ListOptions
+		path    string
+	}{
+		{"", ListOptions{}, ""},
+		{"docs", ListOptions{}, "docs"},
+		{"-l", ListOptions{Long: true}, ""},
+		{"-la", ListOptions{All: true, Long: true}, ""},
+		{"-la /pub", ListOptions{All: true, Long: true}, "/pub"},
+		{"-a -l my docs", ListOptions{All: true, Long: true}, "my docs"},
+		{"-R", ListOptions{Recursive: true}, ""},
+		{"-lR  sub dir ", ListOptions{Long: true, Recursive: true}, "sub dir"},
+		{"-A -x", ListOptions{All: true}, ""},
+		{"- file", ListOptions{}, "- file"},
+		{"-- -file", ListOptions{}, "-file"},
+		{"-l -- -la", ListOptions{Long: true}, "-la"},
+		{"docs -l", ListOptions{}, "docs -l"},
+	}
+	for _, test := range tests {
+		if options, path := ParseListArgs(test.args); options != test.options || path != test.path {
+			t.Errorf("ParseListArgs(%q) = %+v, %q, want %+v, %q", test.args, options, path, test.options, test.path)
+		}
+	}
+}
+
+func TestFormatListMode(t *testing.T) {
+	tests := []struct {
+		mode os.FileMode
+		line string
+	}{
+		{0644, "-rw-r--r--"},
+		{os.ModeDir | 0755, "drwxr-xr-x"},
+		{os.ModeSymlink | 0777, "lrwxrwxrwx"},
+		{os.ModeNamedPipe | 0600, "prw-------"},
+		{os.ModeSocket | 0755, "srwxr-xr-x"},
+		{os.ModeDevice | os.ModeCharDevice | 0666, "crw-rw-rw-"},
+		{os.ModeDevice | 0660, "brw-rw----"},
+		{os.ModeSetuid | 0755, "-rwsr-xr-x"},
+		{os.ModeSetuid | 0644, "-rwSr--r--"},
+		{os.ModeSetgid | 0755, "-rwxr-sr-x"},
+		{os.ModeSetgid | 0745, "-rwxr-Sr-x"},
+		{os.ModeDir | os.ModeSticky | 0777, "drwxrwxrwt"},
+		{os.ModeDir | os.ModeSticky | 0776, "drwxrwxrwT"},
+	}
+	for _, test := range tests {
+		if line := formatListMode(test.mode); line != test.line {
+			t.Errorf("formatListMode(%v) = %q, want %q", test.mode, line, test.line)
+		}
+	}
+}
+
+func TestFormatListTime(t *testing.T) {
+	now := time.Date(2021, 8, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		modTime time.Time
+		line    string
+	}{
+		{now.Add(-time.Hour), "Aug 15 11:00"},
+		{time.Date(2021, 2, 16, 9, 5, 0, 0, time.UTC), "Feb 16 09:05"},
+		{time.Date(2021, 2, 14, 9, 5, 0, 0, time.UTC), "Feb 14  2021"},
+		{time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC), "Dec  1  2019"},
+		//files from future are shown with year too
+		{now.Add(time.Hour), "Aug 15  2021"},
+	}
+	for _, test := range tests {
+		if line := formatListTime(test.modTime, now); line != test.line {
+			t.Errorf("formatListTime(%v) = %q, want %q", test.modTime, line, test.line)
+		}
+	}
+}
+
+func TestFormatListLine(t *testing.T) {
+	now := time.Date(2021, 8, 15, 12, 0, 0, 0, time.UTC)
+	fi := &driverFileInfo{name: "link", size: 8, mode: os.ModeSymlink | 0777, modTime: now.Add(-time.Minute)}
+	want := "lrwxrwxrwx    1 ftp      ftp                 8 Aug 15 11:59 link -> file.txt"
+	if line := FormatListLine(fi, "link", "file.txt", now); line != want {
+		t.Errorf("FormatListLine:\n%q\nwant\n%q", line, want)
+	}
+}
+
+//listNames returns names at ends of listing lines (link targets are cut)
+func listNames(lines []string) []string {
+	names := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if i := strings.Index(line, " -> "); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		names = append(names, fields[len(fields)-1])
+	}
+	return names
+}
+
+func TestListAndStat(t *testing.T) {
+	fsParams := newTestFileSystem(t, &FTPServConfig.ConfigStorage{})
+	store(t, fsParams, "file.txt", "data", 0)
+	store(t, fsParams, ".hidden", "", 0)
+	if err := fsParams.MakeDir("sub"); err != nil {
+		t.Fatal(err)
+	}
+	store(t, fsParams, "sub/inner.txt", "inner", 0)
+	if err := os.Symlink("file.txt", filepath.Join(fsParams.FTPRootFolder, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, err := fsParams.LIST("", ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"file.txt", "link", "sub"}; !equalStrings(lines, want) {
+		t.Errorf("LIST: %q, want %q", lines, want)
+	}
+
+	lines, err = fsParams.STAT("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{".", "..", ".hidden", "file.txt", "link", "sub"}; !equalStrings(listNames(lines), want) {
+		t.Errorf("STAT names: %q, want %q", listNames(lines), want)
+	}
+	for _, line := range lines {
+		switch listNames([]string{line})[0] {
+		case ".", "..", "sub":
+			if !strings.HasPrefix(line, "d") {
+				t.Errorf("STAT: directory line %q", line)
+			}
+		case "link":
+			if !strings.HasPrefix(line, "l") || !strings.HasSuffix(line, " link -> file.txt") {
+				t.Errorf("STAT: link line %q", line)
+			}
+		case "file.txt":
+			if fields := strings.Fields(line); !strings.HasPrefix(line, "-rw") || fields[4] != "4" {
+				t.Errorf("STAT: file line %q", line)
+			}
+		}
+	}
+
+	//STAT of file gives single line, missing path - error
+	lines, err = fsParams.STAT("sub/inner.txt")
+	if err != nil || len(lines) != 1 || !strings.HasSuffix(lines[0], " inner.txt") {
+		t.Errorf("STAT of file: %q, %v", lines, err)
+	}
+	if _, err := fsParams.STAT("missing"); err == nil {
+		t.Errorf("STAT of missing path succeeded")
+	}
+
+	lines, err = fsParams.LIST("", ListOptions{Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/:", "file.txt", "link", "sub", "", "/sub:", "inner.txt"}; !equalStrings(lines, want) {
+		t.Errorf("LIST -R: %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,268 @@
+// Local disk storage driver
+package ftpfs
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/FTPServConfig"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//LocalDriver serves directory of local file system. Paths can't lead out of it (through ".." or symlinks)
+type LocalDriver struct {
+	RootFolder string
+}
+
+//NewLocalDriver returns driver for rootFolder
+func NewLocalDriver(rootFolder string) *LocalDriver {
+	return &LocalDriver{RootFolder: filepath.Clean(rootFolder)}
+}
+
+func (d *LocalDriver) Stat(path string) (os.FileInfo, error) {
+	fullPath, err := d.resolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(fullPath)
+}
+func (d *LocalDriver) Lstat(path string) (os.FileInfo, error) {
+	fullPath, err := d.resolveLinkPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(fullPath)
+}
+func (d *LocalDriver) ReadDir(path string) ([]os.FileInfo, error) {
+	dir, err := d.openFile(path, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	content, err := dir.Readdir(-1)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(content, func(i, j int) bool { return content[i].Name() < content[j].Name() })
+	return content, nil
+}
+func (d *LocalDriver) Readlink(path string) (string, error) {
+	fullPath, err := d.resolveLinkPath(path)
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(fullPath)
+}
+func (d *LocalDriver) Open(path string, offset int64) (io.ReadCloser, error) {
+	file, err := d.openFile(path, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+func (d *LocalDriver) Create(path string, exclusive bool) (io.WriteCloser, error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if exclusive {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+	return d.openFile(path, flag)
+}
+func (d *LocalDriver) Append(path string) (io.WriteCloser, error) {
+	return d.openFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE)
+}
+func (d *LocalDriver) WriteAt(path string, offset int64) (io.WriteCloser, error) {
+	file, err := d.openFile(path, os.O_WRONLY)
+	if err != nil {
+		return nil, err
+	}
+	if err = file.Truncate(offset); err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+func (d *LocalDriver) Mkdir(path string) error {
+	fullPath, err := d.resolvePath(path)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(fullPath, os.ModePerm.Perm())
+}
+func (d *LocalDriver) Remove(path string) error {
+	fullPath, err := d.resolveLinkPath(path)
+	if err != nil {
+		return err
+	}
+	return os.Remove(fullPath)
+}
+func (d *LocalDriver) RemoveAll(path string) error {
+	fullPath, err := d.resolveLinkPath(path)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(fullPath)
+}
+func (d *LocalDriver) Rename(oldPath string, newPath string) error {
+	oldFullPath, err := d.resolveLinkPath(oldPath)
+	if err != nil {
+		return err
+	}
+	newFullPath, err := d.resolveLinkPath(newPath)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldFullPath, newFullPath)
+}
+func (d *LocalDriver) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	fullPath, err := d.resolvePath(path)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(fullPath, atime, mtime)
+}
+func (d *LocalDriver) CreateTimeSupported() bool {
+	return createTimeSupported
+}
+func (d *LocalDriver) SetCreateTime(path string, createTime time.Time) error {
+	if !createTimeSupported {
+		return ErrNotSupported
+	}
+	fullPath, err := d.resolvePath(path)
+	if err != nil {
+		return err
+	}
+	return setCreateTime(fullPath, createTime)
+}
+
+func newLocalDriverForUser(config *FTPServConfig.ConfigStorage, user *FTPAuth.User) (Driver, error) {
+	return NewLocalDriver(filepath.Join(config.FTPRootFolder, filepath.FromSlash(userFolder(user)))), nil
+}
+
+//resolvePath returns path in local file system for virtual path. Every existing part of path is checked
+//so symlinks can't lead out of root folder
+func (d *LocalDriver) resolvePath(virtualPath string) (string, error) {
+	if strings.ContainsRune(virtualPath, 0) {
+		return "", errors.New("Wrong path")
+	}
+	fullPath := filepath.Join(d.RootFolder, filepath.FromSlash(virtualPath))
+	if err := d.checkInsideRoot(fullPath); err != nil {
+		return "", err
+	}
+	return fullPath, nil
+}
+
+//openFile opens file of virtual path. Link in path may be swapped between resolvePath and opening,
+//so opened file must be the one path resolves to inside root folder. It is truncated only after this check
+func (d *LocalDriver) openFile(virtualPath string, flag int) (*os.File, error) {
+	fullPath, err := d.resolvePath(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(fullPath, flag&^os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err = d.checkOpened(fullPath, file); err == nil && flag&os.O_TRUNC != 0 {
+		err = file.Truncate(0)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+//checkOpened checks that file opened by fullPath is file fullPath resolves to inside root folder now
+func (d *LocalDriver) checkOpened(fullPath string, file *os.File) error {
+	rootPath, err := filepath.EvalSymlinks(d.RootFolder)
+	if err != nil {
+		return err
+	}
+	realPath, err := filepath.EvalSymlinks(fullPath)
+	if err != nil {
+		return ErrPathOutsideRoot
+	}
+	if !isInside(realPath, rootPath) {
+		return ErrPathOutsideRoot
+	}
+	pathInfo, err := os.Lstat(realPath)
+	if err != nil {
+		return ErrPathOutsideRoot
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(pathInfo, fileInfo) {
+		return ErrPathOutsideRoot
+	}
+	return nil
+}
+
+//resolveLinkPath is resolvePath for operations on link itself (Lstat, Remove, Rename): last part of path
+//is not followed, so links leading out of root folder may be removed or renamed
+func (d *LocalDriver) resolveLinkPath(virtualPath string) (string, error) {
+	if strings.ContainsRune(virtualPath, 0) {
+		return "", errors.New("Wrong path")
+	}
+	fullPath := filepath.Join(d.RootFolder, filepath.FromSlash(virtualPath))
+	if fullPath == d.RootFolder {
+		return fullPath, d.checkInsideRoot(fullPath)
+	}
+	if err := d.checkInsideRoot(filepath.Dir(fullPath)); err != nil {
+		return "", err
+	}
+	return fullPath, nil
+}
+
+//checkInsideRoot checks that deepest existing part of fullPath resolves into root folder.
+//Missing parts after it can't be links: virtual paths have no ".."
+func (d *LocalDriver) checkInsideRoot(fullPath string) error {
+	rootPath, err := filepath.EvalSymlinks(d.RootFolder)
+	if err != nil {
+		return err
+	}
+	checking := fullPath
+	for {
+		realPath, err := filepath.EvalSymlinks(checking)
+		if err == nil {
+			if !isInside(realPath, rootPath) {
+				return ErrPathOutsideRoot
+			}
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if _, err := os.Lstat(checking); err == nil {
+			//broken symlink: creating file with its name would create file at link target
+			return ErrPathOutsideRoot
+		}
+		parent := filepath.Dir(checking)
+		if parent == checking || !isInside(parent, d.RootFolder) {
+			return ErrPathOutsideRoot
+		}
+		checking = parent
+	}
+}
+
+//isInside reports if fullPath is rootPath or is in it
+func isInside(fullPath string, rootPath string) bool {
+	relPath, err := filepath.Rel(rootPath, fullPath)
+	if err != nil {
+		return false
+	}
+	return relPath != ".." && !strings.HasPrefix(relPath, fmt.Sprint("..", string(filepath.Separator)))
+}
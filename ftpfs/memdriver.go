@@ -0,0 +1,425 @@
+// In-memory storage driver
+package ftpfs
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/FTPServConfig"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//ErrNoSpace is returned by writers when storage (or user) limit is exceeded
+var ErrNoSpace = errors.New("Not enough storage space")
+
+//default permissions of objects created in memory storage
+const (
+	memDirMode  os.FileMode = os.ModeDir | 0755
+	memFileMode os.FileMode = 0644
+)
+
+//MemoryStorage is a tree of directories and files kept in memory. It is shared by all drivers made for it
+type MemoryStorage struct {
+	lock sync.RWMutex
+	root *memNode
+	used int64
+	//Limit - max total size of files, FileLimit - max size of one file. 0 - no limit
+	Limit     int64
+	FileLimit int64
+}
+type memNode struct {
+	name     string
+	mode     os.FileMode
+	modTime  time.Time
+	data     []byte
+	children map[string]*memNode
+	//removed is set for removed nodes, data still written to them is not counted
+	removed bool
+}
+
+//NewMemoryStorage returns empty storage with root directory only
+func NewMemoryStorage(limit int64, fileLimit int64) *MemoryStorage {
+	return &MemoryStorage{
+		root:      &memNode{name: "/", mode: memDirMode, modTime: time.Now(), children: make(map[string]*memNode)},
+		Limit:     limit,
+		FileLimit: fileLimit,
+	}
+}
+
+//memoryStorage is shared by all users of "memory" storage, lives until server stops
+var memoryStorage *MemoryStorage
+var memoryStorageOnce sync.Once
+
+func newMemoryDriverForUser(config *FTPServConfig.ConfigStorage, user *FTPAuth.User) (Driver, error) {
+	memoryStorageOnce.Do(func() {
+		memoryStorage = NewMemoryStorage(config.MemoryStorageLimit, config.MemoryFileSizeLimit)
+	})
+	return NewMemoryDriver(memoryStorage, userFolder(user))
+}
+
+//MemoryDriver is a view of MemoryStorage with RootFolder of storage as "/"
+type MemoryDriver struct {
+	Storage    *MemoryStorage
+	RootFolder string
+}
+
+//NewMemoryDriver returns driver for rootFolder of storage. rootFolder is created if not exists
+func NewMemoryDriver(storage *MemoryStorage, rootFolder string) (*MemoryDriver, error) {
+	rootFolder = path.Clean(fmt.Sprint("/", rootFolder))
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	node := storage.root
+	for _, name := range strings.Split(strings.Trim(rootFolder, "/"), "/") {
+		if len(name) == 0 {
+			continue
+		}
+		child, ok := node.children[name]
+		if !ok {
+			child = &memNode{name: name, mode: memDirMode, modTime: time.Now(), children: make(map[string]*memNode)}
+			node.children[name] = child
+		}
+		if !child.mode.IsDir() {
+			return nil, errors.New(fmt.Sprint("NewMemoryDriver: ", rootFolder, " is not a directory"))
+		}
+		node = child
+	}
+	return &MemoryDriver{Storage: storage, RootFolder: rootFolder}, nil
+}
+
+func (n *memNode) info() os.FileInfo {
+	return &driverFileInfo{name: n.name, size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+//storagePath returns path in storage for virtual path
+func (d *MemoryDriver) storagePath(virtualPath string) string {
+	return path.Join(d.RootFolder, path.Clean(fmt.Sprint("/", virtualPath)))
+}
+
+//lookup finds node of storage path. Storage lock must be held
+func (d *MemoryDriver) lookup(op string, storagePath string) (*memNode, error) {
+	node := d.Storage.root
+	for _, name := range strings.Split(strings.Trim(storagePath, "/"), "/") {
+		if len(name) == 0 {
+			continue
+		}
+		if !node.mode.IsDir() {
+			return nil, &os.PathError{Op: op, Path: storagePath, Err: errors.New("not a directory")}
+		}
+		child, ok := node.children[name]
+		if !ok {
+			return nil, &os.PathError{Op: op, Path: storagePath, Err: os.ErrNotExist}
+		}
+		node = child
+	}
+	return node, nil
+}
+
+//lookupParent finds directory where object of storage path is (or will be) stored. Storage lock must be held
+func (d *MemoryDriver) lookupParent(op string, storagePath string) (*memNode, string, error) {
+	if storagePath == d.RootFolder {
+		return nil, "", &os.PathError{Op: op, Path: storagePath, Err: os.ErrPermission}
+	}
+	parent, err := d.lookup(op, path.Dir(storagePath))
+	if err != nil {
+		return nil, "", err
+	}
+	if !parent.mode.IsDir() {
+		return nil, "", &os.PathError{Op: op, Path: storagePath, Err: errors.New("not a directory")}
+	}
+	return parent, path.Base(storagePath), nil
+}
+
+//checkWritable checks owner write permission of node
+func checkWritable(op string, storagePath string, node *memNode) error {
+	if node.mode&0200 == 0 {
+		return &os.PathError{Op: op, Path: storagePath, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+func (d *MemoryDriver) Stat(path string) (os.FileInfo, error) {
+	d.Storage.lock.RLock()
+	defer d.Storage.lock.RUnlock()
+	node, err := d.lookup("stat", d.storagePath(path))
+	if err != nil {
+		return nil, err
+	}
+	return node.info(), nil
+}
+
+//Lstat is Stat: there are no links in memory storage
+func (d *MemoryDriver) Lstat(path string) (os.FileInfo, error) {
+	return d.Stat(path)
+}
+func (d *MemoryDriver) ReadDir(path string) ([]os.FileInfo, error) {
+	d.Storage.lock.RLock()
+	defer d.Storage.lock.RUnlock()
+	storagePath := d.storagePath(path)
+	node, err := d.lookup("readdir", storagePath)
+	if err != nil {
+		return nil, err
+	}
+	if !node.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: storagePath, Err: errors.New("not a directory")}
+	}
+	if node.mode&0400 == 0 {
+		return nil, &os.PathError{Op: "readdir", Path: storagePath, Err: os.ErrPermission}
+	}
+	content := make([]os.FileInfo, 0, len(node.children))
+	for _, child := range node.children {
+		content = append(content, child.info())
+	}
+	sort.Slice(content, func(i, j int) bool { return content[i].Name() < content[j].Name() })
+	return content, nil
+}
+func (d *MemoryDriver) Readlink(path string) (string, error) {
+	return "", &os.PathError{Op: "readlink", Path: d.storagePath(path), Err: errors.New("not a link")}
+}
+
+//Open returns reader of file content copy, so later writes don't change data being sent
+func (d *MemoryDriver) Open(path string, offset int64) (io.ReadCloser, error) {
+	d.Storage.lock.RLock()
+	defer d.Storage.lock.RUnlock()
+	storagePath := d.storagePath(path)
+	node, err := d.lookup("open", storagePath)
+	if err != nil {
+		return nil, err
+	}
+	if node.mode.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: storagePath, Err: errors.New("is a directory")}
+	}
+	if node.mode&0400 == 0 {
+		return nil, &os.PathError{Op: "open", Path: storagePath, Err: os.ErrPermission}
+	}
+	if offset > int64(len(node.data)) {
+		offset = int64(len(node.data))
+	}
+	data := make([]byte, int64(len(node.data))-offset)
+	copy(data, node.data[offset:])
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+func (d *MemoryDriver) Create(path string, exclusive bool) (io.WriteCloser, error) {
+	d.Storage.lock.Lock()
+	defer d.Storage.lock.Unlock()
+	storagePath := d.storagePath(path)
+	parent, name, err := d.lookupParent("create", storagePath)
+	if err != nil {
+		return nil, err
+	}
+	node, ok := parent.children[name]
+	if ok {
+		if exclusive {
+			return nil, &os.PathError{Op: "create", Path: storagePath, Err: os.ErrExist}
+		}
+		if node.mode.IsDir() {
+			return nil, &os.PathError{Op: "create", Path: storagePath, Err: errors.New("is a directory")}
+		}
+		if err := checkWritable("create", storagePath, node); err != nil {
+			return nil, err
+		}
+		d.Storage.used -= int64(len(node.data))
+		node.data = nil
+		node.modTime = time.Now()
+		return &memWriter{storage: d.Storage, node: node}, nil
+	}
+	if err := checkWritable("create", storagePath, parent); err != nil {
+		return nil, err
+	}
+	node = &memNode{name: name, mode: memFileMode, modTime: time.Now()}
+	parent.children[name] = node
+	parent.modTime = node.modTime
+	return &memWriter{storage: d.Storage, node: node}, nil
+}
+func (d *MemoryDriver) Append(path string) (io.WriteCloser, error) {
+	d.Storage.lock.Lock()
+	_, err := d.lookup("append", d.storagePath(path))
+	d.Storage.lock.Unlock()
+	if os.IsNotExist(err) {
+		return d.Create(path, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.openForWrite("append", path, -1)
+}
+func (d *MemoryDriver) WriteAt(path string, offset int64) (io.WriteCloser, error) {
+	return d.openForWrite("writeat", path, offset)
+}
+
+//openForWrite opens existing file for writing, truncated to offset (offset < 0 - at end of file)
+func (d *MemoryDriver) openForWrite(op string, path string, offset int64) (io.WriteCloser, error) {
+	d.Storage.lock.Lock()
+	defer d.Storage.lock.Unlock()
+	storagePath := d.storagePath(path)
+	node, err := d.lookup(op, storagePath)
+	if err != nil {
+		return nil, err
+	}
+	if node.mode.IsDir() {
+		return nil, &os.PathError{Op: op, Path: storagePath, Err: errors.New("is a directory")}
+	}
+	if err := checkWritable(op, storagePath, node); err != nil {
+		return nil, err
+	}
+	if offset >= 0 && offset < int64(len(node.data)) {
+		d.Storage.used -= int64(len(node.data)) - offset
+		node.data = node.data[:offset]
+	}
+	return &memWriter{storage: d.Storage, node: node}, nil
+}
+func (d *MemoryDriver) Mkdir(path string) error {
+	d.Storage.lock.Lock()
+	defer d.Storage.lock.Unlock()
+	storagePath := d.storagePath(path)
+	parent, name, err := d.lookupParent("mkdir", storagePath)
+	if err != nil {
+		return err
+	}
+	if _, ok := parent.children[name]; ok {
+		return &os.PathError{Op: "mkdir", Path: storagePath, Err: os.ErrExist}
+	}
+	if err := checkWritable("mkdir", storagePath, parent); err != nil {
+		return err
+	}
+	node := &memNode{name: name, mode: memDirMode, modTime: time.Now(), children: make(map[string]*memNode)}
+	parent.children[name] = node
+	parent.modTime = node.modTime
+	return nil
+}
+func (d *MemoryDriver) Remove(path string) error {
+	return d.remove("remove", path, false)
+}
+func (d *MemoryDriver) RemoveAll(path string) error {
+	return d.remove("removeall", path, true)
+}
+func (d *MemoryDriver) remove(op string, path string, recursive bool) error {
+	d.Storage.lock.Lock()
+	defer d.Storage.lock.Unlock()
+	storagePath := d.storagePath(path)
+	parent, name, err := d.lookupParent(op, storagePath)
+	if err != nil {
+		return err
+	}
+	node, ok := parent.children[name]
+	if !ok {
+		if recursive {
+			return nil
+		}
+		return &os.PathError{Op: op, Path: storagePath, Err: os.ErrNotExist}
+	}
+	if err := checkWritable(op, storagePath, parent); err != nil {
+		return err
+	}
+	if node.mode.IsDir() && len(node.children) != 0 && !recursive {
+		return &os.PathError{Op: op, Path: storagePath, Err: errors.New("directory not empty")}
+	}
+	d.Storage.used -= node.totalSize()
+	node.markRemoved()
+	delete(parent.children, name)
+	parent.modTime = time.Now()
+	return nil
+}
+
+func (n *memNode) markRemoved() {
+	n.removed = true
+	for _, child := range n.children {
+		child.markRemoved()
+	}
+}
+
+//totalSize returns size of files in node and its subdirectories
+func (n *memNode) totalSize() int64 {
+	size := int64(len(n.data))
+	for _, child := range n.children {
+		size += child.totalSize()
+	}
+	return size
+}
+func (d *MemoryDriver) Rename(oldPath string, newPath string) error {
+	d.Storage.lock.Lock()
+	defer d.Storage.lock.Unlock()
+	oldStoragePath := d.storagePath(oldPath)
+	newStoragePath := d.storagePath(newPath)
+	oldParent, oldName, err := d.lookupParent("rename", oldStoragePath)
+	if err != nil {
+		return err
+	}
+	node, ok := oldParent.children[oldName]
+	if !ok {
+		return &os.PathError{Op: "rename", Path: oldStoragePath, Err: os.ErrNotExist}
+	}
+	if strings.HasPrefix(newStoragePath, fmt.Sprint(oldStoragePath, "/")) {
+		return &os.PathError{Op: "rename", Path: newStoragePath, Err: errors.New("can't move directory into itself")}
+	}
+	newParent, newName, err := d.lookupParent("rename", newStoragePath)
+	if err != nil {
+		return err
+	}
+	if err := checkWritable("rename", oldStoragePath, oldParent); err != nil {
+		return err
+	}
+	if err := checkWritable("rename", newStoragePath, newParent); err != nil {
+		return err
+	}
+	if existing, ok := newParent.children[newName]; ok && existing != node {
+		//same rules as rename(2): file replaces file, directory replaces empty directory
+		if existing.mode.IsDir() != node.mode.IsDir() || len(existing.children) != 0 {
+			return &os.PathError{Op: "rename", Path: newStoragePath, Err: os.ErrExist}
+		}
+		d.Storage.used -= existing.totalSize()
+		existing.markRemoved()
+	}
+	delete(oldParent.children, oldName)
+	node.name = newName
+	newParent.children[newName] = node
+	now := time.Now()
+	oldParent.modTime = now
+	newParent.modTime = now
+	return nil
+}
+func (d *MemoryDriver) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	d.Storage.lock.Lock()
+	defer d.Storage.lock.Unlock()
+	node, err := d.lookup("chtimes", d.storagePath(path))
+	if err != nil {
+		return err
+	}
+	node.modTime = mtime
+	return nil
+}
+
+//memWriter writes to file of memory storage. Data is visible to others while written, as on disk
+type memWriter struct {
+	storage *MemoryStorage
+	node    *memNode
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.storage.lock.Lock()
+	defer w.storage.lock.Unlock()
+	size := int64(len(p))
+	if w.node.removed {
+		return len(p), nil
+	}
+	if w.storage.FileLimit > 0 && int64(len(w.node.data))+size > w.storage.FileLimit {
+		return 0, ErrNoSpace
+	}
+	if w.storage.Limit > 0 && w.storage.used+size > w.storage.Limit {
+		return 0, ErrNoSpace
+	}
+	w.node.data = append(w.node.data, p...)
+	w.node.modTime = time.Now()
+	w.storage.used += size
+	return len(p), nil
+}
+func (w *memWriter) Close() error {
+	return nil
+}
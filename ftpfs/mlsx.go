@@ -0,0 +1,156 @@
+// Machine listings (RFC 3659 MLST, MLSD)
+package ftpfs
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//MLSTFacts - facts supported by server in order they are written
+var MLSTFacts = []string{"type", "size", "modify", "perm", "unique", "unix.mode"}
+
+//SelectMLSTFacts returns supported facts from "fact1;fact2;" list sent with OPTS MLST
+func SelectMLSTFacts(list string) []string {
+	requested := make(map[string]bool)
+	for _, fact := range strings.Split(list, ";") {
+		requested[strings.ToLower(strings.TrimSpace(fact))] = true
+	}
+	selected := make([]string, 0, len(MLSTFacts))
+	for _, fact := range MLSTFacts {
+		if requested[fact] {
+			selected = append(selected, fact)
+		}
+	}
+	return selected
+}
+
+//MLSTFeature returns FEAT line for MLST with selected facts marked by "*"
+func MLSTFeature(selected []string) string {
+	isSelected := make(map[string]bool)
+	for _, fact := range selected {
+		isSelected[fact] = true
+	}
+	feature := "MLST "
+	for _, fact := range MLSTFacts {
+		feature = fmt.Sprint(feature, fact)
+		if isSelected[fact] {
+			feature = fmt.Sprint(feature, "*")
+		}
+		feature = fmt.Sprint(feature, ";")
+	}
+	return feature
+}
+
+//MLST returns facts line for single object (working directory if path is empty)
+func (fsParams *FileSystem) MLST(path string, facts []string) (string, error) {
+	path = fsParams.VirtualPath(path)
+	fi, err := fsParams.Driver.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return formatFacts(fi, path, "", facts, path), nil
+}
+
+//MLSD returns facts lines for directory content (working directory if path is empty)
+func (fsParams *FileSystem) MLSD(path string, facts []string) ([]string, error) {
+	path = fsParams.VirtualPath(path)
+	dirInfo, err := fsParams.Driver.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if dirInfo.IsDir() == false {
+		return nil, errors.New("Not a dir")
+	}
+	content, err := fsParams.Driver.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, 0, len(content)+1)
+	lines = append(lines, formatFacts(dirInfo, path, "cdir", facts, "."))
+	for _, fi := range content {
+		entryPath := fmt.Sprint(strings.TrimRight(path, "/"), "/", fi.Name())
+		if fi.Mode()&os.ModeSymlink != 0 {
+			//facts are given for link target, broken links and links out of root folder are skipped
+			target, err := fsParams.Driver.Stat(entryPath)
+			if err != nil {
+				continue
+			}
+			lines = append(lines, formatFacts(target, entryPath, "", facts, fi.Name()))
+			continue
+		}
+		lines = append(lines, formatFacts(fi, entryPath, "", facts, fi.Name()))
+	}
+	return lines, nil
+}
+
+//formatFacts makes "fact=value;...; name" line. objType overrides "file"/"dir" type (cdir, pdir)
+func formatFacts(fi os.FileInfo, objPath string, objType string, facts []string, name string) string {
+	line := ""
+	for _, fact := range facts {
+		value := ""
+		switch fact {
+		case "type":
+			value = objType
+			if len(value) == 0 {
+				value = "file"
+				if fi.IsDir() {
+					value = "dir"
+				}
+			}
+		case "size":
+			if fi.IsDir() {
+				continue
+			}
+			value = fmt.Sprint(fi.Size())
+		case "modify":
+			value = FormatFTPTime(fi.ModTime())
+		case "perm":
+			value = factPerm(fi)
+		case "unique":
+			value = fileUniqueID(fi, objPath)
+		case "unix.mode":
+			value = fmt.Sprintf("0%o", fi.Mode().Perm())
+		default:
+			continue
+		}
+		line = fmt.Sprint(line, fact, "=", value, ";")
+	}
+	return fmt.Sprint(line, " ", name)
+}
+
+//factPerm returns RFC 3659 perm fact from owner permission bits
+func factPerm(fi os.FileInfo) string {
+	mode := fi.Mode().Perm()
+	canRead := mode&0400 != 0
+	canWrite := mode&0200 != 0
+	canEnter := mode&0100 != 0
+	perm := ""
+	if fi.IsDir() {
+		if canWrite {
+			perm = fmt.Sprint(perm, "cmpfd")
+		}
+		if canEnter {
+			perm = fmt.Sprint(perm, "e")
+		}
+		if canRead {
+			perm = fmt.Sprint(perm, "l")
+		}
+		return perm
+	}
+	if canWrite {
+		perm = fmt.Sprint(perm, "awfd")
+	}
+	if canRead {
+		perm = fmt.Sprint(perm, "r")
+	}
+	return perm
+}
+
+func pathUniqueID(path string) string {
+	sum := sha1.Sum([]byte(path))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,99 @@
+package ftpfs
+
+import (
+	"FTPServ/FTPServConfig"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectMLSTFacts(t *testing.T) {
+	tests := []struct {
+		list     string
+		selected string
+	}{
+		{"type;size;modify;perm;unique;unix.mode;", "type;size;modify;perm;unique;unix.mode"},
+		{"Perm;TYPE; size ;", "type;size;perm"},
+		{"unknown;modify", "modify"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if selected := strings.Join(SelectMLSTFacts(test.list), ";"); selected != test.selected {
+			t.Errorf("SelectMLSTFacts(%q) = %q, want %q", test.list, selected, test.selected)
+		}
+	}
+	if feature := MLSTFeature([]string{"type", "perm"}); feature != "MLST type*;size;modify;perm*;unique;unix.mode;" {
+		t.Errorf("MLSTFeature: %q", feature)
+	}
+}
+
+func TestFormatFacts(t *testing.T) {
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	file := &driverFileInfo{name: "a.txt", size: 42, mode: 0640, modTime: modTime}
+	dir := &driverFileInfo{name: "docs", mode: os.ModeDir | 0755, modTime: modTime}
+	tests := []struct {
+		fi      os.FileInfo
+		objType string
+		facts   []string
+		line    string
+	}{
+		{file, "", MLSTFacts, "type=file;size=42;modify=20210304050607;perm=awfdr;unique=" + pathUniqueID("/a.txt") + ";unix.mode=0640; a.txt"},
+		{dir, "", []string{"type", "size", "perm"}, "type=dir;perm=cmpfdel; a.txt"},
+		{dir, "cdir", []string{"type"}, "type=cdir; a.txt"},
+		{file, "", []string{"unknown", "size"}, "size=42; a.txt"},
+		{file, "", nil, " a.txt"},
+	}
+	for _, test := range tests {
+		if line := formatFacts(test.fi, "/a.txt", test.objType, test.facts, "a.txt"); line != test.line {
+			t.Errorf("formatFacts(%s, %q, %q) = %q, want %q", test.fi.Name(), test.objType, test.facts, line, test.line)
+		}
+	}
+}
+
+func TestFactPerm(t *testing.T) {
+	tests := []struct {
+		mode os.FileMode
+		perm string
+	}{
+		{0644, "awfdr"},
+		{0444, "r"},
+		{0200, "awfd"},
+		{0000, ""},
+		{os.ModeDir | 0755, "cmpfdel"},
+		{os.ModeDir | 0555, "el"},
+		{os.ModeDir | 0444, "l"},
+		{os.ModeDir | 0100, "e"},
+	}
+	for _, test := range tests {
+		if perm := factPerm(&driverFileInfo{mode: test.mode}); perm != test.perm {
+			t.Errorf("factPerm(%v) = %q, want %q", test.mode, perm, test.perm)
+		}
+	}
+}
+
+func TestMLSD(t *testing.T) {
+	fsParams := newTestFileSystem(t, &FTPServConfig.ConfigStorage{})
+	store(t, fsParams, "file.txt", "data", 0)
+	if err := fsParams.MakeDir("sub"); err != nil {
+		t.Fatal(err)
+	}
+	os.Symlink("file.txt", filepath.Join(fsParams.FTPRootFolder, "link"))
+	os.Symlink("missing.txt", filepath.Join(fsParams.FTPRootFolder, "broken"))
+
+	lines, err := fsParams.MLSD("", []string{"type", "size"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"type=cdir; .", "type=file;size=4; file.txt", "type=file;size=4; link", "type=dir; sub"}
+	if !equalStrings(lines, want) {
+		t.Errorf("MLSD: %q, want %q", lines, want)
+	}
+	if _, err := fsParams.MLSD("file.txt", []string{"type"}); err == nil {
+		t.Errorf("MLSD of file succeeded")
+	}
+	if line, err := fsParams.MLST("sub", []string{"type"}); err != nil || line != "type=dir; /sub" {
+		t.Errorf("MLST: %q, %v", line, err)
+	}
+}
@@ -0,0 +1,319 @@
+// Per-user virtual folder mounts
+package ftpfs
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/FTPServConfig"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//ErrCrossMount is returned when object is renamed from one mount to another
+var ErrCrossMount = errors.New("Can't move objects between mounts")
+
+//MountDriver shows drivers of mounts at their paths of Driver (user root folder).
+//Folders leading to mount paths are shown even if they don't exist in Driver
+type MountDriver struct {
+	Driver
+	mounts  []*mountPoint
+	created time.Time
+}
+
+type mountPoint struct {
+	path   string
+	driver Driver
+}
+
+//NewMountDriver returns driver with root driver and no mounts
+func NewMountDriver(root Driver) *MountDriver {
+	return &MountDriver{Driver: root, created: time.Now()}
+}
+
+//Mount shows driver at mountPath. Deeper mounts hide parts of upper ones
+func (d *MountDriver) Mount(mountPath string, driver Driver) error {
+	mountPath = path.Clean(fmt.Sprint("/", mountPath))
+	if mountPath == "/" {
+		return errors.New("Mount: root folder can't be mounted")
+	}
+	for _, mount := range d.mounts {
+		if mount.path == mountPath {
+			return errors.New(fmt.Sprint("Mount: ", mountPath, " is mounted already"))
+		}
+	}
+	d.mounts = append(d.mounts, &mountPoint{path: mountPath, driver: driver})
+	sort.Slice(d.mounts, func(i, j int) bool { return len(d.mounts[i].path) > len(d.mounts[j].path) })
+	return nil
+}
+
+//route returns driver serving virtual path, path in it and its mount (nil for root driver)
+func (d *MountDriver) route(virtualPath string) (Driver, string, *mountPoint) {
+	for _, mount := range d.mounts {
+		if virtualPath == mount.path {
+			return mount.driver, "/", mount
+		}
+		if strings.HasPrefix(virtualPath, fmt.Sprint(mount.path, "/")) {
+			return mount.driver, virtualPath[len(mount.path):], mount
+		}
+	}
+	return d.Driver, virtualPath, nil
+}
+
+//leadsToMount reports if virtual path is a folder above some mount path
+func (d *MountDriver) leadsToMount(virtualPath string) bool {
+	prefix := strings.TrimSuffix(virtualPath, "/") + "/"
+	for _, mount := range d.mounts {
+		if strings.HasPrefix(mount.path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+//fixed reports if virtual path can't be removed or renamed: it is a mount path or a folder above it
+func (d *MountDriver) fixed(virtualPath string) bool {
+	_, innerPath, mount := d.route(virtualPath)
+	return (mount != nil && innerPath == "/") || d.leadsToMount(virtualPath)
+}
+
+func (d *MountDriver) stat(virtualPath string, followLinks bool) (os.FileInfo, error) {
+	driver, innerPath, mount := d.route(virtualPath)
+	var fi os.FileInfo
+	var err error
+	if followLinks || mount != nil && innerPath == "/" {
+		fi, err = driver.Stat(innerPath)
+	} else {
+		fi, err = driver.Lstat(innerPath)
+	}
+	if err != nil && os.IsNotExist(err) && mount == nil && d.leadsToMount(virtualPath) {
+		return &driverFileInfo{name: path.Base(virtualPath), mode: os.ModeDir | 0555, modTime: d.created}, nil
+	}
+	if err != nil || mount == nil || innerPath != "/" {
+		return fi, err
+	}
+	//root of mounted driver is shown with name of mount path
+	return &driverFileInfo{name: path.Base(virtualPath), size: fi.Size(), mode: fi.Mode(), modTime: fi.ModTime()}, nil
+}
+func (d *MountDriver) Stat(path string) (os.FileInfo, error) {
+	return d.stat(path, true)
+}
+func (d *MountDriver) Lstat(path string) (os.FileInfo, error) {
+	return d.stat(path, false)
+}
+
+//ReadDir returns directory content with mount paths (and folders leading to them) in it
+func (d *MountDriver) ReadDir(dirPath string) ([]os.FileInfo, error) {
+	driver, innerPath, mount := d.route(dirPath)
+	content, err := driver.ReadDir(innerPath)
+	if mount != nil {
+		return content, err
+	}
+	if err != nil {
+		if !os.IsNotExist(err) || !d.leadsToMount(dirPath) {
+			return nil, err
+		}
+		content = nil
+	}
+	prefix := strings.TrimSuffix(dirPath, "/") + "/"
+	shown := make(map[string]bool)
+	for _, mount := range d.mounts {
+		if !strings.HasPrefix(mount.path, prefix) {
+			continue
+		}
+		name := strings.SplitN(mount.path[len(prefix):], "/", 2)[0]
+		if shown[name] {
+			continue
+		}
+		shown[name] = true
+		fi, err := d.Lstat(path.Join(dirPath, name))
+		if err != nil {
+			continue
+		}
+		replaced := false
+		for i := range content {
+			if content[i].Name() == name {
+				content[i] = fi
+				replaced = true
+			}
+		}
+		if !replaced {
+			content = append(content, fi)
+		}
+	}
+	sort.Slice(content, func(i, j int) bool { return content[i].Name() < content[j].Name() })
+	return content, nil
+}
+func (d *MountDriver) Readlink(path string) (string, error) {
+	driver, innerPath, _ := d.route(path)
+	return driver.Readlink(innerPath)
+}
+func (d *MountDriver) Open(path string, offset int64) (io.ReadCloser, error) {
+	driver, innerPath, _ := d.route(path)
+	return driver.Open(innerPath, offset)
+}
+func (d *MountDriver) Create(path string, exclusive bool) (io.WriteCloser, error) {
+	driver, innerPath, _ := d.route(path)
+	return driver.Create(innerPath, exclusive)
+}
+func (d *MountDriver) Append(path string) (io.WriteCloser, error) {
+	driver, innerPath, _ := d.route(path)
+	return driver.Append(innerPath)
+}
+func (d *MountDriver) WriteAt(path string, offset int64) (io.WriteCloser, error) {
+	driver, innerPath, _ := d.route(path)
+	return driver.WriteAt(innerPath, offset)
+}
+func (d *MountDriver) Mkdir(path string) error {
+	if d.fixed(path) {
+		return &os.PathError{Op: "mkdir", Path: path, Err: os.ErrExist}
+	}
+	driver, innerPath, _ := d.route(path)
+	return driver.Mkdir(innerPath)
+}
+func (d *MountDriver) Remove(path string) error {
+	if d.fixed(path) {
+		return &os.PathError{Op: "remove", Path: path, Err: os.ErrPermission}
+	}
+	driver, innerPath, _ := d.route(path)
+	return driver.Remove(innerPath)
+}
+func (d *MountDriver) RemoveAll(path string) error {
+	if d.fixed(path) {
+		return &os.PathError{Op: "removeall", Path: path, Err: os.ErrPermission}
+	}
+	driver, innerPath, _ := d.route(path)
+	return driver.RemoveAll(innerPath)
+}
+func (d *MountDriver) Rename(oldPath string, newPath string) error {
+	if d.fixed(oldPath) || d.fixed(newPath) {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrPermission}
+	}
+	oldDriver, oldInnerPath, oldMount := d.route(oldPath)
+	_, newInnerPath, newMount := d.route(newPath)
+	if oldMount != newMount {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: ErrCrossMount}
+	}
+	return oldDriver.Rename(oldInnerPath, newInnerPath)
+}
+func (d *MountDriver) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	driver, innerPath, _ := d.route(path)
+	return driver.Chtimes(innerPath, atime, mtime)
+}
+func (d *MountDriver) CreateTimeSupported() bool {
+	setter, ok := d.Driver.(CreateTimeSetter)
+	return ok && setter.CreateTimeSupported()
+}
+func (d *MountDriver) SetCreateTime(path string, createTime time.Time) error {
+	driver, innerPath, _ := d.route(path)
+	setter, ok := driver.(CreateTimeSetter)
+	if !ok {
+		return ErrNotSupported
+	}
+	return setter.SetCreateTime(innerPath, createTime)
+}
+
+//ReadOnlyDriver is Driver refusing all changes, objects are shown without write permissions
+type ReadOnlyDriver struct {
+	Driver
+}
+
+//readOnlyFileInfo is os.FileInfo with write permissions cleared
+type readOnlyFileInfo struct {
+	os.FileInfo
+}
+
+func (fi *readOnlyFileInfo) Mode() os.FileMode { return fi.FileInfo.Mode() &^ 0222 }
+
+func (d *ReadOnlyDriver) Stat(path string) (os.FileInfo, error) {
+	fi, err := d.Driver.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyFileInfo{FileInfo: fi}, nil
+}
+func (d *ReadOnlyDriver) Lstat(path string) (os.FileInfo, error) {
+	fi, err := d.Driver.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyFileInfo{FileInfo: fi}, nil
+}
+func (d *ReadOnlyDriver) ReadDir(path string) ([]os.FileInfo, error) {
+	content, err := d.Driver.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, fi := range content {
+		content[i] = &readOnlyFileInfo{FileInfo: fi}
+	}
+	return content, nil
+}
+
+func (d *ReadOnlyDriver) Create(path string, exclusive bool) (io.WriteCloser, error) {
+	return nil, &os.PathError{Op: "create", Path: path, Err: os.ErrPermission}
+}
+func (d *ReadOnlyDriver) Append(path string) (io.WriteCloser, error) {
+	return nil, &os.PathError{Op: "append", Path: path, Err: os.ErrPermission}
+}
+func (d *ReadOnlyDriver) WriteAt(path string, offset int64) (io.WriteCloser, error) {
+	return nil, &os.PathError{Op: "writeat", Path: path, Err: os.ErrPermission}
+}
+func (d *ReadOnlyDriver) Mkdir(path string) error {
+	return &os.PathError{Op: "mkdir", Path: path, Err: os.ErrPermission}
+}
+func (d *ReadOnlyDriver) Remove(path string) error {
+	return &os.PathError{Op: "remove", Path: path, Err: os.ErrPermission}
+}
+func (d *ReadOnlyDriver) RemoveAll(path string) error {
+	return &os.PathError{Op: "removeall", Path: path, Err: os.ErrPermission}
+}
+func (d *ReadOnlyDriver) Rename(oldPath string, newPath string) error {
+	return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrPermission}
+}
+func (d *ReadOnlyDriver) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: path, Err: os.ErrPermission}
+}
+
+//newMountDriverForUser mounts Mounts of user over root driver
+func newMountDriverForUser(config *FTPServConfig.ConfigStorage, user *FTPAuth.User, root Driver) (Driver, error) {
+	mountDriver := NewMountDriver(root)
+	for _, mount := range user.Mounts {
+		driver, err := newMountedDriver(config, mount)
+		if err != nil {
+			return nil, errors.New(fmt.Sprint("Mount ", mount.Path, " error: ", err))
+		}
+		if mount.ReadOnly {
+			driver = &ReadOnlyDriver{Driver: driver}
+		}
+		if err = mountDriver.Mount(mount.Path, driver); err != nil {
+			return nil, err
+		}
+	}
+	return mountDriver, nil
+}
+
+//newMountedDriver makes driver for Folder of mount
+func newMountedDriver(config *FTPServConfig.ConfigStorage, mount FTPAuth.Mount) (Driver, error) {
+	storage := strings.ToLower(strings.TrimSpace(mount.Storage))
+	if len(storage) == 0 || storage == DefaultStorage {
+		folder := filepath.FromSlash(mount.Folder)
+		if !filepath.IsAbs(folder) {
+			folder = filepath.Join(config.FTPRootFolder, folder)
+		}
+		fi, err := os.Stat(folder)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			return nil, errors.New(fmt.Sprint(folder, " is not a directory"))
+		}
+		return NewLocalDriver(folder), nil
+	}
+	return newStorageDriver(storage, config, &FTPAuth.User{Folder: mount.Folder})
+}
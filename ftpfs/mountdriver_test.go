@@ -0,0 +1,180 @@
+package ftpfs
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/FTPServConfig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestMemoryDriver(t *testing.T) Driver {
+	driver, err := NewMemoryDriver(NewMemoryStorage(0, 0), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return driver
+}
+
+func writeFile(t *testing.T, d Driver, path string, data string) {
+	t.Helper()
+	file, err := d.Create(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(data))
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(d Driver, path string) string {
+	file, err := d.Open(path, 0)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	data, _ := ioutil.ReadAll(file)
+	return string(data)
+}
+
+//dirNames returns names of directory content
+func dirNames(t *testing.T, d Driver, dirPath string) []string {
+	t.Helper()
+	content, err := d.ReadDir(dirPath)
+	if err != nil {
+		t.Fatalf("ReadDir %s: %v", dirPath, err)
+	}
+	names := make([]string, 0, len(content))
+	for _, fi := range content {
+		names = append(names, fi.Name())
+	}
+	return names
+}
+
+func TestMountResolution(t *testing.T) {
+	root, data, deep := newTestMemoryDriver(t), newTestMemoryDriver(t), newTestMemoryDriver(t)
+	writeFile(t, root, "/home.txt", "root")
+	writeFile(t, data, "/file.txt", "data")
+	writeFile(t, deep, "/file.txt", "deep")
+	d := NewMountDriver(root)
+	for mountPath, driver := range map[string]Driver{"data": data, "/a/b/deep/": deep} {
+		if err := d.Mount(mountPath, driver); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Mount("/data", deep); err == nil {
+		t.Errorf("second mount at /data succeeded")
+	}
+	if err := d.Mount("/", deep); err == nil {
+		t.Errorf("mount at root folder succeeded")
+	}
+
+	for filePath, want := range map[string]string{"/home.txt": "root", "/data/file.txt": "data", "/a/b/deep/file.txt": "deep"} {
+		if got := readFile(d, filePath); got != want {
+			t.Errorf("%s: %q, want %q", filePath, got, want)
+		}
+	}
+	writeFile(t, d, "/data/new.txt", "new")
+	if got := readFile(data, "/new.txt"); got != "new" {
+		t.Errorf("file written to mount is not in mounted driver: %q", got)
+	}
+
+	//folders leading to mount are shown without existing in root driver
+	if names := dirNames(t, d, "/"); !equalStrings(names, []string{"a", "data", "home.txt"}) {
+		t.Errorf("ReadDir /: %q", names)
+	}
+	if names := dirNames(t, d, "/a/b"); !equalStrings(names, []string{"deep"}) {
+		t.Errorf("ReadDir /a/b: %q", names)
+	}
+	for _, dirPath := range []string{"/a", "/a/b", "/data"} {
+		fi, err := d.Stat(dirPath)
+		if err != nil || !fi.IsDir() {
+			t.Errorf("Stat %s: %v, %v", dirPath, fi, err)
+		}
+	}
+	if fi, err := d.Stat("/data"); err == nil && fi.Name() != "data" {
+		t.Errorf("mount root is named %q", fi.Name())
+	}
+	if _, err := d.Stat("/a/c"); !os.IsNotExist(err) {
+		t.Errorf("Stat of missing folder next to mount path: %v", err)
+	}
+
+	//mount paths and folders leading to them can't be changed
+	for _, fixedPath := range []string{"/data", "/a", "/a/b/deep"} {
+		if err := d.Remove(fixedPath); !os.IsPermission(err) {
+			t.Errorf("Remove %s: %v", fixedPath, err)
+		}
+		if err := d.RemoveAll(fixedPath); !os.IsPermission(err) {
+			t.Errorf("RemoveAll %s: %v", fixedPath, err)
+		}
+		if err := d.Rename(fixedPath, "/moved"); !os.IsPermission(err) {
+			t.Errorf("Rename %s: %v", fixedPath, err)
+		}
+		if err := d.Mkdir(fixedPath); !os.IsExist(err) {
+			t.Errorf("Mkdir %s: %v", fixedPath, err)
+		}
+	}
+}
+
+func TestCrossMountRename(t *testing.T) {
+	root, data := newTestMemoryDriver(t), newTestMemoryDriver(t)
+	d := NewMountDriver(root)
+	if err := d.Mount("/data", data); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, d, "/home.txt", "root")
+	writeFile(t, d, "/data/file.txt", "data")
+
+	for _, paths := range [][2]string{{"/home.txt", "/data/home.txt"}, {"/data/file.txt", "/file.txt"}} {
+		err := d.Rename(paths[0], paths[1])
+		if linkErr, ok := err.(*os.LinkError); !ok || linkErr.Err != ErrCrossMount {
+			t.Errorf("Rename %s to %s: %v, want ErrCrossMount", paths[0], paths[1], err)
+		}
+	}
+	if err := d.Rename("/data/file.txt", "/data/renamed.txt"); err != nil {
+		t.Errorf("rename inside mount: %v", err)
+	}
+	if got := readFile(data, "/renamed.txt"); got != "data" {
+		t.Errorf("renamed file in mounted driver: %q", got)
+	}
+}
+
+func TestUserMounts(t *testing.T) {
+	config := &FTPServConfig.ConfigStorage{FTPRootFolder: t.TempDir()}
+	for _, folder := range []string{"bob", "shared", "public"} {
+		if err := os.Mkdir(filepath.Join(config.FTPRootFolder, folder), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(config.FTPRootFolder, "public", "readme.txt"), []byte("read me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	user := &FTPAuth.User{UserName: "bob", Folder: "/bob", Mounts: []FTPAuth.Mount{
+		{Path: "/shared", Folder: "shared"},
+		{Path: "/pub", Folder: filepath.Join(config.FTPRootFolder, "public"), ReadOnly: true},
+	}}
+	d, err := NewDriver(config, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, d, "/shared/note.txt", "note")
+	if _, err := os.Stat(filepath.Join(config.FTPRootFolder, "shared", "note.txt")); err != nil {
+		t.Errorf("file written to mount: %v", err)
+	}
+	if got := readFile(d, "/pub/readme.txt"); got != "read me" {
+		t.Errorf("read-only mount: %q", got)
+	}
+	if _, err := d.Create("/pub/new.txt", false); !os.IsPermission(err) {
+		t.Errorf("Create in read-only mount: %v", err)
+	}
+	if fi, err := d.Stat("/pub/readme.txt"); err != nil || fi.Mode()&0222 != 0 {
+		t.Errorf("file of read-only mount is shown writable: %v, %v", fi, err)
+	}
+
+	user.Mounts = append(user.Mounts, FTPAuth.Mount{Path: "/missing", Folder: "missing"})
+	if _, err := NewDriver(config, user); err == nil {
+		t.Errorf("mount of missing folder succeeded")
+	}
+}
@@ -0,0 +1,373 @@
+// Per-user quotas of space and number of files
+package ftpfs
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/FTPServConfig"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Quota is usage of user root folder with its limits. Usage is computed on first use and updated by QuotaDriver,
+//it is shared by sessions of users with the same root folder. Versions and trash (VersionsFolder, TrashFolder)
+//are counted too. If PruneVersions is set, oldest versions are removed when change doesn't fit in quota
+type Quota struct {
+	//BytesLimit, FilesLimit - 0 - no limit
+	BytesLimit    int64
+	FilesLimit    int64
+	PruneVersions bool
+	usage         *quotaUsage
+	driver        Driver
+}
+
+//QuotaUsage is state of quota returned to client
+type QuotaUsage struct {
+	Bytes      int64
+	Files      int64
+	BytesLimit int64
+	FilesLimit int64
+	Computed   time.Time
+}
+
+type quotaUsage struct {
+	lock     sync.Mutex
+	bytes    int64
+	files    int64
+	computed time.Time
+}
+
+var quotaUsages = make(map[string]*quotaUsage)
+var quotaUsagesLock sync.Mutex
+
+//newQuotaForUser returns quota of user (nil if user has no limits) for root driver of user folder
+func newQuotaForUser(config *FTPServConfig.ConfigStorage, user *FTPAuth.User, root Driver) *Quota {
+	bytesLimit := quotaLimit(user.QuotaBytes, config.DefaultQuotaBytes)
+	filesLimit := quotaLimit(user.QuotaFiles, config.DefaultQuotaFiles)
+	if bytesLimit == 0 && filesLimit == 0 {
+		return nil
+	}
+	key := fmt.Sprint(strings.ToLower(strings.TrimSpace(config.Storage)), ":", userFolder(user))
+	quotaUsagesLock.Lock()
+	usage, ok := quotaUsages[key]
+	if !ok {
+		usage = new(quotaUsage)
+		quotaUsages[key] = usage
+	}
+	quotaUsagesLock.Unlock()
+	return &Quota{BytesLimit: bytesLimit, FilesLimit: filesLimit, PruneVersions: config.Versioning, usage: usage, driver: root}
+}
+
+//quotaLimit returns limit of user: 0 - server default, < 0 - no limit
+func quotaLimit(userLimit int64, defaultLimit int64) int64 {
+	if userLimit == 0 {
+		userLimit = defaultLimit
+	}
+	if userLimit < 0 {
+		return 0
+	}
+	return userLimit
+}
+
+//Usage returns usage of quota, it is computed if it was not
+func (q *Quota) Usage() (QuotaUsage, error) {
+	q.usage.lock.Lock()
+	defer q.usage.lock.Unlock()
+	if err := q.compute(false); err != nil {
+		return QuotaUsage{}, err
+	}
+	return QuotaUsage{Bytes: q.usage.bytes, Files: q.usage.files, BytesLimit: q.BytesLimit, FilesLimit: q.FilesLimit, Computed: q.usage.computed}, nil
+}
+
+//Recalculate computes usage again (after changes of folder made not through FTP)
+func (q *Quota) Recalculate() error {
+	q.usage.lock.Lock()
+	defer q.usage.lock.Unlock()
+	return q.compute(true)
+}
+
+//compute counts files of root folder if usage is not computed or force is set. Usage lock must be held
+func (q *Quota) compute(force bool) error {
+	if !force && !q.usage.computed.IsZero() {
+		return nil
+	}
+	bytes, files, err := treeUsage(q.driver, "/")
+	if err != nil {
+		return err
+	}
+	q.usage.bytes, q.usage.files, q.usage.computed = bytes, files, time.Now()
+	return nil
+}
+
+//treeUsage returns size and number of regular files of object (with content of directory)
+func treeUsage(driver Driver, objPath string) (int64, int64, error) {
+	fi, err := driver.Lstat(objPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	if fi.Mode().IsRegular() {
+		return fi.Size(), 1, nil
+	}
+	if !fi.IsDir() {
+		return 0, 0, nil
+	}
+	content, err := driver.ReadDir(objPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	var bytes, files int64
+	for _, child := range content {
+		if child.Mode().IsRegular() {
+			bytes += child.Size()
+			files++
+			continue
+		}
+		if child.IsDir() {
+			childBytes, childFiles, err := treeUsage(driver, path.Join(objPath, child.Name()))
+			if err != nil {
+				return 0, 0, err
+			}
+			bytes += childBytes
+			files += childFiles
+		}
+	}
+	return bytes, files, nil
+}
+
+//update changes usage by bytes and files. New bytes and files are checked with limits, versions are pruned
+//if they are exceeded, then ErrNoSpace is returned if they are still exceeded. Usage lock must be held
+func (q *Quota) update(bytes int64, files int64) error {
+	if err := q.compute(false); err != nil {
+		return err
+	}
+	if q.exceeded(bytes, files) && q.PruneVersions {
+		q.pruneVersions(bytes, files)
+	}
+	if q.exceeded(bytes, files) {
+		return ErrNoSpace
+	}
+	q.usage.bytes += bytes
+	q.usage.files += files
+	if q.usage.bytes < 0 {
+		q.usage.bytes = 0
+	}
+	if q.usage.files < 0 {
+		q.usage.files = 0
+	}
+	return nil
+}
+
+//exceeded reports if new bytes or files don't fit in limits. Usage lock must be held
+func (q *Quota) exceeded(bytes int64, files int64) bool {
+	if bytes > 0 && q.BytesLimit > 0 && q.usage.bytes+bytes > q.BytesLimit {
+		return true
+	}
+	return files > 0 && q.FilesLimit > 0 && q.usage.files+files > q.FilesLimit
+}
+
+//pruneVersions removes versions and deleted objects from oldest one until new bytes and files fit in limits.
+//Usage lock must be held
+func (q *Quota) pruneVersions(bytes int64, files int64) {
+	for _, versionPath := range versionPaths(q.driver) {
+		if !q.exceeded(bytes, files) {
+			return
+		}
+		versionBytes, versionFiles, err := treeUsage(q.driver, versionPath)
+		if err != nil || (versionBytes == 0 && versionFiles == 0) {
+			continue
+		}
+		if err := q.driver.RemoveAll(versionPath); err != nil {
+			continue
+		}
+		q.usage.bytes -= versionBytes
+		q.usage.files -= versionFiles
+	}
+}
+
+//versionPaths returns paths of versions in VersionsFolder and TrashFolder of driver from oldest to newest
+func versionPaths(driver Driver) []string {
+	var paths []string
+	var walk func(dirPath string)
+	walk = func(dirPath string) {
+		content, err := driver.ReadDir(dirPath)
+		if err != nil {
+			return
+		}
+		for _, fi := range content {
+			if _, err := time.Parse(versionIDFormat, fi.Name()); err == nil {
+				paths = append(paths, path.Join(dirPath, fi.Name()))
+			} else if fi.IsDir() {
+				walk(path.Join(dirPath, fi.Name()))
+			}
+		}
+	}
+	walk(VersionsFolder)
+	walk(TrashFolder)
+	sort.SliceStable(paths, func(i, j int) bool { return path.Base(paths[i]) < path.Base(paths[j]) })
+	return paths
+}
+
+//QuotaDriver enforces Quota on changes of Driver: writers return ErrNoSpace when space is exceeded,
+//new files are not created when number of files is exceeded
+type QuotaDriver struct {
+	Driver
+	Quota *Quota
+}
+
+//NewQuotaDriver returns driver with quota. Quota must be made for driver
+func NewQuotaDriver(driver Driver, quota *Quota) *QuotaDriver {
+	return &QuotaDriver{Driver: driver, Quota: quota}
+}
+
+//fileSize returns size of regular file (links are not followed), ok is false for other objects
+func (d *QuotaDriver) fileSize(path string) (int64, bool) {
+	fi, err := d.Driver.Lstat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return 0, false
+	}
+	return fi.Size(), true
+}
+
+//open opens writer counting new file and truncated bytes
+func (d *QuotaDriver) open(path string, truncatedTo int64, open func() (io.WriteCloser, error)) (io.WriteCloser, error) {
+	d.Quota.usage.lock.Lock()
+	defer d.Quota.usage.lock.Unlock()
+	size, exists := d.fileSize(path)
+	var bytes, files int64
+	if !exists {
+		files = 1
+	} else if truncatedTo >= 0 && truncatedTo < size {
+		bytes = truncatedTo - size
+	}
+	if err := d.Quota.update(0, files); err != nil {
+		return nil, err
+	}
+	file, err := open()
+	if err != nil {
+		d.Quota.update(0, -files)
+		return nil, err
+	}
+	d.Quota.update(bytes, 0)
+	return &quotaWriter{WriteCloser: file, quota: d.Quota}, nil
+}
+func (d *QuotaDriver) Create(path string, exclusive bool) (io.WriteCloser, error) {
+	return d.open(path, 0, func() (io.WriteCloser, error) { return d.Driver.Create(path, exclusive) })
+}
+func (d *QuotaDriver) Append(path string) (io.WriteCloser, error) {
+	return d.open(path, -1, func() (io.WriteCloser, error) { return d.Driver.Append(path) })
+}
+func (d *QuotaDriver) WriteAt(path string, offset int64) (io.WriteCloser, error) {
+	return d.open(path, offset, func() (io.WriteCloser, error) { return d.Driver.WriteAt(path, offset) })
+}
+func (d *QuotaDriver) Remove(path string) error {
+	d.Quota.usage.lock.Lock()
+	defer d.Quota.usage.lock.Unlock()
+	size, isFile := d.fileSize(path)
+	if err := d.Driver.Remove(path); err != nil {
+		return err
+	}
+	if isFile {
+		d.Quota.update(-size, -1)
+	}
+	return nil
+}
+func (d *QuotaDriver) RemoveAll(path string) error {
+	d.Quota.usage.lock.Lock()
+	defer d.Quota.usage.lock.Unlock()
+	bytes, files, usageErr := treeUsage(d.Driver, path)
+	if err := d.Driver.RemoveAll(path); err != nil {
+		//part of tree may be removed
+		d.Quota.compute(true)
+		return err
+	}
+	if usageErr == nil {
+		d.Quota.update(-bytes, -files)
+	}
+	return nil
+}
+
+//Rename counts file replaced by newPath
+func (d *QuotaDriver) Rename(oldPath string, newPath string) error {
+	d.Quota.usage.lock.Lock()
+	defer d.Quota.usage.lock.Unlock()
+	size, replaced := d.fileSize(newPath)
+	if err := d.Driver.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	if replaced && oldPath != newPath {
+		d.Quota.update(-size, -1)
+	}
+	return nil
+}
+func (d *QuotaDriver) CreateTimeSupported() bool {
+	setter, ok := d.Driver.(CreateTimeSetter)
+	return ok && setter.CreateTimeSupported()
+}
+func (d *QuotaDriver) SetCreateTime(path string, createTime time.Time) error {
+	setter, ok := d.Driver.(CreateTimeSetter)
+	if !ok {
+		return ErrNotSupported
+	}
+	return setter.SetCreateTime(path, createTime)
+}
+
+//quotaWriter counts written bytes, ErrNoSpace is returned when quota is exceeded
+type quotaWriter struct {
+	io.WriteCloser
+	quota *Quota
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	w.quota.usage.lock.Lock()
+	err := w.quota.update(int64(len(p)), 0)
+	w.quota.usage.lock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	written, err := w.WriteCloser.Write(p)
+	if written < len(p) {
+		w.quota.usage.lock.Lock()
+		w.quota.update(int64(written-len(p)), 0)
+		w.quota.usage.lock.Unlock()
+	}
+	return written, err
+}
+
+//discard passes dropping of atomic upload to driver writer
+func (w *quotaWriter) discard() {
+	if discarder, ok := w.WriteCloser.(uploadDiscarder); ok {
+		discarder.discard()
+		return
+	}
+	w.WriteCloser.Close()
+}
+
+//Quota returns quota usage of user, ok is false if user has no quota
+func (fsParams *FileSystem) Quota() (QuotaUsage, bool, error) {
+	if fsParams.quota == nil {
+		return QuotaUsage{}, false, nil
+	}
+	usage, err := fsParams.quota.Usage()
+	return usage, true, err
+}
+
+//RecalculateQuota computes quota usage of user again
+func (fsParams *FileSystem) RecalculateQuota() error {
+	if fsParams.quota == nil {
+		return nil
+	}
+	return fsParams.quota.Recalculate()
+}
+
+//quotaDriverIfNeeded wraps root driver of user with quota driver if user has limits
+func quotaDriverIfNeeded(config *FTPServConfig.ConfigStorage, user *FTPAuth.User, root Driver) (Driver, *Quota) {
+	quota := newQuotaForUser(config, user, root)
+	if quota == nil {
+		return root, nil
+	}
+	return NewQuotaDriver(root, quota), quota
+}
@@ -0,0 +1,131 @@
+package ftpfs
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestQuota(t *testing.T, bytesLimit int64, filesLimit int64) (*Quota, *MemoryDriver) {
+	memory, err := NewMemoryDriver(NewMemoryStorage(0, 0), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Quota{BytesLimit: bytesLimit, FilesLimit: filesLimit, usage: new(quotaUsage), driver: memory}, memory
+}
+
+func checkUsage(t *testing.T, quota *Quota, step string, bytes int64, files int64) {
+	t.Helper()
+	usage, err := quota.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Bytes != bytes || usage.Files != files {
+		t.Errorf("%s: usage %d bytes, %d files, want %d, %d", step, usage.Bytes, usage.Files, bytes, files)
+	}
+	//tracked usage is the same as computed one
+	if err := quota.Recalculate(); err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ = quota.Usage(); usage.Bytes != bytes || usage.Files != files {
+		t.Errorf("%s: computed usage %d bytes, %d files, want %d, %d", step, usage.Bytes, usage.Files, bytes, files)
+	}
+}
+
+func TestQuotaLimits(t *testing.T) {
+	quota, memory := newTestQuota(t, 100, 2)
+	d := NewQuotaDriver(memory, quota)
+	writeFile(t, d, "/a.txt", strings.Repeat("a", 60))
+	checkUsage(t, quota, "write", 60, 1)
+	file, err := d.Create("/b.txt", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte(strings.Repeat("b", 50))); err != ErrNoSpace {
+		t.Errorf("write over bytes limit returned %v", err)
+	}
+	file.Close()
+	if _, err := d.Create("/c.txt", false); err != ErrNoSpace {
+		t.Errorf("create over files limit returned %v", err)
+	}
+	writeFile(t, d, "/a.txt", "short")
+	checkUsage(t, quota, "overwrite", 5, 2)
+	if err := d.Rename("/a.txt", "/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, quota, "rename over file", 5, 1)
+	if err := d.Remove("/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, quota, "remove", 0, 0)
+}
+
+//TestQuotaVersions checks that versions and trash use quota
+func TestQuotaVersions(t *testing.T) {
+	quota, memory := newTestQuota(t, 1000, 10)
+	d := NewVersionDriver(NewQuotaDriver(memory, quota), 0, 0)
+	writeFile(t, d, "/a.txt", strings.Repeat("a", 60))
+	writeFile(t, d, "/a.txt", strings.Repeat("b", 70))
+	checkUsage(t, quota, "replace", 130, 2)
+	if err := d.Remove("/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, quota, "remove", 130, 2)
+	if err := d.Mkdir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, d, "/dir/c.txt", "c")
+	if err := d.RemoveAll("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, quota, "remove directory", 131, 3)
+	versions := d.Versions("/a.txt")
+	if len(versions) != 2 {
+		t.Fatalf("versions %+v", versions)
+	}
+	if err := d.Restore("/a.txt", versions[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, quota, "restore", 131, 3)
+
+	//versions are not removed without PruneVersions
+	quota.BytesLimit = 140
+	file, err := d.Create("/e.txt", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte(strings.Repeat("e", 10))); err != ErrNoSpace {
+		t.Errorf("write over quota returned %v", err)
+	}
+	file.Close()
+	checkUsage(t, quota, "write over quota", 131, 4)
+}
+
+//TestQuotaPruneVersions checks that oldest versions are removed when quota is reached
+func TestQuotaPruneVersions(t *testing.T) {
+	quota, memory := newTestQuota(t, 100, 3)
+	quota.PruneVersions = true
+	d := NewVersionDriver(NewQuotaDriver(memory, quota), 0, 0)
+	writeFile(t, d, "/a.txt", strings.Repeat("a", 60))
+	writeFile(t, d, "/a.txt", strings.Repeat("b", 30))
+	if err := d.Remove("/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, quota, "remove", 90, 2)
+	//oldest version is removed to free space
+	writeFile(t, d, "/b.txt", strings.Repeat("c", 20))
+	checkUsage(t, quota, "write over bytes limit", 50, 2)
+	if versions := versionsContent(d, "/a.txt"); !equalStrings(versions, []string{strings.Repeat("b", 30)}) {
+		t.Errorf("versions after pruning %q", versions)
+	}
+	writeFile(t, d, "/c.txt", "c")
+	writeFile(t, d, "/d.txt", "d")
+	checkUsage(t, quota, "create over files limit", 22, 3)
+	if versions := d.Versions("/a.txt"); len(versions) != 0 {
+		t.Errorf("versions after pruning %+v", versions)
+	}
+	//quota is exceeded without versions
+	if _, err := d.Create("/e.txt", false); err != ErrNoSpace {
+		t.Errorf("create over quota without versions returned %v", err)
+	}
+	checkUsage(t, quota, "create over quota", 22, 3)
+}
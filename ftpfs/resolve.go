@@ -0,0 +1,24 @@
+// Resolving of client paths to paths inside user root folder
+package ftpfs
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//ErrPathOutsideRoot is returned for paths leading out of user root folder (through symlinks)
+var ErrPathOutsideRoot = errors.New("Path is outside of user root folder")
+
+//VirtualPath returns cleaned absolute path as user sees it: relative paths are joined with working directory,
+//".." can't go above "/". Paths given to Driver are made with it
+func (fsParams *FileSystem) VirtualPath(clientPath string) string {
+	if filepath.Separator != '/' {
+		clientPath = strings.Replace(clientPath, string(filepath.Separator), "/", -1)
+	}
+	if !strings.HasPrefix(clientPath, "/") {
+		clientPath = path.Join(fsParams.WorkingDirectory(), clientPath)
+	}
+	return path.Clean(fsParams.checkForSlash(clientPath))
+}
@@ -0,0 +1,250 @@
+package ftpfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestVirtualPath(t *testing.T) {
+	fsParams := &FileSystem{FTPWorkingDirectory: "/pub/docs"}
+	tests := []struct {
+		clientPath  string
+		virtualPath string
+	}{
+		{"", "/pub/docs"},
+		{".", "/pub/docs"},
+		{"..", "/pub"},
+		{"../../../..", "/"},
+		{"a/../../../x", "/x"},
+		{"/../../etc/passwd", "/etc/passwd"},
+		{"/a/./b//c/", "/a/b/c"},
+		{"../../../../etc/../etc/passwd", "/etc/passwd"},
+		{"file.txt", "/pub/docs/file.txt"},
+	}
+	if filepath.Separator == '/' {
+		//backslash is usual character of name
+		tests = append(tests, struct {
+			clientPath  string
+			virtualPath string
+		}{`..\..\etc`, `/pub/docs/..\..\etc`})
+	} else {
+		tests = append(tests, struct {
+			clientPath  string
+			virtualPath string
+		}{`..\..\..\etc`, "/etc"})
+	}
+	for _, test := range tests {
+		if virtualPath := fsParams.VirtualPath(test.clientPath); virtualPath != test.virtualPath {
+			t.Errorf("VirtualPath(%q) = %q, want %q", test.clientPath, virtualPath, test.virtualPath)
+		}
+	}
+}
+
+//makeTraversalTree makes root folder of driver and folder outside of it:
+//
+//	base/outside/secret.txt
+//	base/root/dir/file.txt
+//	base/root/dir/up -> ../..          (base)
+//	base/root/in -> dir
+//	base/root/abs -> base/root/dir
+//	base/root/out -> base/outside
+//	base/root/outfile -> base/outside/secret.txt
+//	base/root/broken -> base/outside/new.txt
+//	base/root/loop1 -> loop2, base/root/loop2 -> loop1
+func makeTraversalTree(t *testing.T) (base string, driver *LocalDriver) {
+	base, err := ioutil.TempDir("", "ftpfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "dir"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "dir", "file.txt"), []byte("inside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	links := [][2]string{
+		{filepath.Join("..", ".."), filepath.Join(root, "dir", "up")},
+		{"dir", filepath.Join(root, "in")},
+		{filepath.Join(root, "dir"), filepath.Join(root, "abs")},
+		{outside, filepath.Join(root, "out")},
+		{filepath.Join(outside, "secret.txt"), filepath.Join(root, "outfile")},
+		{filepath.Join(outside, "new.txt"), filepath.Join(root, "broken")},
+		{"loop2", filepath.Join(root, "loop1")},
+		{"loop1", filepath.Join(root, "loop2")},
+	}
+	for _, link := range links {
+		if err := os.Symlink(link[0], link[1]); err != nil {
+			os.RemoveAll(base)
+			t.Skip("symlinks are not supported: ", err)
+		}
+	}
+	return base, NewLocalDriver(root)
+}
+
+//errAny - test expects some error, not particular one
+var errAny = &os.PathError{Op: "any"}
+
+func TestLocalDriverTraversal(t *testing.T) {
+	base, d := makeTraversalTree(t)
+	defer os.RemoveAll(base)
+	read := func(path string) error {
+		file, err := d.Open(path, 0)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		data, err := ioutil.ReadAll(file)
+		if err == nil && string(data) != "inside" {
+			t.Errorf("Open(%q) read %q", path, data)
+		}
+		return err
+	}
+	create := func(path string) error {
+		file, err := d.Create(path, false)
+		if err == nil {
+			file.Close()
+		}
+		return err
+	}
+	stat := func(path string) error {
+		_, err := d.Stat(path)
+		return err
+	}
+	lstat := func(path string) error {
+		_, err := d.Lstat(path)
+		return err
+	}
+	readDir := func(path string) error {
+		_, err := d.ReadDir(path)
+		return err
+	}
+	tests := []struct {
+		name string
+		op   func(path string) error
+		path string
+		err  error
+	}{
+		{"file", read, "/dir/file.txt", nil},
+		{"dot-dot chain", read, "/../outside/secret.txt", ErrPathOutsideRoot},
+		{"dot-dot inside path", read, "/dir/../../outside/secret.txt", ErrPathOutsideRoot},
+		{"dot-dot back inside", read, "/dir/../dir/file.txt", nil},
+		{"absolute path of host", read, filepath.ToSlash(filepath.Join(base, "outside", "secret.txt")), os.ErrNotExist},
+		{"backslashes", read, `/..\outside\secret.txt`, errAny},
+		{"NUL byte", read, "/dir/file.txt\x00", errAny},
+		{"NUL byte stat", stat, "/dir\x00/../../outside", errAny},
+		{"relative link inside", read, "/in/file.txt", nil},
+		{"absolute link inside", read, "/abs/file.txt", nil},
+		{"link to outside folder", read, "/out/secret.txt", ErrPathOutsideRoot},
+		{"link to outside file", read, "/outfile", ErrPathOutsideRoot},
+		{"link up from folder", read, "/dir/up/outside/secret.txt", ErrPathOutsideRoot},
+		{"link loop", read, "/loop1", errAny},
+		{"link loop stat", stat, "/loop1/file", errAny},
+		{"stat outside folder", stat, "/out", ErrPathOutsideRoot},
+		{"lstat of link itself", lstat, "/out", nil},
+		{"lstat through link", lstat, "/out/secret.txt", ErrPathOutsideRoot},
+		{"list outside folder", readDir, "/out", ErrPathOutsideRoot},
+		{"list through link up", readDir, "/dir/up", ErrPathOutsideRoot},
+		{"list inside link", readDir, "/in", nil},
+		{"create through broken link", create, "/broken", ErrPathOutsideRoot},
+		{"create in outside folder", create, "/out/new.txt", ErrPathOutsideRoot},
+		{"overwrite outside file", create, "/outfile", ErrPathOutsideRoot},
+		{"create in missing folder outside", create, "/out/a/b.txt", ErrPathOutsideRoot},
+		{"mkdir in outside folder", d.Mkdir, "/out/newdir", ErrPathOutsideRoot},
+		{"remove through link", d.Remove, "/out/secret.txt", ErrPathOutsideRoot},
+		{"remove all through link up", d.RemoveAll, "/dir/up/outside", ErrPathOutsideRoot},
+	}
+	for _, test := range tests {
+		err := test.op(test.path)
+		switch {
+		case test.err == errAny:
+			if err == nil {
+				t.Errorf("%s (%q): no error", test.name, test.path)
+			}
+		case test.err == os.ErrNotExist:
+			if !os.IsNotExist(err) {
+				t.Errorf("%s (%q): error %v, want not exist error", test.name, test.path, err)
+			}
+		case err != test.err:
+			t.Errorf("%s (%q): error %v, want %v", test.name, test.path, err, test.err)
+		}
+	}
+	if err := d.Rename("/dir/file.txt", "/out/moved.txt"); err != ErrPathOutsideRoot {
+		t.Errorf("rename into outside folder: error %v, want %v", err, ErrPathOutsideRoot)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(base, "outside", "secret.txt")); err != nil || string(data) != "secret" {
+		t.Errorf("outside file changed: %q, %v", data, err)
+	}
+	if _, err := os.Lstat(filepath.Join(base, "outside", "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("file created outside of root through broken link")
+	}
+	//links leading out may be removed, their targets stay
+	if err := d.Remove("/outfile"); err != nil {
+		t.Errorf("remove of link to outside file: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "outside", "secret.txt")); err != nil {
+		t.Errorf("link target removed: %v", err)
+	}
+}
+
+//TestLocalDriverSymlinkSwap swaps link between file inside and file outside root folder while it is opened
+func TestLocalDriverSymlinkSwap(t *testing.T) {
+	base, d := makeTraversalTree(t)
+	defer os.RemoveAll(base)
+	root := d.RootFolder
+	targets := []string{filepath.Join("dir", "file.txt"), filepath.Join(base, "outside", "secret.txt")}
+	if err := os.Symlink(targets[0], filepath.Join(root, "swap")); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			tmpLink := filepath.Join(root, "swap.tmp")
+			os.Remove(tmpLink)
+			if err := os.Symlink(targets[i%2], tmpLink); err != nil {
+				continue
+			}
+			os.Rename(tmpLink, filepath.Join(root, "swap"))
+		}
+	}()
+	opened := 0
+	for i := 0; i < 2000; i++ {
+		if file, err := d.Open("/swap", 0); err == nil {
+			data, _ := ioutil.ReadAll(file)
+			file.Close()
+			if string(data) == "secret" {
+				t.Errorf("file outside of root read through swapped link")
+				break
+			}
+			opened++
+		}
+		if file, err := d.Create("/swap", false); err == nil {
+			file.Close()
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if data, err := ioutil.ReadFile(filepath.Join(base, "outside", "secret.txt")); err != nil || string(data) != "secret" {
+		t.Errorf("file outside of root truncated through swapped link: %q, %v", data, err)
+	}
+	if opened == 0 {
+		t.Logf("link inside root was never opened")
+	}
+}
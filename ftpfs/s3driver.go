@@ -0,0 +1,804 @@
+// S3-compatible object storage driver
+package ftpfs
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/FTPServConfig"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//permissions shown for objects of bucket
+const (
+	s3DirMode  os.FileMode = os.ModeDir | 0755
+	s3FileMode os.FileMode = 0644
+)
+
+//S3MinPartSize is the smallest part of multipart upload allowed by S3 (except the last one)
+const S3MinPartSize = 5 << 20
+
+//s3MaxCopySize - objects up to it are copied with one CopyObject request (S3 limit is 5 GB),
+//bigger ones - with multipart copy in parts of s3CopyPartSize
+var s3MaxCopySize int64 = 5 << 30
+var s3CopyPartSize int64 = 1 << 30
+
+//S3Driver maps virtual paths to keys of bucket: "/dir/file" is Prefix + "dir/file". Directories are key prefixes,
+//empty directories are kept as "dir/" marker objects. S3 can't modify objects: APPE and REST + STOR
+//upload object again with its old content
+type S3Driver struct {
+	Endpoint  *url.URL
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	//PathStyle - bucket in path ("host/bucket/key", MinIO and other stand-ins), otherwise in host name
+	PathStyle bool
+	PartSize  int64
+	Client    *http.Client
+}
+
+//NewS3Driver returns driver for objects of bucket with keys starting with prefix
+func NewS3Driver(endpoint string, region string, bucket string, prefix string, accessKey string, secretKey string, pathStyle bool) (*S3Driver, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpointURL.Scheme != "http" && endpointURL.Scheme != "https" || len(endpointURL.Host) == 0 {
+		return nil, errors.New(fmt.Sprint("NewS3Driver: wrong endpoint \"", endpoint, "\""))
+	}
+	if len(bucket) == 0 {
+		return nil, errors.New("NewS3Driver: no bucket specified")
+	}
+	if len(region) == 0 {
+		region = "us-east-1"
+	}
+	prefix = strings.Trim(path.Clean(fmt.Sprint("/", prefix)), "/")
+	if len(prefix) != 0 {
+		prefix = fmt.Sprint(prefix, "/")
+	}
+	return &S3Driver{
+		Endpoint:  endpointURL,
+		Region:    region,
+		Bucket:    bucket,
+		Prefix:    prefix,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		PathStyle: pathStyle,
+		PartSize:  S3MinPartSize,
+		Client:    http.DefaultClient,
+	}, nil
+}
+
+//newS3DriverForUser makes driver for bucket from config, user folder is added to S3Prefix.
+//Keys are taken from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY if not set in config
+func newS3DriverForUser(config *FTPServConfig.ConfigStorage, user *FTPAuth.User) (Driver, error) {
+	accessKey, secretKey := config.S3AccessKey, config.S3SecretKey
+	if len(accessKey) == 0 {
+		accessKey, secretKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	driver, err := NewS3Driver(config.S3Endpoint, config.S3Region, config.S3Bucket, path.Join(config.S3Prefix, userFolder(user)), accessKey, secretKey, config.S3PathStyle)
+	if err != nil {
+		return nil, err
+	}
+	if config.S3PartSize > S3MinPartSize {
+		driver.PartSize = config.S3PartSize
+	}
+	return driver, nil
+}
+
+//key returns object key of virtual path. Key of "/" is Prefix
+func (d *S3Driver) key(virtualPath string) string {
+	return fmt.Sprint(d.Prefix, strings.TrimPrefix(path.Clean(fmt.Sprint("/", virtualPath)), "/"))
+}
+
+//dirPrefix returns prefix of keys in directory of virtual path
+func (d *S3Driver) dirPrefix(virtualPath string) string {
+	key := d.key(virtualPath)
+	if key == d.Prefix {
+		return key
+	}
+	return fmt.Sprint(key, "/")
+}
+
+func (d *S3Driver) Stat(path string) (os.FileInfo, error) {
+	key := d.key(path)
+	if key == d.Prefix {
+		return &driverFileInfo{name: "/", mode: s3DirMode, modTime: time.Now()}, nil
+	}
+	resp, err := d.do("HEAD", key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	name := objectName(key)
+	if resp.StatusCode == http.StatusOK {
+		modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return &driverFileInfo{name: name, size: resp.ContentLength, mode: s3FileMode, modTime: modTime}, nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return nil, s3ResponseError("stat", key, resp)
+	}
+	list, err := d.list(fmt.Sprint(key, "/"), "", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Contents) == 0 && len(list.CommonPrefixes) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: key, Err: os.ErrNotExist}
+	}
+	modTime := time.Now()
+	if len(list.Contents) != 0 && list.Contents[0].Key == fmt.Sprint(key, "/") {
+		modTime = list.Contents[0].LastModified
+	}
+	return &driverFileInfo{name: name, mode: s3DirMode, modTime: modTime}, nil
+}
+
+//Lstat is Stat: there are no links in object storage
+func (d *S3Driver) Lstat(path string) (os.FileInfo, error) {
+	return d.Stat(path)
+}
+func (d *S3Driver) ReadDir(path string) ([]os.FileInfo, error) {
+	if err := d.checkDir("readdir", path); err != nil {
+		return nil, err
+	}
+	prefix := d.dirPrefix(path)
+	list, err := d.list(prefix, "/", 0)
+	if err != nil {
+		return nil, err
+	}
+	content := make([]os.FileInfo, 0, len(list.Contents)+len(list.CommonPrefixes))
+	for _, object := range list.Contents {
+		name := strings.TrimPrefix(object.Key, prefix)
+		if len(name) == 0 {
+			//directory marker
+			continue
+		}
+		content = append(content, &driverFileInfo{name: name, size: object.Size, mode: s3FileMode, modTime: object.LastModified})
+	}
+	now := time.Now()
+	for _, dir := range list.CommonPrefixes {
+		name := strings.TrimSuffix(strings.TrimPrefix(dir.Prefix, prefix), "/")
+		if len(name) == 0 {
+			continue
+		}
+		content = append(content, &driverFileInfo{name: name, mode: s3DirMode, modTime: now})
+	}
+	sort.Slice(content, func(i, j int) bool { return content[i].Name() < content[j].Name() })
+	return content, nil
+}
+func (d *S3Driver) Readlink(path string) (string, error) {
+	return "", &os.PathError{Op: "readlink", Path: d.key(path), Err: errors.New("not a link")}
+}
+
+//Open streams object from GET request
+func (d *S3Driver) Open(path string, offset int64) (io.ReadCloser, error) {
+	key := d.key(path)
+	header := make(http.Header)
+	if offset > 0 {
+		header.Set("Range", fmt.Sprint("bytes=", offset, "-"))
+	}
+	resp, err := d.do("GET", key, nil, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		//offset is at end of object
+		resp.Body.Close()
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	return nil, s3ResponseError("open", key, resp)
+}
+func (d *S3Driver) Create(path string, exclusive bool) (io.WriteCloser, error) {
+	key := d.key(path)
+	if err := d.checkParent("create", path); err != nil {
+		return nil, err
+	}
+	fi, err := d.Stat(path)
+	if err == nil {
+		if exclusive {
+			return nil, &os.PathError{Op: "create", Path: key, Err: os.ErrExist}
+		}
+		if fi.IsDir() {
+			return nil, &os.PathError{Op: "create", Path: key, Err: errors.New("is a directory")}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return &s3Writer{driver: d, key: key}, nil
+}
+func (d *S3Driver) Append(path string) (io.WriteCloser, error) {
+	fi, err := d.Stat(path)
+	if os.IsNotExist(err) {
+		return d.Create(path, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.rewrite("append", path, fi.Size())
+}
+func (d *S3Driver) WriteAt(path string, offset int64) (io.WriteCloser, error) {
+	return d.rewrite("writeat", path, offset)
+}
+
+//rewrite starts new upload of object with first length bytes of its content
+func (d *S3Driver) rewrite(op string, path string, length int64) (io.WriteCloser, error) {
+	key := d.key(path)
+	fi, err := d.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, &os.PathError{Op: op, Path: key, Err: errors.New("is a directory")}
+	}
+	writer := &s3Writer{driver: d, key: key}
+	if length <= 0 {
+		return writer, nil
+	}
+	header := make(http.Header)
+	header.Set("Range", fmt.Sprint("bytes=0-", length-1))
+	resp, err := d.do("GET", key, nil, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, s3ResponseError(op, key, resp)
+	}
+	if _, err = io.Copy(writer, io.LimitReader(resp.Body, length)); err != nil {
+		writer.abort()
+		return nil, err
+	}
+	return writer, nil
+}
+func (d *S3Driver) Mkdir(path string) error {
+	key := d.key(path)
+	if err := d.checkParent("mkdir", path); err != nil {
+		return err
+	}
+	if _, err := d.Stat(path); err == nil {
+		return &os.PathError{Op: "mkdir", Path: key, Err: os.ErrExist}
+	}
+	return d.put(fmt.Sprint(key, "/"), nil)
+}
+func (d *S3Driver) Remove(path string) error {
+	key := d.key(path)
+	if key == d.Prefix {
+		return &os.PathError{Op: "remove", Path: key, Err: os.ErrPermission}
+	}
+	fi, err := d.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return d.delete(key)
+	}
+	list, err := d.list(fmt.Sprint(key, "/"), "", 2)
+	if err != nil {
+		return err
+	}
+	for _, object := range list.Contents {
+		if object.Key != fmt.Sprint(key, "/") {
+			return &os.PathError{Op: "remove", Path: key, Err: errors.New("directory not empty")}
+		}
+	}
+	if len(list.CommonPrefixes) != 0 {
+		return &os.PathError{Op: "remove", Path: key, Err: errors.New("directory not empty")}
+	}
+	return d.delete(fmt.Sprint(key, "/"))
+}
+func (d *S3Driver) RemoveAll(path string) error {
+	key := d.key(path)
+	if key == d.Prefix {
+		return &os.PathError{Op: "removeall", Path: key, Err: os.ErrPermission}
+	}
+	fi, err := d.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return d.delete(key)
+	}
+	list, err := d.list(fmt.Sprint(key, "/"), "", 0)
+	if err != nil {
+		return err
+	}
+	for _, object := range list.Contents {
+		if err := d.delete(object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Rename copies objects to new keys and removes old ones
+func (d *S3Driver) Rename(oldPath string, newPath string) error {
+	oldKey, newKey := d.key(oldPath), d.key(newPath)
+	if oldKey == d.Prefix || newKey == d.Prefix {
+		return &os.PathError{Op: "rename", Path: oldKey, Err: os.ErrPermission}
+	}
+	fi, err := d.Stat(oldPath)
+	if err != nil {
+		return err
+	}
+	if err := d.checkParent("rename", newPath); err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		if err := d.copy(oldKey, newKey, fi.Size()); err != nil {
+			return err
+		}
+		return d.delete(oldKey)
+	}
+	oldPrefix, newPrefix := fmt.Sprint(oldKey, "/"), fmt.Sprint(newKey, "/")
+	if strings.HasPrefix(newPrefix, oldPrefix) {
+		return &os.PathError{Op: "rename", Path: newKey, Err: errors.New("can't move directory into itself")}
+	}
+	list, err := d.list(oldPrefix, "", 0)
+	if err != nil {
+		return err
+	}
+	for _, object := range list.Contents {
+		if err := d.copy(object.Key, fmt.Sprint(newPrefix, strings.TrimPrefix(object.Key, oldPrefix)), object.Size); err != nil {
+			return err
+		}
+	}
+	for _, object := range list.Contents {
+		if err := d.delete(object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Chtimes is not supported: modification time of object is time of its upload
+func (d *S3Driver) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return ErrNotSupported
+}
+
+//checkDir checks that virtual path is a directory
+func (d *S3Driver) checkDir(op string, path string) error {
+	fi, err := d.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: op, Path: d.key(path), Err: errors.New("not a directory")}
+	}
+	return nil
+}
+
+//checkParent checks that directory of virtual path exists, as it must on disk
+func (d *S3Driver) checkParent(op string, virtualPath string) error {
+	parent := path.Dir(path.Clean(fmt.Sprint("/", virtualPath)))
+	if parent == "/" {
+		return nil
+	}
+	return d.checkDir(op, parent)
+}
+
+//objectName returns last part of key
+func objectName(key string) string {
+	return path.Base(strings.TrimSuffix(key, "/"))
+}
+
+type s3Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+type s3Prefix struct {
+	Prefix string
+}
+
+//s3ListResult is ListObjectsV2 reply
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []s3Object
+	CommonPrefixes        []s3Prefix
+}
+
+//list returns objects with prefix (all pages if maxKeys is 0). With delimiter "/" objects of subdirectories
+//are returned as CommonPrefixes
+func (d *S3Driver) list(prefix string, delimiter string, maxKeys int) (*s3ListResult, error) {
+	result := &s3ListResult{}
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if len(delimiter) != 0 {
+			query.Set("delimiter", delimiter)
+		}
+		if maxKeys > 0 {
+			query.Set("max-keys", strconv.Itoa(maxKeys))
+		}
+		if len(token) != 0 {
+			query.Set("continuation-token", token)
+		}
+		resp, err := d.do("GET", "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, s3ResponseError("list", prefix, resp)
+		}
+		page := &s3ListResult{}
+		err = xml.NewDecoder(resp.Body).Decode(page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		result.Contents = append(result.Contents, page.Contents...)
+		result.CommonPrefixes = append(result.CommonPrefixes, page.CommonPrefixes...)
+		if maxKeys > 0 || !page.IsTruncated || len(page.NextContinuationToken) == 0 {
+			return result, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+func (d *S3Driver) put(key string, data []byte) error {
+	resp, err := d.do("PUT", key, nil, nil, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3ResponseError("put", key, resp)
+	}
+	return nil
+}
+func (d *S3Driver) delete(key string) error {
+	resp, err := d.do("DELETE", key, nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3ResponseError("delete", key, resp)
+	}
+	return nil
+}
+
+//copy copies object of size inside bucket: with CopyObject up to s3MaxCopySize, with multipart copy if bigger
+func (d *S3Driver) copy(srcKey string, dstKey string, size int64) error {
+	header := make(http.Header)
+	header.Set("x-amz-copy-source", fmt.Sprint("/", d.Bucket, "/", s3EscapePath(srcKey)))
+	if size > s3MaxCopySize {
+		return d.copyParts(srcKey, dstKey, size, header)
+	}
+	resp, err := d.do("PUT", dstKey, nil, header, nil, 0)
+	if err != nil {
+		return err
+	}
+	return s3CheckReply("copy", dstKey, resp, nil)
+}
+
+//copyParts copies object with UploadPartCopy requests of s3CopyPartSize ranges
+func (d *S3Driver) copyParts(srcKey string, dstKey string, size int64, header http.Header) error {
+	w := &s3Writer{driver: d, key: dstKey}
+	if err := w.start(); err != nil {
+		return err
+	}
+	for first := int64(0); first < size; first += s3CopyPartSize {
+		last := first + s3CopyPartSize - 1
+		if last >= size {
+			last = size - 1
+		}
+		number := len(w.parts) + 1
+		query := url.Values{}
+		query.Set("partNumber", strconv.Itoa(number))
+		query.Set("uploadId", w.uploadID)
+		header.Set("x-amz-copy-source-range", fmt.Sprint("bytes=", first, "-", last))
+		resp, err := d.do("PUT", dstKey, query, header, nil, 0)
+		if err != nil {
+			w.abort()
+			return err
+		}
+		reply := struct{ ETag string }{}
+		if err := s3CheckReply("copy", dstKey, resp, &reply); err != nil {
+			w.abort()
+			return err
+		}
+		w.parts = append(w.parts, s3Part{PartNumber: number, ETag: reply.ETag})
+	}
+	if err := w.complete(); err != nil {
+		w.abort()
+		return err
+	}
+	return nil
+}
+
+//s3Error is error reply of S3
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+}
+
+//s3ResponseError makes error of unexpected reply and closes its body
+func s3ResponseError(op string, key string, resp *http.Response) error {
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return &os.PathError{Op: op, Path: key, Err: os.ErrNotExist}
+	case http.StatusForbidden:
+		return &os.PathError{Op: op, Path: key, Err: os.ErrPermission}
+	}
+	reply := s3Error{}
+	xml.NewDecoder(resp.Body).Decode(&reply)
+	return &os.PathError{Op: op, Path: key, Err: errors.New(fmt.Sprint("S3 error: ", resp.Status, " ", reply.Code, " ", reply.Message))}
+}
+
+//s3CheckReply decodes reply to v and closes its body. S3 may return error in body of "200 OK" reply
+//(CopyObject, CompleteMultipartUpload)
+func s3CheckReply(op string, key string, resp *http.Response, v interface{}) error {
+	if resp.StatusCode != http.StatusOK {
+		return s3ResponseError(op, key, resp)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	reply := s3Error{}
+	if xml.Unmarshal(body, &reply) == nil {
+		return &os.PathError{Op: op, Path: key, Err: errors.New(fmt.Sprint("S3 error: ", reply.Code, " ", reply.Message))}
+	}
+	if v == nil {
+		return nil
+	}
+	return xml.Unmarshal(body, v)
+}
+
+//s3Writer uploads object: small objects with one PUT, bigger with multipart upload of PartSize parts.
+//Object appears in bucket on Close
+type s3Writer struct {
+	driver   *S3Driver
+	key      string
+	buffer   bytes.Buffer
+	uploadID string
+	parts    []s3Part
+	err      error
+}
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buffer.Write(p)
+	for int64(w.buffer.Len()) >= w.driver.PartSize {
+		if w.err = w.uploadPart(w.buffer.Next(int(w.driver.PartSize))); w.err != nil {
+			return 0, w.err
+		}
+	}
+	return len(p), nil
+}
+func (w *s3Writer) Close() error {
+	if w.err != nil {
+		w.abort()
+		return w.err
+	}
+	if len(w.uploadID) == 0 {
+		return w.driver.put(w.key, w.buffer.Bytes())
+	}
+	if w.buffer.Len() > 0 {
+		if err := w.uploadPart(w.buffer.Bytes()); err != nil {
+			w.abort()
+			return err
+		}
+	}
+	if err := w.complete(); err != nil {
+		w.abort()
+		return err
+	}
+	return nil
+}
+//start initiates multipart upload
+func (w *s3Writer) start() error {
+	query := url.Values{}
+	query.Set("uploads", "")
+	resp, err := w.driver.do("POST", w.key, query, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	reply := struct{ UploadId string }{}
+	if err := s3CheckReply("upload", w.key, resp, &reply); err != nil {
+		return err
+	}
+	if len(reply.UploadId) == 0 {
+		return errors.New(fmt.Sprint("S3 error: no upload id for ", w.key))
+	}
+	w.uploadID = reply.UploadId
+	return nil
+}
+func (w *s3Writer) uploadPart(data []byte) error {
+	if len(w.uploadID) == 0 {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	number := len(w.parts) + 1
+	query := url.Values{}
+	query.Set("partNumber", strconv.Itoa(number))
+	query.Set("uploadId", w.uploadID)
+	resp, err := w.driver.do("PUT", w.key, query, nil, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3ResponseError("upload", w.key, resp)
+	}
+	w.parts = append(w.parts, s3Part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	return nil
+}
+func (w *s3Writer) complete() error {
+	request := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: w.parts}
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	query.Set("uploadId", w.uploadID)
+	resp, err := w.driver.do("POST", w.key, query, nil, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	return s3CheckReply("upload", w.key, resp, nil)
+}
+
+//discard drops upload of atomic upload temporary file, nothing is stored in bucket
+func (w *s3Writer) discard() {
+	w.abort()
+}
+
+//abort cancels multipart upload, so its parts are not kept (and paid for) in bucket
+func (w *s3Writer) abort() {
+	if len(w.uploadID) == 0 {
+		return
+	}
+	query := url.Values{}
+	query.Set("uploadId", w.uploadID)
+	if resp, err := w.driver.do("DELETE", w.key, query, nil, nil, 0); err == nil {
+		resp.Body.Close()
+	}
+	w.uploadID = ""
+}
+
+//do sends signed request for object key (bucket if key is empty)
+func (d *S3Driver) do(method string, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	requestURL := *d.Endpoint
+	objectPath := fmt.Sprint("/", key)
+	if d.PathStyle {
+		objectPath = fmt.Sprint("/", d.Bucket, objectPath)
+	} else {
+		requestURL.Host = fmt.Sprint(d.Bucket, ".", requestURL.Host)
+	}
+	requestURL.Path = objectPath
+	requestURL.RawPath = s3EscapePath(objectPath)
+	requestURL.RawQuery = s3CanonicalQuery(query)
+	req, err := http.NewRequest(method, requestURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if len(d.AccessKey) != 0 {
+		d.sign(req, time.Now())
+	}
+	return d.Client.Do(req)
+}
+
+//sign adds AWS Signature Version 4 to request. Payload is not signed (UNSIGNED-PAYLOAD) so data is streamed
+func (d *S3Driver) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	if len(req.Header.Get("x-amz-content-sha256")) == 0 {
+		req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	}
+	signed := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-type" || name == "content-md5" {
+			signed[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders = fmt.Sprint(canonicalHeaders, name, ":", signed[name], "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonicalHeaders, signedHeaders, req.Header.Get("x-amz-content-sha256")}, "\n")
+	scope := fmt.Sprint(date, "/", d.Region, "/s3/aws4_request")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+	signingKey := hmacSHA256([]byte(fmt.Sprint("AWS4", d.SecretKey)), date)
+	for _, part := range []string{d.Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprint("AWS4-HMAC-SHA256 Credential=", d.AccessKey, "/", scope, ", SignedHeaders=", signedHeaders, ", Signature=", signature))
+}
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+//s3Escape encodes string as SigV4 requires: everything except A-Z, a-z, 0-9, "-", ".", "_", "~"
+func s3Escape(value string, keepSlash bool) string {
+	escaped := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' || c == '/' && keepSlash {
+			escaped = append(escaped, c)
+			continue
+		}
+		escaped = append(escaped, fmt.Sprintf("%%%02X", c)...)
+	}
+	return string(escaped)
+}
+func s3EscapePath(objectPath string) string {
+	return s3Escape(objectPath, true)
+}
+
+//s3CanonicalQuery makes query string sorted by parameter names (and values) with SigV4 encoding
+func s3CanonicalQuery(query url.Values) string {
+	type param struct{ name, value string }
+	params := make([]param, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, param{s3Escape(name, false), s3Escape(value, false)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].name != params[j].name {
+			return params[i].name < params[j].name
+		}
+		return params[i].value < params[j].value
+	})
+	pairs := make([]string, 0, len(params))
+	for _, p := range params {
+		pairs = append(pairs, fmt.Sprint(p.name, "=", p.value))
+	}
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,79 @@
+// Modification and creation times (MDTM, MFMT, MFCT)
+package ftpfs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//ftpTimeLayout is time-val of RFC 3659: YYYYMMDDHHMMSS in UTC
+const ftpTimeLayout = "20060102150405"
+
+var ErrNotSupported = errors.New("Operation not supported by file system")
+
+//FormatFTPTime formats time as RFC 3659 time-val
+func FormatFTPTime(t time.Time) string {
+	return t.UTC().Format(ftpTimeLayout)
+}
+
+//ParseFTPTime parses RFC 3659 time-val with optional fraction of second (YYYYMMDDHHMMSS[.sss])
+func ParseFTPTime(value string) (time.Time, error) {
+	fraction := ""
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		value, fraction = value[:i], value[i+1:]
+	}
+	t, err := time.ParseInLocation(ftpTimeLayout, value, time.UTC)
+	if err != nil {
+		return time.Time{}, errors.New(fmt.Sprint("Wrong time value: ", value))
+	}
+	if len(fraction) != 0 {
+		nsec, err := time.ParseDuration(fmt.Sprint("0.", fraction, "s"))
+		if err != nil {
+			return time.Time{}, errors.New(fmt.Sprint("Wrong time fraction: ", fraction))
+		}
+		t = t.Add(nsec)
+	}
+	return t, nil
+}
+
+//CreateTimeSupported reports if file creation time may be set (MFCT)
+func (fsParams *FileSystem) CreateTimeSupported() bool {
+	setter, ok := fsParams.Driver.(CreateTimeSetter)
+	return ok && setter.CreateTimeSupported()
+}
+
+//MDTM returns modification time of file
+func (fsParams *FileSystem) MDTM(fileName string) (time.Time, error) {
+	fi, err := fsParams.Driver.Stat(fsParams.VirtualPath(fileName))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if fi.IsDir() {
+		return time.Time{}, errors.New("Is a folder")
+	}
+	return fi.ModTime(), nil
+}
+
+//SetModTime sets modification time of file or directory (MFMT)
+func (fsParams *FileSystem) SetModTime(path string, modTime time.Time) error {
+	path = fsParams.VirtualPath(path)
+	if _, err := fsParams.Driver.Stat(path); err != nil {
+		return err
+	}
+	return fsParams.Driver.Chtimes(path, modTime, modTime)
+}
+
+//SetCreateTime sets creation time of file or directory (MFCT). Not all file systems allow it
+func (fsParams *FileSystem) SetCreateTime(path string, createTime time.Time) error {
+	setter, ok := fsParams.Driver.(CreateTimeSetter)
+	if !ok || !setter.CreateTimeSupported() {
+		return ErrNotSupported
+	}
+	path = fsParams.VirtualPath(path)
+	if _, err := fsParams.Driver.Stat(path); err != nil {
+		return err
+	}
+	return setter.SetCreateTime(path, createTime)
+}
@@ -0,0 +1,69 @@
+package ftpfs
+
+import (
+	"FTPServ/FTPServConfig"
+	"testing"
+	"time"
+)
+
+func TestParseFTPTime(t *testing.T) {
+	tests := []struct {
+		value string
+		time  time.Time
+		ok    bool
+	}{
+		{"20210304050607", time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC), true},
+		{"20210304050607.5", time.Date(2021, 3, 4, 5, 6, 7, 500000000, time.UTC), true},
+		{"20210304050607.123", time.Date(2021, 3, 4, 5, 6, 7, 123000000, time.UTC), true},
+		{"20210304050607.", time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC), true},
+		{"", time.Time{}, false},
+		{"2021030405060", time.Time{}, false},
+		{"202103040506070", time.Time{}, false},
+		{"20211304050607", time.Time{}, false},
+		{"2021-03-04 05:06", time.Time{}, false},
+		{"20210304050607.x", time.Time{}, false},
+		{"20210304050607.-5", time.Time{}, false},
+		{"20210304050607.5s", time.Time{}, false},
+	}
+	for _, test := range tests {
+		parsed, err := ParseFTPTime(test.value)
+		if (err == nil) != test.ok || !parsed.Equal(test.time) {
+			t.Errorf("ParseFTPTime(%q) = %v, %v, want %v", test.value, parsed, err, test.time)
+		}
+	}
+	local := time.Date(2021, 3, 4, 7, 6, 7, 0, time.FixedZone("UTC+2", 2*3600))
+	if value := FormatFTPTime(local); value != "20210304050607" {
+		t.Errorf("FormatFTPTime(%v) = %q", local, value)
+	}
+}
+
+func TestSetTimes(t *testing.T) {
+	fsParams := newTestFileSystem(t, &FTPServConfig.ConfigStorage{})
+	store(t, fsParams, "file.txt", "data", 0)
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := fsParams.SetModTime("file.txt", modTime); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := fsParams.MDTM("file.txt"); err != nil || !got.Equal(modTime) {
+		t.Errorf("MDTM after SetModTime: %v, %v", got, err)
+	}
+	if err := fsParams.SetModTime("missing.txt", modTime); err == nil {
+		t.Errorf("SetModTime of missing file succeeded")
+	}
+	if err := fsParams.MakeDir("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsParams.MDTM("dir"); err == nil {
+		t.Errorf("MDTM of folder succeeded")
+	}
+
+	if createTimeSupported {
+		t.Skip("creation time is supported by local file system")
+	}
+	if fsParams.CreateTimeSupported() {
+		t.Errorf("CreateTimeSupported is set")
+	}
+	if err := fsParams.SetCreateTime("file.txt", modTime); err != ErrNotSupported {
+		t.Errorf("SetCreateTime: %v, want ErrNotSupported", err)
+	}
+}
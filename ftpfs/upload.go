@@ -0,0 +1,159 @@
+// Atomic uploads through temporary files
+package ftpfs
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/FTPServConfig"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+//Temporary files of atomic uploads are named PartialUploadPrefix + file name + random part + PartialUploadSuffix
+const (
+	PartialUploadPrefix = ".ftpupload."
+	PartialUploadSuffix = ".part"
+)
+
+//Aborter is implemented by uploads which drop received data if transfer failed
+type Aborter interface {
+	Abort() error
+}
+
+//uploadDiscarder is implemented by driver writers able to drop data instead of storing it on Close
+type uploadDiscarder interface {
+	discard()
+}
+
+//AbortUpload finishes upload after failed transfer. Uploads without Abort are closed, so received data is kept (for REST)
+func AbortUpload(file io.WriteCloser) error {
+	if aborter, ok := file.(Aborter); ok {
+		return aborter.Abort()
+	}
+	return file.Close()
+}
+
+//atomicUpload writes file to temporary file in the same directory, file is moved to its place on Close
+type atomicUpload struct {
+	io.WriteCloser
+	driver   Driver
+	tempPath string
+	filePath string
+}
+
+//newAtomicUpload creates temporary file for upload of filePath
+func newAtomicUpload(driver Driver, filePath string) (*atomicUpload, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	tempPath := path.Join(path.Dir(filePath), fmt.Sprint(PartialUploadPrefix, path.Base(filePath), ".", hex.EncodeToString(random), PartialUploadSuffix))
+	file, err := driver.Create(tempPath, true)
+	if err != nil {
+		return nil, err
+	}
+	return &atomicUpload{WriteCloser: file, driver: driver, tempPath: tempPath, filePath: filePath}, nil
+}
+
+//Close stores temporary file and moves it to its place. Temporary file is removed on errors
+func (u *atomicUpload) Close() error {
+	if err := u.WriteCloser.Close(); err != nil {
+		u.driver.Remove(u.tempPath)
+		return err
+	}
+	if err := u.driver.Rename(u.tempPath, u.filePath); err != nil {
+		u.driver.Remove(u.tempPath)
+		return err
+	}
+	return nil
+}
+
+//Abort removes temporary file, file at its place is not changed
+func (u *atomicUpload) Abort() error {
+	if discarder, ok := u.WriteCloser.(uploadDiscarder); ok {
+		discarder.discard()
+	} else {
+		u.WriteCloser.Close()
+	}
+	if err := u.driver.Remove(u.tempPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//isPartialUpload reports if name is name of atomic upload temporary file
+func isPartialUpload(name string) bool {
+	return strings.HasPrefix(name, PartialUploadPrefix) && strings.HasSuffix(name, PartialUploadSuffix)
+}
+
+//CleanPartialUploads removes temporary files of atomic uploads older than PartialUploadsMaxAge minutes
+//(left by server stopped while receiving files) from storage and from mounts of users.
+//Returns number of removed files
+func CleanPartialUploads(config *FTPServConfig.ConfigStorage, users *FTPAuth.Users) (int, error) {
+	name := strings.ToLower(strings.TrimSpace(config.Storage))
+	if len(name) == 0 {
+		name = DefaultStorage
+	}
+	driver, err := newStorageDriver(name, config, &FTPAuth.User{Folder: "/"})
+	if err != nil {
+		return 0, err
+	}
+	before := time.Now().Add(-time.Duration(config.PartialUploadsMaxAge) * time.Minute)
+	removed, err := cleanPartialUploads(driver, "/", before)
+	if err != nil || users == nil {
+		return removed, err
+	}
+	cleaned := make(map[FTPAuth.Mount]bool)
+	for _, user := range users.Users {
+		for _, mount := range user.Mounts {
+			mount.Path, mount.ReadOnly = "", false
+			if cleaned[mount] {
+				continue
+			}
+			cleaned[mount] = true
+			driver, err := newMountedDriver(config, mount)
+			if err != nil {
+				continue
+			}
+			count, err := cleanPartialUploads(driver, "/", before)
+			removed += count
+			if err != nil {
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+//cleanPartialUploads removes temporary files modified before time from dirPath and its subdirectories
+func cleanPartialUploads(driver Driver, dirPath string, before time.Time) (int, error) {
+	content, err := driver.ReadDir(dirPath)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, fi := range content {
+		objPath := path.Join(dirPath, fi.Name())
+		if fi.IsDir() {
+			count, err := cleanPartialUploads(driver, objPath, before)
+			removed += count
+			if err != nil {
+				return removed, err
+			}
+			continue
+		}
+		if !fi.Mode().IsRegular() || !isPartialUpload(fi.Name()) || fi.ModTime().After(before) {
+			continue
+		}
+		if err := driver.Remove(objPath); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,148 @@
+package ftpfs
+
+import (
+	"FTPServ/FTPAuth"
+	"FTPServ/FTPServConfig"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//partialUploads returns names of temporary upload files in folder
+func partialUploads(t *testing.T, folder string) []string {
+	t.Helper()
+	content, err := ioutil.ReadDir(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range content {
+		if isPartialUpload(fi.Name()) {
+			names = append(names, fi.Name())
+		}
+	}
+	return names
+}
+
+func TestAtomicUpload(t *testing.T) {
+	fsParams := newTestFileSystem(t, &FTPServConfig.ConfigStorage{AtomicUploads: true, OverwritePolicy: OverwritePolicyOverwrite})
+	root := fsParams.FTPRootFolder
+
+	//file is not visible until upload is complete
+	file, _, err := fsParams.STOR("new.txt", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(file, "new data")
+	if fsParams.FileExists("new.txt") {
+		t.Errorf("file of running upload is visible")
+	}
+	if names := partialUploads(t, root); len(names) != 1 {
+		t.Errorf("temporary files of running upload: %q", names)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data := retrieve(t, fsParams, "new.txt", 0); data != "new data" {
+		t.Errorf("uploaded file: %q", data)
+	}
+	if names := partialUploads(t, root); len(names) != 0 {
+		t.Errorf("temporary files left after upload: %q", names)
+	}
+
+	//failed upload drops temporary file and keeps replaced file
+	file, _, err = fsParams.STOR("new.txt", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(file, "partial")
+	if err := AbortUpload(file); err != nil {
+		t.Fatal(err)
+	}
+	if data := retrieve(t, fsParams, "new.txt", 0); data != "new data" {
+		t.Errorf("file after failed upload: %q", data)
+	}
+	file, _, err = fsParams.STOR("failed.txt", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(file, "partial")
+	AbortUpload(file)
+	if fsParams.FileExists("failed.txt") {
+		t.Errorf("file of failed upload exists")
+	}
+	if names := partialUploads(t, root); len(names) != 0 {
+		t.Errorf("temporary files left after failed uploads: %q", names)
+	}
+	content, err := fsParams.LIST("", ListOptions{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{".", "..", "new.txt"}; !equalStrings(content, want) {
+		t.Errorf("folder after uploads: %q, want %q", content, want)
+	}
+}
+
+func TestAbortUploadKeepsResumableData(t *testing.T) {
+	fsParams := newTestFileSystem(t, &FTPServConfig.ConfigStorage{})
+	file, _, err := fsParams.STOR("file.txt", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(file, "received")
+	if err := AbortUpload(file); err != nil {
+		t.Fatal(err)
+	}
+	if data := retrieve(t, fsParams, "file.txt", 0); data != "received" {
+		t.Errorf("data of failed upload without AtomicUploads: %q", data)
+	}
+}
+
+func TestCleanPartialUploads(t *testing.T) {
+	config := &FTPServConfig.ConfigStorage{FTPRootFolder: t.TempDir(), PartialUploadsMaxAge: 60}
+	shared := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	files := []struct {
+		path    string
+		old     bool
+		removed bool
+	}{
+		{filepath.Join(config.FTPRootFolder, "bob", PartialUploadPrefix+"a.txt.1"+PartialUploadSuffix), true, true},
+		{filepath.Join(config.FTPRootFolder, "bob", "sub", PartialUploadPrefix+"b.txt.2"+PartialUploadSuffix), true, true},
+		{filepath.Join(config.FTPRootFolder, "bob", PartialUploadPrefix+"c.txt.3"+PartialUploadSuffix), false, false},
+		{filepath.Join(config.FTPRootFolder, "bob", PartialUploadPrefix+"d.txt"), true, false},
+		{filepath.Join(config.FTPRootFolder, "bob", "e.txt"+PartialUploadSuffix), true, false},
+		{filepath.Join(shared, PartialUploadPrefix+"f.txt.4"+PartialUploadSuffix), true, true},
+		{filepath.Join(shared, "g.txt"), true, false},
+	}
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file.path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file.path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if file.old {
+			os.Chtimes(file.path, old, old)
+		}
+	}
+	users := &FTPAuth.Users{Users: []FTPAuth.User{
+		{UserName: "bob", Folder: "/bob", Mounts: []FTPAuth.Mount{{Path: "/shared", Folder: shared}}},
+		{UserName: "alice", Folder: "/alice", Mounts: []FTPAuth.Mount{{Path: "/team", Folder: shared, ReadOnly: true}}},
+	}}
+	removed, err := CleanPartialUploads(config, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 3 {
+		t.Errorf("%d files removed, want 3", removed)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file.path); os.IsNotExist(err) != file.removed {
+			t.Errorf("%s: removed - %v, want %v", file.path, os.IsNotExist(err), file.removed)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+// Versions of replaced files and recycle bin of deleted ones
+package ftpfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Previous versions of replaced files are kept in VersionsFolder, deleted objects - in TrashFolder.
+//Versions of "/dir/name" are "/.versions/dir/name/ID" ("/.trash/dir/name/ID"), ID is UTC time of change
+const (
+	VersionsFolder  = "/.versions"
+	TrashFolder     = "/.trash"
+	versionIDFormat = "20060102150405.000000"
+)
+
+//ErrVersioningDisabled is returned by version commands if versions are not kept
+var ErrVersioningDisabled = errors.New("Versioning is disabled")
+
+//VersionDriver keeps previous versions of files replaced (STOR, RNTO), changed in place (APPE, REST + STOR)
+//and deleted objects of Driver.
+//Keep versions of each path are kept (0 - all), versions older than MaxAge are removed (0 - no limit).
+//VersionsFolder and TrashFolder are read-only.
+//Versions are real folders of Driver, not a virtual view: version is kept by rename (no copy on the same mount),
+//versions survive server restart and are listed and downloaded as other files.
+//Clients can't change them, as any write to these folders is refused here
+type VersionDriver struct {
+	Driver
+	Keep   int
+	MaxAge time.Duration
+}
+
+//Version is previous version of object
+type Version struct {
+	ID      string
+	Time    time.Time
+	Size    int64
+	IsDir   bool
+	Deleted bool
+}
+
+//NewVersionDriver returns driver keeping versions of objects of driver
+func NewVersionDriver(driver Driver, keep int, maxAge time.Duration) *VersionDriver {
+	return &VersionDriver{Driver: driver, Keep: keep, MaxAge: maxAge}
+}
+
+//inVersionsArea reports if virtual path is in (or is) VersionsFolder or TrashFolder.
+//Case is ignored: "/.VERSIONS" is the same folder on case-insensitive file systems
+func inVersionsArea(virtualPath string) bool {
+	virtualPath = strings.ToLower(virtualPath)
+	for _, folder := range []string{VersionsFolder, TrashFolder} {
+		if virtualPath == folder || strings.HasPrefix(virtualPath, fmt.Sprint(folder, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+//readOnly returns error for changes in versions area
+func (d *VersionDriver) readOnly(op string, path string) error {
+	if inVersionsArea(path) {
+		return &os.PathError{Op: op, Path: path, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+//isFile reports if path is a regular file (links are not followed)
+func (d *VersionDriver) isFile(path string) bool {
+	fi, err := d.Driver.Lstat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+func (d *VersionDriver) Stat(path string) (os.FileInfo, error) {
+	fi, err := d.Driver.Stat(path)
+	if err != nil || !inVersionsArea(path) {
+		return fi, err
+	}
+	return &readOnlyFileInfo{FileInfo: fi}, nil
+}
+func (d *VersionDriver) Lstat(path string) (os.FileInfo, error) {
+	fi, err := d.Driver.Lstat(path)
+	if err != nil || !inVersionsArea(path) {
+		return fi, err
+	}
+	return &readOnlyFileInfo{FileInfo: fi}, nil
+}
+
+//ReadDir returns directory content, objects of versions area are shown without write permissions
+func (d *VersionDriver) ReadDir(dirPath string) ([]os.FileInfo, error) {
+	content, err := d.Driver.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	for i, fi := range content {
+		if inVersionsArea(path.Join(dirPath, fi.Name())) {
+			content[i] = &readOnlyFileInfo{FileInfo: fi}
+		}
+	}
+	return content, nil
+}
+func (d *VersionDriver) Create(path string, exclusive bool) (io.WriteCloser, error) {
+	if err := d.readOnly("create", path); err != nil {
+		return nil, err
+	}
+	if !exclusive && d.isFile(path) {
+		if err := d.keepVersion(path, VersionsFolder); err != nil {
+			return nil, err
+		}
+	}
+	return d.Driver.Create(path, exclusive)
+}
+
+//Append keeps copy of existing file as version before it is changed (APPE)
+func (d *VersionDriver) Append(path string) (io.WriteCloser, error) {
+	if err := d.readOnly("append", path); err != nil {
+		return nil, err
+	}
+	if d.isFile(path) {
+		if err := d.keepCopy(path); err != nil {
+			return nil, err
+		}
+	}
+	return d.Driver.Append(path)
+}
+
+//WriteAt keeps copy of file as version before it is truncated and written (REST + STOR)
+func (d *VersionDriver) WriteAt(path string, offset int64) (io.WriteCloser, error) {
+	if err := d.readOnly("writeat", path); err != nil {
+		return nil, err
+	}
+	if d.isFile(path) {
+		if err := d.keepCopy(path); err != nil {
+			return nil, err
+		}
+	}
+	return d.Driver.WriteAt(path, offset)
+}
+func (d *VersionDriver) Mkdir(path string) error {
+	if err := d.readOnly("mkdir", path); err != nil {
+		return err
+	}
+	return d.Driver.Mkdir(path)
+}
+
+//Remove moves file to TrashFolder, links, empty directories and atomic upload temporary files are removed
+func (d *VersionDriver) Remove(objPath string) error {
+	if err := d.readOnly("remove", objPath); err != nil {
+		return err
+	}
+	if d.isFile(objPath) && !isPartialUpload(path.Base(objPath)) {
+		return d.keepVersion(objPath, TrashFolder)
+	}
+	return d.Driver.Remove(objPath)
+}
+
+//RemoveAll moves file or directory with its content to TrashFolder
+func (d *VersionDriver) RemoveAll(path string) error {
+	if err := d.readOnly("removeall", path); err != nil {
+		return err
+	}
+	fi, err := d.Driver.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.IsDir() || fi.Mode().IsRegular() {
+		return d.keepVersion(path, TrashFolder)
+	}
+	return d.Driver.RemoveAll(path)
+}
+
+//Rename keeps version of file replaced by newPath
+func (d *VersionDriver) Rename(oldPath string, newPath string) error {
+	if err := d.readOnly("rename", oldPath); err != nil {
+		return err
+	}
+	if err := d.readOnly("rename", newPath); err != nil {
+		return err
+	}
+	if oldPath != newPath && d.isFile(newPath) {
+		if _, err := d.Driver.Lstat(oldPath); err != nil {
+			return err
+		}
+		if err := d.keepVersion(newPath, VersionsFolder); err != nil {
+			return err
+		}
+	}
+	return d.Driver.Rename(oldPath, newPath)
+}
+func (d *VersionDriver) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	if err := d.readOnly("chtimes", path); err != nil {
+		return err
+	}
+	return d.Driver.Chtimes(path, atime, mtime)
+}
+func (d *VersionDriver) CreateTimeSupported() bool {
+	setter, ok := d.Driver.(CreateTimeSetter)
+	return ok && setter.CreateTimeSupported()
+}
+func (d *VersionDriver) SetCreateTime(path string, createTime time.Time) error {
+	setter, ok := d.Driver.(CreateTimeSetter)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := d.readOnly("setcreatetime", path); err != nil {
+		return err
+	}
+	return setter.SetCreateTime(path, createTime)
+}
+
+//keepVersion moves object to its versions folder in area (VersionsFolder or TrashFolder) and removes expired versions
+func (d *VersionDriver) keepVersion(objPath string, area string) error {
+	if err := d.keep(objPath, area); err != nil {
+		return err
+	}
+	d.prune(path.Join(area, objPath))
+	return nil
+}
+
+//keep moves object to its versions folder in area, versions are not pruned
+func (d *VersionDriver) keep(objPath string, area string) error {
+	versionPath, err := d.newVersionPath(objPath, area)
+	if err != nil {
+		return err
+	}
+	return d.move(objPath, versionPath)
+}
+
+//keepCopy copies file to its versions folder (file is changed in place) and removes expired versions
+func (d *VersionDriver) keepCopy(objPath string) error {
+	versionPath, err := d.newVersionPath(objPath, VersionsFolder)
+	if err != nil {
+		return err
+	}
+	if err := d.copyTree(objPath, versionPath); err != nil {
+		d.Driver.Remove(versionPath)
+		return err
+	}
+	d.prune(path.Dir(versionPath))
+	return nil
+}
+
+//newVersionPath creates versions folder of object in area and returns free path of new version in it
+func (d *VersionDriver) newVersionPath(objPath string, area string) (string, error) {
+	versionsPath := path.Join(area, objPath)
+	if err := d.mkdirAll(versionsPath); err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	versionPath := path.Join(versionsPath, now.Format(versionIDFormat))
+	for {
+		if _, err := d.Driver.Lstat(versionPath); os.IsNotExist(err) {
+			break
+		}
+		now = now.Add(time.Microsecond)
+		versionPath = path.Join(versionsPath, now.Format(versionIDFormat))
+	}
+	return versionPath, nil
+}
+
+//move renames object, objects of other mount are copied and removed
+func (d *VersionDriver) move(oldPath string, newPath string) error {
+	err := d.Driver.Rename(oldPath, newPath)
+	if linkErr, ok := err.(*os.LinkError); !ok || linkErr.Err != ErrCrossMount {
+		return err
+	}
+	if err := d.copyTree(oldPath, newPath); err != nil {
+		d.Driver.RemoveAll(newPath)
+		return err
+	}
+	if err := d.Driver.RemoveAll(oldPath); err != nil {
+		d.Driver.RemoveAll(newPath)
+		return err
+	}
+	return nil
+}
+
+//copyTree copies file or directory with its content, links are skipped
+func (d *VersionDriver) copyTree(oldPath string, newPath string) error {
+	fi, err := d.Driver.Lstat(oldPath)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		if err := d.Driver.Mkdir(newPath); err != nil {
+			return err
+		}
+		content, err := d.Driver.ReadDir(oldPath)
+		if err != nil {
+			return err
+		}
+		for _, child := range content {
+			if err := d.copyTree(path.Join(oldPath, child.Name()), path.Join(newPath, child.Name())); err != nil {
+				return err
+			}
+		}
+	} else if fi.Mode().IsRegular() {
+		source, err := d.Driver.Open(oldPath, 0)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		target, err := d.Driver.Create(newPath, true)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(target, source)
+		if closeErr := target.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	d.Driver.Chtimes(newPath, fi.ModTime(), fi.ModTime())
+	return nil
+}
+
+//mkdirAll creates directory with missing parents
+func (d *VersionDriver) mkdirAll(dirPath string) error {
+	fi, err := d.Driver.Stat(dirPath)
+	if err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dirPath, Err: errors.New("not a directory")}
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if err := d.mkdirAll(path.Dir(dirPath)); err != nil {
+		return err
+	}
+	if err := d.Driver.Mkdir(dirPath); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+//prune removes versions over Keep and older than MaxAge from versions folder
+func (d *VersionDriver) prune(versionsPath string) {
+	versions := d.readVersions(versionsPath, false)
+	now := time.Now()
+	for i, version := range versions {
+		expired := d.MaxAge > 0 && now.Sub(version.Time) > d.MaxAge
+		if expired || (d.Keep > 0 && len(versions)-i > d.Keep) {
+			d.Driver.RemoveAll(path.Join(versionsPath, version.ID))
+		}
+	}
+}
+
+//readVersions returns versions of versions folder from oldest to newest. Other objects in it
+//(versions folders of objects inside versioned directory) are skipped
+func (d *VersionDriver) readVersions(versionsPath string, deleted bool) []Version {
+	content, err := d.Driver.ReadDir(versionsPath)
+	if err != nil {
+		return nil
+	}
+	versions := make([]Version, 0, len(content))
+	for _, fi := range content {
+		versionTime, err := time.Parse(versionIDFormat, fi.Name())
+		if err != nil {
+			continue
+		}
+		versions = append(versions, Version{ID: fi.Name(), Time: versionTime, Size: fi.Size(), IsDir: fi.IsDir(), Deleted: deleted})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].ID < versions[j].ID })
+	return versions
+}
+
+//Versions returns previous versions and deleted copies of object from oldest to newest
+func (d *VersionDriver) Versions(objPath string) []Version {
+	versions := append(d.readVersions(path.Join(VersionsFolder, objPath), false), d.readVersions(path.Join(TrashFolder, objPath), true)...)
+	sort.Slice(versions, func(i, j int) bool { return versions[i].ID < versions[j].ID })
+	return versions
+}
+
+//Restore moves version back to object path. Current file is kept as version
+func (d *VersionDriver) Restore(objPath string, id string) error {
+	if _, err := time.Parse(versionIDFormat, id); err != nil || inVersionsArea(objPath) {
+		return &os.PathError{Op: "restore", Path: objPath, Err: os.ErrNotExist}
+	}
+	versionPath := path.Join(VersionsFolder, objPath, id)
+	if _, err := d.Driver.Lstat(versionPath); err != nil {
+		versionPath = path.Join(TrashFolder, objPath, id)
+		if _, err := d.Driver.Lstat(versionPath); err != nil {
+			return err
+		}
+	}
+	if fi, err := d.Driver.Lstat(objPath); err == nil {
+		if !fi.Mode().IsRegular() {
+			return &os.PathError{Op: "restore", Path: objPath, Err: os.ErrExist}
+		}
+		//restored version is pruned only after it is moved
+		if err := d.keep(objPath, VersionsFolder); err != nil {
+			return err
+		}
+		defer d.prune(path.Join(VersionsFolder, objPath))
+	}
+	if err := d.mkdirAll(path.Dir(objPath)); err != nil {
+		return err
+	}
+	return d.move(versionPath, objPath)
+}
+
+//Versions returns previous versions of file (or deleted directory)
+func (fsParams *FileSystem) Versions(fileName string) ([]Version, error) {
+	versionDriver, ok := fsParams.Driver.(*VersionDriver)
+	if !ok {
+		return nil, ErrVersioningDisabled
+	}
+	if len(fileName) == 0 {
+		return nil, errors.New("No file name specified")
+	}
+	return versionDriver.Versions(fsParams.VirtualPath(fileName)), nil
+}
+
+//RestoreVersion restores version of file (or deleted directory)
+func (fsParams *FileSystem) RestoreVersion(fileName string, id string) error {
+	versionDriver, ok := fsParams.Driver.(*VersionDriver)
+	if !ok {
+		return ErrVersioningDisabled
+	}
+	if len(fileName) == 0 {
+		return errors.New("No file name specified")
+	}
+	return versionDriver.Restore(fsParams.VirtualPath(fileName), id)
+}
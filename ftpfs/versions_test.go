@@ -0,0 +1,243 @@
+package ftpfs
+
+import (
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func newTestVersionDriver(t *testing.T, keep int, maxAge time.Duration) (*VersionDriver, *MemoryDriver) {
+	memory, err := NewMemoryDriver(NewMemoryStorage(0, 0), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewVersionDriver(memory, keep, maxAge), memory
+}
+
+//versionsContent returns contents of versions of file from oldest to newest
+func versionsContent(d *VersionDriver, objPath string) []string {
+	var content []string
+	for _, version := range d.Versions(objPath) {
+		area := VersionsFolder
+		if version.Deleted {
+			area = TrashFolder
+		}
+		content = append(content, readFile(d, path.Join(area, objPath, version.ID)))
+	}
+	return content
+}
+
+func TestVersionsKeep(t *testing.T) {
+	tests := []struct {
+		keep     int
+		versions []string
+	}{
+		{0, []string{"v1", "v2", "v3", "v4"}},
+		{1, []string{"v4"}},
+		{2, []string{"v3", "v4"}},
+		{10, []string{"v1", "v2", "v3", "v4"}},
+	}
+	for _, test := range tests {
+		d, _ := newTestVersionDriver(t, test.keep, 0)
+		for _, data := range []string{"v1", "v2", "v3", "v4", "v5"} {
+			writeFile(t, d, "/file.txt", data)
+		}
+		if data := readFile(d, "/file.txt"); data != "v5" {
+			t.Errorf("keep %d: current content %q", test.keep, data)
+		}
+		if versions := versionsContent(d, "/file.txt"); !equalStrings(versions, test.versions) {
+			t.Errorf("keep %d: versions %q, want %q", test.keep, versions, test.versions)
+		}
+	}
+}
+
+func TestVersionsAppendAndWriteAt(t *testing.T) {
+	d, _ := newTestVersionDriver(t, 2, 0)
+	writeFile(t, d, "/file.txt", "0123456789")
+	change := func(file io.WriteCloser, err error, data string) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write([]byte(data))
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file, err := d.Append("/file.txt")
+	change(file, err, "abc")
+	if versions := versionsContent(d, "/file.txt"); !equalStrings(versions, []string{"0123456789"}) {
+		t.Errorf("versions after APPE %q", versions)
+	}
+	file, err = d.WriteAt("/file.txt", 4)
+	change(file, err, "xyz")
+	if data := readFile(d, "/file.txt"); data != "0123xyz" {
+		t.Errorf("content after REST + STOR %q", data)
+	}
+	if versions := versionsContent(d, "/file.txt"); !equalStrings(versions, []string{"0123456789", "0123456789abc"}) {
+		t.Errorf("versions after REST + STOR %q", versions)
+	}
+	//APPE of new file has nothing to keep, Keep limit is applied to copies
+	file, err = d.Append("/new.txt")
+	change(file, err, "new")
+	if versions := d.Versions("/new.txt"); len(versions) != 0 {
+		t.Errorf("versions of appended new file %+v", versions)
+	}
+	file, err = d.Append("/file.txt")
+	change(file, err, "!")
+	if versions := versionsContent(d, "/file.txt"); !equalStrings(versions, []string{"0123456789abc", "0123xyz"}) {
+		t.Errorf("versions over Keep limit %q", versions)
+	}
+}
+
+func TestVersionsMaxAge(t *testing.T) {
+	d, memory := newTestVersionDriver(t, 0, time.Hour)
+	writeFile(t, d, "/file.txt", "current")
+	//version made two hours ago is expired, one made a minute ago is not
+	for _, age := range []time.Duration{2 * time.Hour, time.Minute} {
+		if err := d.mkdirAll(path.Join(VersionsFolder, "file.txt")); err != nil {
+			t.Fatal(err)
+		}
+		id := time.Now().Add(-age).UTC().Format(versionIDFormat)
+		writeFile(t, memory, path.Join(VersionsFolder, "file.txt", id), age.String())
+	}
+	writeFile(t, d, "/file.txt", "new")
+	if versions := versionsContent(d, "/file.txt"); !equalStrings(versions, []string{"1m0s", "current"}) {
+		t.Errorf("versions %q after pruning by age", versions)
+	}
+}
+
+func TestVersionsTrashAndRestore(t *testing.T) {
+	d, _ := newTestVersionDriver(t, 0, 0)
+	for _, dir := range []string{"/docs", "/dir", "/dir/sub"} {
+		if err := d.Mkdir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, d, "/docs/a.txt", "first")
+	writeFile(t, d, "/docs/a.txt", "second")
+	if err := d.Remove("/docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat("/docs/a.txt"); !os.IsNotExist(err) {
+		t.Fatalf("removed file exists: %v", err)
+	}
+	versions := d.Versions("/docs/a.txt")
+	if len(versions) != 2 || versions[0].Deleted || !versions[1].Deleted || versions[1].Size != int64(len("second")) {
+		t.Fatalf("versions of removed file %+v", versions)
+	}
+	//restored deleted copy, then replaced content becomes current and restored one is kept as version
+	if err := d.Restore("/docs/a.txt", versions[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if data := readFile(d, "/docs/a.txt"); data != "second" {
+		t.Errorf("restored content %q", data)
+	}
+	if err := d.Restore("/docs/a.txt", versions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if data := readFile(d, "/docs/a.txt"); data != "first" {
+		t.Errorf("restored content %q", data)
+	}
+	if versions := versionsContent(d, "/docs/a.txt"); !equalStrings(versions, []string{"second"}) {
+		t.Errorf("versions after restore %q", versions)
+	}
+	if err := d.Restore("/docs/a.txt", versions[0].ID); !os.IsNotExist(err) {
+		t.Errorf("restore of restored version returned %v", err)
+	}
+	if err := d.Restore("/docs/a.txt", "latest"); !os.IsNotExist(err) {
+		t.Errorf("restore of wrong ID returned %v", err)
+	}
+
+	//removed directory is moved to trash with its content and restored whole
+	writeFile(t, d, "/dir/sub/b.txt", "b")
+	if err := d.RemoveAll("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	dirVersions := d.Versions("/dir")
+	if len(dirVersions) != 1 || !dirVersions[0].IsDir || !dirVersions[0].Deleted {
+		t.Fatalf("versions of removed directory %+v", dirVersions)
+	}
+	if err := d.Restore("/dir", dirVersions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if data := readFile(d, "/dir/sub/b.txt"); data != "b" {
+		t.Errorf("content of restored directory %q", data)
+	}
+
+	//temporary files of atomic uploads are not kept
+	partial := path.Join("/", PartialUploadPrefix+"c.txt.1234"+PartialUploadSuffix)
+	writeFile(t, d, partial, "partial")
+	if err := d.Remove(partial); err != nil {
+		t.Fatal(err)
+	}
+	if versions := d.Versions(partial); len(versions) != 0 {
+		t.Errorf("temporary upload file kept in trash: %+v", versions)
+	}
+}
+
+func TestVersionsReadOnly(t *testing.T) {
+	d, _ := newTestVersionDriver(t, 0, 0)
+	writeFile(t, d, "/file.txt", "v1")
+	writeFile(t, d, "/file.txt", "v2")
+	id := d.Versions("/file.txt")[0].ID
+	version := path.Join(VersionsFolder, "file.txt", id)
+	write := func(op string, path string) error {
+		var file interface{ Close() error }
+		var err error
+		switch op {
+		case "create":
+			file, err = d.Create(path, false)
+		case "append":
+			file, err = d.Append(path)
+		default:
+			file, err = d.WriteAt(path, 0)
+		}
+		if err == nil {
+			file.Close()
+		}
+		return err
+	}
+	tests := []struct {
+		name string
+		do   func() error
+	}{
+		{"create", func() error { return write("create", version) }},
+		{"create new", func() error { return write("create", path.Join(TrashFolder, "new.txt")) }},
+		{"append", func() error { return write("append", version) }},
+		{"write at", func() error { return write("writeat", version) }},
+		{"mkdir", func() error { return d.Mkdir(path.Join(VersionsFolder, "dir")) }},
+		{"remove", func() error { return d.Remove(version) }},
+		{"remove all", func() error { return d.RemoveAll(VersionsFolder) }},
+		{"remove trash", func() error { return d.RemoveAll(TrashFolder) }},
+		{"rename from", func() error { return d.Rename(version, "/restored.txt") }},
+		{"rename to", func() error { return d.Rename("/file.txt", path.Join(VersionsFolder, "file.txt", "x")) }},
+		{"rename folder", func() error { return d.Rename(VersionsFolder, "/versions") }},
+		{"chtimes", func() error { return d.Chtimes(version, time.Now(), time.Now()) }},
+		{"upper case create", func() error { return write("create", "/.VERSIONS/file.txt/new") }},
+		{"upper case mkdir", func() error { return d.Mkdir("/.Trash") }},
+	}
+	for _, test := range tests {
+		if err := test.do(); !os.IsPermission(err) {
+			t.Errorf("%s: error %v, want permission error", test.name, err)
+		}
+	}
+	if data := readFile(d, version); data != "v1" {
+		t.Errorf("version content %q", data)
+	}
+	fi, err := d.Stat(version)
+	if err != nil || fi.Mode()&0222 != 0 {
+		t.Errorf("version is shown writable: %v, %v", fi.Mode(), err)
+	}
+	content, err := d.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range content {
+		if fi.Name() == ".versions" && fi.Mode()&0222 != 0 {
+			t.Errorf("versions folder is shown writable: %v", fi.Mode())
+		}
+	}
+}